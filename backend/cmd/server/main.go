@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
+	"github.com/codeMaster/backend/internal/agent"
 	"github.com/codeMaster/backend/internal/bot"
 	"github.com/codeMaster/backend/internal/codegen"
 	"github.com/codeMaster/backend/internal/config"
@@ -39,6 +41,7 @@ func main() {
 		domainItems = append(domainItems, gitops.DomainMappingItem{From: m.From, To: m.To})
 	}
 	gitops.InitDomainMapping(domainItems)
+	gitops.InitMirrorCache(cfg.Codegen.GitCacheDir)
 
 	// Database
 	db, err := gorm.Open(mysql.Open(cfg.Database.DSN()), &gorm.Config{})
@@ -54,9 +57,11 @@ func main() {
 		&model.Repository{},
 		&model.Requirement{},
 		&model.CodegenTask{},
+		&model.CodegenQueueItem{},
 		&model.CodeReview{},
 		&model.OperationLog{},
 		&model.UserSetting{},
+		&model.DiffBlob{},
 	); err != nil {
 		log.Fatalf("auto migrate: %v", err)
 	}
@@ -76,8 +81,16 @@ func main() {
 
 	// Core components
 	sseHub := sse.NewHub(rdb)
-	pool := codegen.NewPool(cfg.Codegen.MaxWorkers)
-	analyzer := codegen.NewAnalyzer(db, cfg.Encrypt.AESKey, cfg.Codegen.WorkDir)
+	pool := codegen.NewPool(db, codegen.PoolConfig{
+		MaxWorkers:    cfg.Codegen.MaxWorkers,
+		MaxPerProject: cfg.Codegen.MaxPerProject,
+		MaxPerUser:    cfg.Codegen.MaxPerUser,
+	})
+	agentBackend, err := agent.New(cfg.Codegen.Agent)
+	if err != nil {
+		log.Fatalf("agent backend: %v", err)
+	}
+	analyzer := codegen.NewAnalyzer(db, cfg.Encrypt.AESKey, cfg.Codegen.WorkDir, agentBackend)
 
 	// Ensure session dir exists if configured
 	if cfg.Codegen.SessionDir != "" {
@@ -105,18 +118,34 @@ func main() {
 
 	// Services
 	authService := service.NewAuthService(db, feishuOAuth, cfg.JWT.Secret, cfg.JWT.ExpireHours)
-	projectService := service.NewProjectService(db)
+	projectService := service.NewProjectService(db, cfg.Encrypt.AESKey)
+	projectService.SetTaskTimeout(cfg.Codegen.TimeoutMinutes)
 	repoService := service.NewRepositoryService(db, cfg.Encrypt.AESKey, analyzer)
 	reqService := service.NewRequirementService(db)
-	codegenService := service.NewCodegenService(db, pool, sseHub, cfg.Encrypt.AESKey, cfg.Codegen.MaxTurns, cfg.Codegen.TimeoutMinutes, cfg.Codegen.WorkDir, cfg.Codegen.UseLocalGit, cfg.Codegen.SessionDir)
-	reviewService := service.NewReviewService(db, cfg.Encrypt.AESKey, cfg.Codegen.WorkDir)
+	codegenService := service.NewCodegenService(db, pool, sseHub, cfg.Encrypt.AESKey, cfg.Codegen.MaxTurns, cfg.Codegen.TimeoutMinutes, cfg.Codegen.WorkDir, cfg.Codegen.UseLocalGit, cfg.Codegen.SessionDir, agentBackend)
+	reviewService := service.NewReviewService(db, cfg.Encrypt.AESKey, cfg.Codegen.WorkDir, agentBackend)
 	settingService := service.NewSettingService(db, cfg.Encrypt.AESKey)
 
 	// Inject notifiers
 	codegenService.SetNotifier(notifier)
 	codegenService.SetDocClient(docClient)
+	codegenService.SetBudget(cfg.Codegen.Budget)
+	codegenService.SetVerify(cfg.Codegen.Verify)
+	codegenService.SetSandbox(cfg.Codegen.Sandbox)
 	reviewService.SetNotifier(notifier)
 
+	// Recover interrupted codegen tasks and start consuming the persisted queue
+	codegenService.Start()
+
+	// Clean up finished codegen workspaces
+	workspaceGC := codegen.NewWorkspaceGC(db, cfg.Codegen.WorkDir, codegen.WorkspaceGCConfig{
+		Retention:  time.Duration(cfg.Codegen.Workspace.RetentionHours) * time.Hour,
+		QuotaBytes: int64(cfg.Codegen.Workspace.QuotaGB * (1 << 30)),
+		Interval:   time.Duration(cfg.Codegen.Workspace.GCIntervalMinutes) * time.Minute,
+	})
+	workspaceGC.Start()
+	defer workspaceGC.Stop()
+
 	// AI Chat client
 	var aiChat *bot.AIChatClient
 	if cfg.AIChat.APIKey != "" {
@@ -149,7 +178,7 @@ func main() {
 	projectHandler := handler.NewProjectHandler(projectService)
 	repoHandler := handler.NewRepositoryHandler(repoService, projectService)
 	requirementHandler := handler.NewRequirementHandler(reqService, projectService, notifier, rdb)
-	codegenHandler := handler.NewCodegenHandler(codegenService, reqService, repoService, reviewService)
+	codegenHandler := handler.NewCodegenHandler(codegenService, reqService, repoService, reviewService, authService)
 	reviewHandler := handler.NewReviewHandler(reviewService)
 	dashboardHandler := handler.NewDashboardHandler(db)
 	feishuHandler := handler.NewFeishuHandler(docClient)
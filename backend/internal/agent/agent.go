@@ -0,0 +1,142 @@
+// Package agent abstracts the coding agent process (Claude Code CLI or any
+// command speaking the same stream-json protocol) behind a Backend interface.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/codeMaster/backend/internal/config"
+	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/internal/sandbox"
+)
+
+// ErrResumeUnsupported is returned by backends that cannot continue a previous session.
+var ErrResumeUnsupported = errors.New("agent backend does not support session resume")
+
+// ErrInputUnsupported is returned by Send on sessions that were not started
+// interactively or whose backend cannot take input while running.
+var ErrInputUnsupported = errors.New("agent session does not accept input")
+
+// ErrSessionFinished is returned by Send once the agent has finished its work.
+var ErrSessionFinished = errors.New("agent session has finished")
+
+// Request describes a single agent invocation.
+type Request struct {
+	Prompt       string
+	WorkDir      string
+	AllowedTools []string
+	MaxTurns     int
+	Model        string
+	APIKey       string
+	BaseURL      string
+	HomeDir      string // persisted HOME for session data; empty = inherit
+	TimeoutMin   int    // hint passed to the agent; the caller's context enforces the deadline
+	Env          []string
+	Sandbox      *sandbox.Policy // nil = run unconfined
+	Interactive  bool            // keep the session open for follow-up messages (see Session.Send)
+	// PermissionPrompt routes calls of tools that are not pre-allowed to the
+	// caller as permission_request events instead of refusing them. Requires Interactive.
+	PermissionPrompt bool
+
+	// Set from the project's tool policy, see ResolveTools
+	DisallowedTools []string
+	MCPServers      map[string]model.MCPServer
+}
+
+// Result summarizes a finished session.
+type Result struct {
+	SessionID string
+	CostUSD   float64
+	NumTurns  int
+	Text      string // final result text from the "result" event
+	Stderr    string
+	LastLines []string // last raw stdout lines, for debugging failures
+}
+
+// Session is a running agent process.
+type Session interface {
+	// Events streams parsed events until the agent's stdout is closed.
+	// Callers must drain it before calling Wait.
+	Events() <-chan *Event
+	// Wait blocks until the process exits and returns the collected result.
+	Wait() (*Result, error)
+	// Send delivers a follow-up user message to a running interactive session.
+	// It returns ErrInputUnsupported or ErrSessionFinished when the message
+	// cannot be delivered.
+	Send(text string) error
+	// RespondPermission answers a permission_request event. A denied tool
+	// call is refused with message and the agent carries on.
+	RespondPermission(requestID string, allow bool, input json.RawMessage, message string) error
+	// Cancel interrupts the agent, killing it if it does not exit promptly.
+	Cancel() error
+	PID() int
+	// Command returns the argv used to start the agent, for logging.
+	Command() []string
+}
+
+// Backend starts agent sessions.
+type Backend interface {
+	Name() string
+	Start(ctx context.Context, req Request) (Session, error)
+	// Resume continues a previous session with a new prompt.
+	Resume(ctx context.Context, sessionID string, req Request) (Session, error)
+}
+
+// SessionRelocator is implemented by backends whose sessions are bound to the
+// working directory they were started in. RelocateSession makes sessionID,
+// recorded under fromDir in fromHome, resumable from toDir in toHome.
+type SessionRelocator interface {
+	RelocateSession(fromHome, toHome, sessionID, fromDir, toDir string) error
+}
+
+// PermissionPrompter is implemented by backends that can hand tool permission
+// decisions to the caller (see Request.PermissionPrompt).
+type PermissionPrompter interface {
+	SupportsPermissionPrompt() bool
+}
+
+// New builds the backend selected in the codegen agent config.
+func New(cfg config.AgentConfig) (Backend, error) {
+	switch cfg.Backend {
+	case "", "claude":
+		return NewClaudeBackend(cfg.Command), nil
+	case "command":
+		if cfg.Command == "" {
+			return nil, fmt.Errorf("agent backend %q requires a command", cfg.Backend)
+		}
+		return NewCommandBackend(CommandConfig{
+			Name:        cfg.Name,
+			Command:     cfg.Command,
+			Args:        cfg.Args,
+			ResumeArgs:  cfg.ResumeArgs,
+			PromptStdin: cfg.PromptStdin,
+			Env:         cfg.Env,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown agent backend: %s", cfg.Backend)
+	}
+}
+
+// Run starts (or resumes, when sessionID is set) a session, drains its events
+// through onEvent and waits for it to finish. onEvent may be nil.
+func Run(ctx context.Context, b Backend, sessionID string, req Request, onEvent func(*Event)) (*Result, error) {
+	var sess Session
+	var err error
+	if sessionID != "" {
+		sess, err = b.Resume(ctx, sessionID, req)
+	} else {
+		sess, err = b.Start(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for ev := range sess.Events() {
+		if onEvent != nil {
+			onEvent(ev)
+		}
+	}
+	return sess.Wait()
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/codeMaster/backend/internal/sandbox"
+)
+
+// ClaudeBackend runs the Claude Code CLI in non-interactive stream-json mode.
+type ClaudeBackend struct {
+	binary string
+}
+
+// NewClaudeBackend returns a backend invoking the given claude binary ("claude" if empty).
+func NewClaudeBackend(binary string) *ClaudeBackend {
+	if binary == "" {
+		binary = "claude"
+	}
+	return &ClaudeBackend{binary: binary}
+}
+
+func (b *ClaudeBackend) Name() string { return "claude" }
+
+func (b *ClaudeBackend) SupportsPermissionPrompt() bool { return true }
+
+func (b *ClaudeBackend) Start(ctx context.Context, req Request) (Session, error) {
+	return b.start(ctx, nil, req)
+}
+
+func (b *ClaudeBackend) Resume(ctx context.Context, sessionID string, req Request) (Session, error) {
+	return b.start(ctx, []string{"--resume", sessionID}, req)
+}
+
+func (b *ClaudeBackend) start(ctx context.Context, prefix []string, req Request) (Session, error) {
+	args := append(prefix, "-p")
+	if req.Interactive {
+		// The prompt and any follow-up messages arrive on stdin
+		args = append(args, "--input-format", "stream-json")
+		if req.PermissionPrompt {
+			args = append(args, "--permission-prompt-tool", "stdio")
+		}
+	} else {
+		args = append(args, req.Prompt)
+	}
+	args = append(args, "--output-format", "stream-json", "--verbose")
+	if len(req.AllowedTools) > 0 {
+		args = append(args, "--allowedTools", strings.Join(req.AllowedTools, ","))
+	}
+	if len(req.DisallowedTools) > 0 {
+		args = append(args, "--disallowedTools", strings.Join(req.DisallowedTools, ","))
+	}
+	mcpArg := -1
+	if len(req.MCPServers) > 0 {
+		// Only the configured servers; never ones declared by the repository's .mcp.json
+		args = append(args, "--mcp-config", mcpConfig(req.MCPServers, false), "--strict-mcp-config")
+		mcpArg = len(args) - 2
+	}
+	if req.MaxTurns > 0 {
+		args = append(args, "--max-turns", strconv.Itoa(req.MaxTurns))
+	}
+	if req.Model != "" {
+		args = append(args, "--model", req.Model)
+	}
+
+	env := append(sandbox.Env(req.Sandbox, os.Environ()), req.Env...)
+	if req.TimeoutMin > 0 {
+		env = append(env, fmt.Sprintf("CLAUDE_CODE_MAX_TIMEOUT=%d", req.TimeoutMin*60*1000))
+	}
+	if req.HomeDir != "" {
+		env = append(env, "HOME="+req.HomeDir)
+	}
+	if req.APIKey != "" {
+		env = append(env, "ANTHROPIC_API_KEY="+req.APIKey)
+	}
+	if req.BaseURL != "" {
+		env = append(env, "ANTHROPIC_BASE_URL="+req.BaseURL)
+	}
+
+	sess, err := startProcess(ctx, req, b.binary, args, env, "", req.Interactive)
+	if err != nil {
+		return nil, err
+	}
+	if mcpArg >= 0 {
+		// The logged command must not expose MCP server credentials
+		sess.argv = append([]string{}, sess.argv...)
+		sess.argv[len(sess.argv)-len(args)+mcpArg] = mcpConfig(req.MCPServers, true)
+	}
+	if req.Interactive {
+		if err := sess.Send(req.Prompt); err != nil {
+			sess.Cancel()
+			for range sess.Events() {
+			}
+			sess.Wait()
+			return nil, err
+		}
+	}
+	return sess, nil
+}
+
+// nonAlnum matches the characters Claude Code replaces when naming a
+// project's session directory after its working directory.
+var nonAlnum = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// RelocateSession copies the session transcript from fromDir's project
+// directory in fromHome to toDir's in toHome, since `--resume` only looks up
+// sessions of the current working directory. The original is kept so the
+// session can still be resumed from older workspaces.
+func (b *ClaudeBackend) RelocateSession(fromHome, toHome, sessionID, fromDir, toDir string) error {
+	if fromHome == toHome && fromDir == toDir {
+		return nil
+	}
+	userHome, err := os.UserHomeDir()
+	if err != nil && (fromHome == "" || toHome == "") {
+		return err
+	}
+	if fromHome == "" {
+		fromHome = userHome
+	}
+	if toHome == "" {
+		toHome = userHome
+	}
+	name := sessionID + ".jsonl"
+	src := filepath.Join(fromHome, ".claude", "projects", nonAlnum.ReplaceAllString(fromDir, "-"), name)
+	dstDir := filepath.Join(toHome, ".claude", "projects", nonAlnum.ReplaceAllString(toDir, "-"))
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("read session %s: %w", sessionID, err)
+	}
+	if err := os.MkdirAll(dstDir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dstDir, name), data, 0o600)
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/codeMaster/backend/internal/sandbox"
+)
+
+// CommandConfig configures a generic agent command that emits Claude-compatible
+// stream-json on stdout. Args may contain the placeholders {prompt}, {model},
+// {max_turns}, {allowed_tools}, {disallowed_tools}, {mcp_config} and
+// {work_dir}; ResumeArgs may additionally use {session_id}. An empty
+// ResumeArgs means the command cannot resume sessions.
+type CommandConfig struct {
+	Name        string
+	Command     string
+	Args        []string
+	ResumeArgs  []string
+	PromptStdin bool // write the prompt to stdin instead of substituting {prompt}
+	Env         []string
+}
+
+// CommandBackend runs an arbitrary stream-json speaking command, e.g. another
+// coding agent or a local scripted stand-in used for testing.
+type CommandBackend struct {
+	cfg CommandConfig
+}
+
+func NewCommandBackend(cfg CommandConfig) *CommandBackend {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Command
+	}
+	return &CommandBackend{cfg: cfg}
+}
+
+func (b *CommandBackend) Name() string { return b.cfg.Name }
+
+func (b *CommandBackend) Start(ctx context.Context, req Request) (Session, error) {
+	return b.start(ctx, b.cfg.Args, "", req)
+}
+
+func (b *CommandBackend) Resume(ctx context.Context, sessionID string, req Request) (Session, error) {
+	if len(b.cfg.ResumeArgs) == 0 {
+		return nil, ErrResumeUnsupported
+	}
+	return b.start(ctx, b.cfg.ResumeArgs, sessionID, req)
+}
+
+func (b *CommandBackend) start(ctx context.Context, argTemplate []string, sessionID string, req Request) (Session, error) {
+	vars := map[string]string{
+		"{prompt}":           req.Prompt,
+		"{model}":            req.Model,
+		"{max_turns}":        strconv.Itoa(req.MaxTurns),
+		"{allowed_tools}":    strings.Join(req.AllowedTools, ","),
+		"{disallowed_tools}": strings.Join(req.DisallowedTools, ","),
+		"{mcp_config}":       "",
+		"{work_dir}":         req.WorkDir,
+		"{session_id}":       sessionID,
+	}
+	if len(req.MCPServers) > 0 {
+		vars["{mcp_config}"] = mcpConfig(req.MCPServers, false)
+	}
+	if b.cfg.PromptStdin {
+		vars["{prompt}"] = ""
+	}
+	// One pass, so placeholders inside substituted values (e.g. the prompt) stay as they are
+	pairs := make([]string, 0, 2*len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, k, v)
+	}
+	r := strings.NewReplacer(pairs...)
+	args := make([]string, 0, len(argTemplate))
+	for _, a := range argTemplate {
+		args = append(args, r.Replace(a))
+	}
+
+	env := append(sandbox.Env(req.Sandbox, os.Environ()), b.cfg.Env...)
+	env = append(env, req.Env...)
+	env = append(env,
+		"AGENT_WORK_DIR="+req.WorkDir,
+		"AGENT_MODEL="+req.Model,
+		"AGENT_SESSION_ID="+sessionID,
+	)
+	if req.HomeDir != "" {
+		env = append(env, "HOME="+req.HomeDir)
+	}
+	if req.APIKey != "" {
+		env = append(env, "AGENT_API_KEY="+req.APIKey)
+	}
+	if req.BaseURL != "" {
+		env = append(env, "AGENT_BASE_URL="+req.BaseURL)
+	}
+
+	stdin := ""
+	if b.cfg.PromptStdin {
+		stdin = req.Prompt
+	}
+	return startProcess(ctx, req, b.cfg.Command, args, env, stdin, false)
+}
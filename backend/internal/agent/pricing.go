@@ -0,0 +1,81 @@
+package agent
+
+import "strings"
+
+// modelPrice is the list price in USD per million tokens.
+type modelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// modelPrices is matched by substring against the model name reported on
+// assistant messages. Unknown models are priced as sonnet.
+var modelPrices = []struct {
+	match string
+	price modelPrice
+}{
+	{"opus", modelPrice{Input: 15, Output: 75}},
+	{"sonnet", modelPrice{Input: 3, Output: 15}},
+	{"haiku", modelPrice{Input: 0.8, Output: 4}},
+}
+
+// EstimateCostUSD estimates the cost of one assistant message from its token usage.
+// It is only used for live budget tracking; the "result" event's total_cost_usd
+// remains the authoritative figure.
+func EstimateCostUSD(model string, u *Usage) float64 {
+	if u == nil {
+		return 0
+	}
+	price := modelPrices[1].price
+	for _, p := range modelPrices {
+		if strings.Contains(strings.ToLower(model), p.match) {
+			price = p.price
+			break
+		}
+	}
+	// Cache writes cost 1.25x the input price, cache reads 0.1x
+	input := float64(u.InputTokens) + float64(u.CacheCreationInputTokens)*1.25 + float64(u.CacheReadInputTokens)*0.1
+	return (input*price.Input + float64(u.OutputTokens)*price.Output) / 1e6
+}
+
+// CostMeter accumulates the spend of one or more consecutive sessions from their events.
+type CostMeter struct {
+	perMessage map[string]float64
+	total      float64
+}
+
+func NewCostMeter() *CostMeter {
+	return &CostMeter{perMessage: make(map[string]float64)}
+}
+
+// Add records the usage carried by ev and returns the running total.
+// Repeated events of the same message replace rather than add to its cost.
+func (m *CostMeter) Add(ev *Event) float64 {
+	if ev.Type == "result" && ev.CostUSD > 0 {
+		// The run is over: swap its estimate for the reported cost
+		for _, c := range m.perMessage {
+			m.total -= c
+		}
+		m.total += ev.CostUSD
+		m.perMessage = make(map[string]float64)
+		return m.total
+	}
+	if ev.Usage == nil {
+		return m.total
+	}
+	cost := EstimateCostUSD(ev.Model, ev.Usage)
+	m.total += cost - m.perMessage[ev.MessageID]
+	m.perMessage[ev.MessageID] = cost
+	return m.total
+}
+
+// AddSettled records cost that is already final, e.g. from an earlier run
+// of the same task.
+func (m *CostMeter) AddSettled(cost float64) {
+	m.total += cost
+}
+
+// Total returns the spend recorded so far.
+func (m *CostMeter) Total() float64 {
+	return m.total
+}
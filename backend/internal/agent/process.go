@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codeMaster/backend/internal/sandbox"
+)
+
+// processSession runs an agent as a child process and parses its stream-json stdout.
+type processSession struct {
+	cmd     *exec.Cmd
+	argv    []string
+	events  chan *Event
+	cleanup func()
+
+	stderrBuf    bytes.Buffer
+	readers      sync.WaitGroup
+	mu           sync.Mutex
+	result       Result
+	reportedCost float64 // cumulative cost of the last result event
+	cancelOnce   sync.Once
+
+	// Interactive sessions read stream-json user messages from stdin. Input
+	// is closed once every message sent has been answered, which lets the
+	// agent exit.
+	stdin     io.WriteCloser
+	sent      int
+	answered  int
+	idleTimer *time.Timer
+}
+
+// inputIdleTimeout bounds how long an interactive session waits for the
+// answer to a message that the agent may have folded into the previous turn.
+const inputIdleTimeout = time.Minute
+
+// startProcess runs name in req.WorkDir, inside req.Sandbox when one is set.
+// When interactive is set, stdin stays open for messages written with Send
+// and stdin is ignored.
+func startProcess(ctx context.Context, req Request, name string, args []string, env []string, stdin string, interactive bool) (*processSession, error) {
+	var writable []string
+	if req.HomeDir != "" {
+		writable = append(writable, req.HomeDir)
+	}
+	cmd, cleanup, err := sandbox.Command(ctx, req.Sandbox, sandbox.Spec{
+		Kind:     sandbox.KindAgent,
+		Name:     name,
+		Args:     args,
+		Dir:      req.WorkDir,
+		Env:      env,
+		Writable: writable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var input io.WriteCloser
+	if interactive {
+		if input, err = cmd.StdinPipe(); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("获取 stdin 失败: %w", err)
+		}
+	} else if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("获取 stdout 失败: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("获取 stderr 失败: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	s := &processSession{
+		cmd:     cmd,
+		argv:    cmd.Args,
+		events:  make(chan *Event, 64),
+		cleanup: cleanup,
+		stdin:   input,
+	}
+
+	s.readers.Add(2)
+	go func() {
+		defer s.readers.Done()
+		io.Copy(&s.stderrBuf, stderr)
+	}()
+	go func() {
+		defer s.readers.Done()
+		defer close(s.events)
+		s.readStdout(stdout)
+		s.closeInput()
+	}()
+	return s, nil
+}
+
+func (s *processSession) readStdout(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		s.mu.Lock()
+		// Keep last 20 raw lines for debugging on failure
+		s.result.LastLines = append(s.result.LastLines, line)
+		if len(s.result.LastLines) > 20 {
+			s.result.LastLines = s.result.LastLines[1:]
+		}
+		s.mu.Unlock()
+
+		event, err := ParseStreamJSON(line)
+		if err != nil || event == nil {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+			s.idleTimer = nil
+		}
+		switch event.Type {
+		case "system_init":
+			s.result.SessionID = event.SessionID
+		case "result":
+			// The reported cost is cumulative over the process; events carry
+			// the cost of this turn so several results add up correctly
+			total := event.CostUSD
+			if total >= s.reportedCost {
+				event.CostUSD = total - s.reportedCost
+				s.reportedCost = total
+			}
+			s.result.CostUSD = total
+			s.result.Text = event.Content
+			s.result.NumTurns = event.NumTurns
+			if event.SessionID != "" {
+				s.result.SessionID = event.SessionID
+			}
+			s.answered++
+			if s.stdin != nil {
+				if s.answered >= s.sent {
+					s.closeInputLocked()
+				} else {
+					s.idleTimer = time.AfterFunc(inputIdleTimeout, s.closeInput)
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		s.events <- event
+	}
+}
+
+// Send writes a user message to the agent's stream-json input.
+func (s *processSession) Send(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stdin == nil {
+		if s.sent > 0 {
+			return ErrSessionFinished
+		}
+		return ErrInputUnsupported
+	}
+	line, err := json.Marshal(map[string]interface{}{
+		"type": "user",
+		"message": map[string]interface{}{
+			"role":    "user",
+			"content": []map[string]string{{"type": "text", "text": text}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.stdin.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("写入 stdin 失败: %w", err)
+	}
+	s.sent++
+	return nil
+}
+
+// RespondPermission writes the answer to a can_use_tool control request.
+func (s *processSession) RespondPermission(requestID string, allow bool, input json.RawMessage, message string) error {
+	decision := map[string]interface{}{"behavior": "deny", "message": message}
+	if allow {
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		decision = map[string]interface{}{"behavior": "allow", "updatedInput": input}
+	}
+	line, err := json.Marshal(map[string]interface{}{
+		"type": "control_response",
+		"response": map[string]interface{}{
+			"subtype":    "success",
+			"request_id": requestID,
+			"response":   decision,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stdin == nil {
+		return ErrSessionFinished
+	}
+	if _, err := s.stdin.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("写入 stdin 失败: %w", err)
+	}
+	return nil
+}
+
+func (s *processSession) closeInput() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeInputLocked()
+}
+
+func (s *processSession) closeInputLocked() {
+	if s.stdin != nil {
+		s.stdin.Close()
+		s.stdin = nil
+	}
+}
+
+func (s *processSession) Events() <-chan *Event {
+	return s.events
+}
+
+func (s *processSession) Wait() (*Result, error) {
+	// Wait for the pipe readers to finish before calling cmd.Wait
+	s.readers.Wait()
+	err := s.cmd.Wait()
+	s.cleanup()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.result
+	res.Stderr = strings.TrimSpace(s.stderrBuf.String())
+	return &res, err
+}
+
+func (s *processSession) Cancel() error {
+	if s.cmd.Process == nil {
+		return fmt.Errorf("task not running")
+	}
+	s.cancelOnce.Do(func() {
+		process := s.cmd.Process
+		process.Signal(os.Interrupt)
+		time.AfterFunc(3*time.Second, func() {
+			process.Kill()
+		})
+	})
+	return nil
+}
+
+func (s *processSession) PID() int {
+	if s.cmd.Process == nil {
+		return 0
+	}
+	return s.cmd.Process.Pid
+}
+
+func (s *processSession) Command() []string {
+	return s.argv
+}
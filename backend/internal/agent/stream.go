@@ -1,10 +1,11 @@
-package codegen
+package agent
 
 import (
 	"encoding/json"
 )
 
-type StreamEvent struct {
+// Event is a normalized agent stream event.
+type Event struct {
 	Type      string          `json:"type"`
 	SubType   string          `json:"subtype,omitempty"`
 	Content   string          `json:"content,omitempty"`
@@ -16,7 +17,23 @@ type StreamEvent struct {
 	Summary   string          `json:"summary,omitempty"`
 	CostUSD   float64         `json:"cost_usd,omitempty"`
 	SessionID string          `json:"session_id,omitempty"`
+	NumTurns  int             `json:"num_turns,omitempty"`
 	FilePath  string          `json:"-"`
+	RequestID string          `json:"request_id,omitempty"` // permission_request: answer with Session.RespondPermission
+
+	// Token usage of the assistant message this event belongs to. Several
+	// events may share one MessageID and carry the same usage.
+	MessageID string `json:"-"`
+	Model     string `json:"-"`
+	Usage     *Usage `json:"-"`
+}
+
+// Usage is the token accounting reported on assistant messages.
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
 }
 
 // rawStreamLine matches the actual Claude Code --output-format stream-json --verbose format.
@@ -28,6 +45,7 @@ type StreamEvent struct {
 //	{"type":"assistant","message":{"content":[{"type":"tool_use","id":"...","name":"Read","input":{...}}],...}}
 //	{"type":"user","message":{"content":[{"tool_use_id":"...","type":"tool_result","content":"..."}]},...}
 //	{"type":"result","subtype":"success","total_cost_usd":0.15,"result":"...","num_turns":2}
+//	{"type":"control_request","request_id":"...","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{...}}}
 type rawStreamLine struct {
 	Type    string `json:"type"`
 	SubType string `json:"subtype,omitempty"`
@@ -42,11 +60,24 @@ type rawStreamLine struct {
 	TotalCostUSD float64 `json:"total_cost_usd,omitempty"`
 	Result       string  `json:"result,omitempty"`
 	NumTurns     int     `json:"num_turns,omitempty"`
+
+	// "control_request" fields, sent with --permission-prompt-tool stdio
+	RequestID string             `json:"request_id,omitempty"`
+	Request   *rawControlRequest `json:"request,omitempty"`
+}
+
+type rawControlRequest struct {
+	Subtype  string          `json:"subtype"`
+	ToolName string          `json:"tool_name,omitempty"`
+	Input    json.RawMessage `json:"input,omitempty"`
 }
 
 type rawMessage struct {
+	ID      string              `json:"id,omitempty"`
+	Model   string              `json:"model,omitempty"`
 	Role    string              `json:"role,omitempty"`
 	Content []rawContentElement `json:"content,omitempty"`
+	Usage   *Usage              `json:"usage,omitempty"`
 }
 
 type rawContentElement struct {
@@ -65,13 +96,15 @@ type rawContentElement struct {
 	Content   json.RawMessage `json:"content,omitempty"`
 }
 
-func ParseStreamJSON(line string) (*StreamEvent, error) {
+// ParseStreamJSON parses one line of stream-json output into an Event.
+// Returns nil for lines that carry nothing of interest.
+func ParseStreamJSON(line string) (*Event, error) {
 	var raw rawStreamLine
 	if err := json.Unmarshal([]byte(line), &raw); err != nil {
 		return nil, err
 	}
 
-	event := &StreamEvent{}
+	event := &Event{}
 
 	switch raw.Type {
 	case "assistant":
@@ -97,6 +130,9 @@ func ParseStreamJSON(line string) (*StreamEvent, error) {
 		default:
 			return nil, nil
 		}
+		event.MessageID = raw.Message.ID
+		event.Model = raw.Message.Model
+		event.Usage = raw.Message.Usage
 
 	case "user":
 		// Tool result: {"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"...","content":"..."}]}}
@@ -124,6 +160,19 @@ func ParseStreamJSON(line string) (*StreamEvent, error) {
 		event.Type = "result"
 		event.CostUSD = raw.TotalCostUSD
 		event.Content = raw.Result
+		event.NumTurns = raw.NumTurns
+		event.SessionID = raw.SessionID
+
+	case "control_request":
+		// The agent asks whether it may run a tool that is not pre-allowed
+		if raw.Request == nil || raw.Request.Subtype != "can_use_tool" || raw.RequestID == "" {
+			return nil, nil
+		}
+		event.Type = "permission_request"
+		event.RequestID = raw.RequestID
+		event.ToolName = raw.Request.ToolName
+		event.Input = raw.Request.Input
+		event.FilePath = extractFilePath(raw.Request.Input)
 
 	default:
 		// system events — capture session_id from init
@@ -155,7 +204,7 @@ func extractFilePath(input json.RawMessage) string {
 	return ""
 }
 
-func (e *StreamEvent) ToSSEData() map[string]interface{} {
+func (e *Event) ToSSEData() map[string]interface{} {
 	data := map[string]interface{}{
 		"type": e.Type,
 	}
@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/codeMaster/backend/internal/model"
+)
+
+// Default tool sets. Coding runs may edit files and run commands; planning,
+// analysis and review only read the repository.
+var (
+	CodingTools   = []string{"Read", "Write", "Edit", "Glob", "Grep", "Bash"}
+	ReadOnlyTools = []string{"Read", "Glob", "Grep"}
+)
+
+// mcpServerName restricts server names to what is valid in a tool name
+// (mcp__<server>__<tool>).
+var mcpServerName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ToolSet is the effective tool configuration of one agent invocation.
+type ToolSet struct {
+	Allowed    []string
+	Disallowed []string
+	MCPServers map[string]model.MCPServer
+}
+
+// ResolveTools applies a project's tool policy (nil = defaults) to the
+// default tool set of a coding or read-only run.
+//
+//   - allowed_tools replaces the coding tools; read-only runs keep only the
+//     read-only tools it contains.
+//   - disallowed_tools are removed from the allowed list and passed on as
+//     deny rules, so patterns such as "Bash(rm:*)" work too.
+//   - bash_commands narrows Bash to the listed command prefixes.
+//   - every tool of the extra MCP servers is allowed; read-only runs only
+//     get the servers marked read_only.
+func ResolveTools(p *model.ToolPolicy, readOnly bool) ToolSet {
+	base := CodingTools
+	if readOnly {
+		base = ReadOnlyTools
+	}
+	if p == nil {
+		return ToolSet{Allowed: append([]string{}, base...)}
+	}
+
+	allowed := base
+	if len(p.AllowedTools) > 0 {
+		if readOnly {
+			allowed = intersect(base, p.AllowedTools)
+		} else {
+			allowed = p.AllowedTools
+		}
+	}
+	denied := make(map[string]bool, len(p.DisallowedTools))
+	for _, t := range p.DisallowedTools {
+		denied[t] = true
+	}
+
+	set := ToolSet{Disallowed: append([]string{}, p.DisallowedTools...)}
+	for _, t := range allowed {
+		if denied[t] {
+			continue
+		}
+		if t == "Bash" && len(p.BashCommands) > 0 {
+			for _, c := range p.BashCommands {
+				set.Allowed = append(set.Allowed, fmt.Sprintf("Bash(%s:*)", strings.TrimSpace(c)))
+			}
+			continue
+		}
+		set.Allowed = append(set.Allowed, t)
+	}
+	for _, name := range sortedKeys(p.MCPServers) {
+		srv := p.MCPServers[name]
+		if readOnly && !srv.ReadOnly {
+			continue
+		}
+		if set.MCPServers == nil {
+			set.MCPServers = make(map[string]model.MCPServer)
+		}
+		set.MCPServers[name] = srv
+		if !denied["mcp__"+name] {
+			set.Allowed = append(set.Allowed, "mcp__"+name)
+		}
+	}
+	return set
+}
+
+// Apply copies the tool set into req.
+func (t ToolSet) Apply(req *Request) {
+	req.AllowedTools = t.Allowed
+	req.DisallowedTools = t.Disallowed
+	req.MCPServers = t.MCPServers
+}
+
+// Summary describes the tool set for startup logs. MCP server credentials
+// are left out.
+func (t ToolSet) Summary() map[string]interface{} {
+	summary := map[string]interface{}{"allowed": t.Allowed}
+	if len(t.Disallowed) > 0 {
+		summary["disallowed"] = t.Disallowed
+	}
+	if len(t.MCPServers) > 0 {
+		summary["mcp_servers"] = sortedKeys(t.MCPServers)
+	}
+	return summary
+}
+
+// ValidateToolPolicy checks a project tool policy before it is stored.
+// taskTimeoutMin is the project's effective task timeout, which approvals
+// can't outlast; 0 skips that check.
+func ValidateToolPolicy(p *model.ToolPolicy, taskTimeoutMin int) error {
+	for _, list := range [][]string{p.AllowedTools, p.DisallowedTools, p.BashCommands} {
+		for _, t := range list {
+			if strings.TrimSpace(t) == "" || strings.ContainsAny(t, ",\n") {
+				return fmt.Errorf("无效的工具配置: %q", t)
+			}
+		}
+	}
+	if a := p.Approval; a != nil {
+		if a.TimeoutMinutes < 0 {
+			return fmt.Errorf("审批超时时间不能为负数")
+		}
+		if taskTimeoutMin > 0 && a.TimeoutMinutes > taskTimeoutMin {
+			return fmt.Errorf("审批超时时间不能超过任务超时时间 (%d 分钟)", taskTimeoutMin)
+		}
+		for _, r := range append(append([]string{}, a.Commands...), a.Paths...) {
+			if strings.TrimSpace(r) == "" {
+				return fmt.Errorf("审批规则不能为空")
+			}
+		}
+	}
+	for name, srv := range p.MCPServers {
+		if !mcpServerName.MatchString(name) {
+			return fmt.Errorf("MCP server 名称只能包含字母、数字、_ 和 -: %q", name)
+		}
+		switch srv.Type {
+		case "", "stdio":
+			if srv.Command == "" {
+				return fmt.Errorf("MCP server %s 缺少 command", name)
+			}
+		case "sse", "http":
+			if srv.URL == "" {
+				return fmt.Errorf("MCP server %s 缺少 url", name)
+			}
+		default:
+			return fmt.Errorf("MCP server %s 的 type 必须为 stdio / sse / http", name)
+		}
+	}
+	return nil
+}
+
+// mcpConfig renders servers in the format of Claude Code's --mcp-config.
+// With redact set, env and header values are masked for logging.
+func mcpConfig(servers map[string]model.MCPServer, redact bool) string {
+	out := make(map[string]model.MCPServer, len(servers))
+	for name, srv := range servers {
+		srv.ReadOnly = false // ours, not part of the format
+		if redact {
+			srv.Env = maskValues(srv.Env)
+			srv.Headers = maskValues(srv.Headers)
+		}
+		out[name] = srv
+	}
+	b, _ := json.Marshal(map[string]interface{}{"mcpServers": out})
+	return string(b)
+}
+
+func maskValues(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return m
+	}
+	masked := make(map[string]string, len(m))
+	for k := range m {
+		masked[k] = "***"
+	}
+	return masked
+}
+
+func intersect(a, b []string) []string {
+	keep := make(map[string]bool, len(b))
+	for _, s := range b {
+		keep[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if keep[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]model.MCPServer) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
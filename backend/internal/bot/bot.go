@@ -9,6 +9,7 @@ import (
 	"github.com/codeMaster/backend/pkg/feishu"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
+	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher/callback"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 	larkws "github.com/larksuite/oapi-sdk-go/v3/ws"
 	"gorm.io/gorm"
@@ -91,6 +92,18 @@ func New(deps BotDeps) *Bot {
 		return nil
 	})
 
+	eventDispatcher.OnP2CardActionTrigger(func(ctx context.Context, event *callback.CardActionTriggerEvent) (*callback.CardActionTriggerResponse, error) {
+		if event == nil || event.Event == nil || event.Event.Operator == nil || event.Event.Action == nil {
+			return nil, nil
+		}
+		ok, msg := handler.HandleCardAction(event.Event.Operator.OpenID, event.Event.Action.Value)
+		toastType := "success"
+		if !ok {
+			toastType = "error"
+		}
+		return &callback.CardActionTriggerResponse{Toast: &callback.Toast{Type: toastType, Content: msg}}, nil
+	})
+
 	wsClient := larkws.NewClient(
 		deps.AppID,
 		deps.AppSecret,
@@ -3,6 +3,7 @@ package bot
 import (
 	"encoding/json"
 	"log"
+	"strconv"
 	"strings"
 
 	"github.com/codeMaster/backend/internal/model"
@@ -19,6 +20,7 @@ type MessageHandler struct {
 	projectSvc *service.ProjectService
 	reqSvc     *service.RequirementService
 	reviewSvc  *service.ReviewService
+	codegenSvc *service.CodegenService
 	cmdHandler *CommandHandler
 }
 
@@ -40,6 +42,7 @@ func NewMessageHandler(
 		projectSvc: projectSvc,
 		reqSvc:     reqSvc,
 		reviewSvc:  reviewSvc,
+		codegenSvc: codegenSvc,
 		cmdHandler: cmdHandler,
 	}
 }
@@ -107,6 +110,42 @@ func (h *MessageHandler) handleAIChat(senderOpenID, messageID, text string) {
 	}
 }
 
+// HandleCardAction processes a button click on an interactive card and
+// returns the toast shown to the user.
+func (h *MessageHandler) HandleCardAction(operatorOpenID string, value map[string]interface{}) (ok bool, toast string) {
+	if action, _ := value["action"].(string); action != "tool_approval" {
+		return false, "不支持的操作"
+	}
+	user := h.findUserByOpenID(operatorOpenID)
+	if user == nil {
+		return false, "请先通过 CodeMaster Web 端完成飞书登录"
+	}
+	taskIDStr, _ := value["task_id"].(string)
+	approvalID, _ := value["approval_id"].(string)
+	decision, _ := value["decision"].(string)
+	taskID, err := strconv.ParseUint(taskIDStr, 10, 64)
+	if err != nil || approvalID == "" || (decision != "approve" && decision != "deny") {
+		return false, "无效的审批请求"
+	}
+
+	task, err := h.codegenSvc.GetTask(uint(taskID))
+	if err != nil {
+		return false, "生成任务不存在"
+	}
+	if !h.codegenSvc.CanApproveTool(task, user) {
+		return false, "仅需求指派人、项目所有者或管理员可审批工具调用"
+	}
+	approve := decision == "approve"
+	if err := h.codegenSvc.ResolveApproval(task.ID, approvalID, user, approve, ""); err != nil {
+		_, msg := service.ErrorCode(err)
+		return false, msg
+	}
+	if approve {
+		return true, "已批准，任务继续执行"
+	}
+	return true, "已拒绝该工具调用"
+}
+
 func (h *MessageHandler) findUserByOpenID(openID string) *model.User {
 	var user model.User
 	if err := h.db.Where("feishu_uid = ?", openID).First(&user).Error; err != nil {
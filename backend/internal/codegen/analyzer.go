@@ -5,11 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"time"
 
+	"github.com/codeMaster/backend/internal/agent"
 	"github.com/codeMaster/backend/internal/gitops"
 	"github.com/codeMaster/backend/internal/model"
 	"github.com/codeMaster/backend/pkg/claude"
@@ -21,10 +21,11 @@ type Analyzer struct {
 	db      *gorm.DB
 	aesKey  string
 	workDir string
+	backend agent.Backend
 }
 
-func NewAnalyzer(db *gorm.DB, aesKey, workDir string) *Analyzer {
-	return &Analyzer{db: db, aesKey: aesKey, workDir: workDir}
+func NewAnalyzer(db *gorm.DB, aesKey, workDir string, backend agent.Backend) *Analyzer {
+	return &Analyzer{db: db, aesKey: aesKey, workDir: workDir, backend: backend}
 }
 
 func (a *Analyzer) setFailed(repo *model.Repository, errMsg string) {
@@ -72,28 +73,23 @@ func (a *Analyzer) Analyze(ctx context.Context, repo *model.Repository, gitToken
 	analyzeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	args := []string{
-		"-p", prompt,
-		"--output-format", "json",
-		"--allowedTools", "Read,Glob,Grep",
-	}
-	if modelName != "" {
-		args = append(args, "--model", modelName)
+	req := agent.Request{
+		Prompt:  prompt,
+		WorkDir: workDir,
+		Model:   modelName,
+		APIKey:  apiKey,
+		BaseURL: baseURL,
 	}
+	var project model.Project
+	a.db.Select("id", "tool_policy").First(&project, repo.ProjectID)
+	agent.ResolveTools(project.ToolPolicy.Data, true).Apply(&req)
 
-	cmd := exec.CommandContext(analyzeCtx, "claude", args...)
-	cmd.Dir = workDir
-	cmd.Env = os.Environ()
-	if apiKey != "" {
-		cmd.Env = append(cmd.Env, "ANTHROPIC_API_KEY="+apiKey)
-	}
-	if baseURL != "" {
-		cmd.Env = append(cmd.Env, "ANTHROPIC_BASE_URL="+baseURL)
-	}
-
-	output, err := cmd.CombinedOutput()
+	res, err := agent.Run(analyzeCtx, a.backend, "", req, nil)
 	if err != nil {
-		errDetail := string(output)
+		errDetail := ""
+		if res != nil {
+			errDetail = res.Stderr
+		}
 		if len(errDetail) > 500 {
 			errDetail = errDetail[:500]
 		}
@@ -101,8 +97,8 @@ func (a *Analyzer) Analyze(ctx context.Context, repo *model.Repository, gitToken
 		return fmt.Errorf("claude analyze: %s: %w", errDetail, err)
 	}
 
-	// Extract JSON from Claude CLI output (handles envelope + markdown fences)
-	rawJSON := claude.ExtractJSON(output)
+	// Extract JSON from the final result text (handles markdown fences)
+	rawJSON := claude.ExtractJSON([]byte(res.Text))
 
 	var result model.AnalysisResult
 	if err := json.Unmarshal(rawJSON, &result); err != nil {
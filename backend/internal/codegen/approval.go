@@ -0,0 +1,497 @@
+package codegen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codeMaster/backend/internal/agent"
+	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/internal/sse"
+)
+
+// Rules used when a project enables approval without listing its own.
+var (
+	DefaultApprovalCommands = []string{
+		"rm", "git push", "git reset --hard", "git clean",
+		"npm install", "npm i", "yarn add", "pnpm add", "pip install", "go get", "go install",
+		"cargo add", "cargo install", "apt-get", "apt", "yum", "brew install",
+	}
+	DefaultApprovalPaths = []string{"deploy/**", ".github/**", ".gitlab-ci.yml", ".gitlab/**", ".circleci/**", "Jenkinsfile"}
+)
+
+const defaultApprovalTimeout = 30 * time.Minute
+
+// ErrApprovalNotFound is returned when resolving an approval that does not
+// exist or has already been decided.
+var ErrApprovalNotFound = errors.New("approval request not found")
+
+// gatedTools are taken off the pre-allowed list while approval is enabled, so
+// every call reaches the gate, which lets the harmless ones through.
+var gatedTools = map[string]bool{"Bash": true, "Write": true, "Edit": true, "MultiEdit": true, "NotebookEdit": true}
+
+// commandSeparators splits a shell command line into simple commands.
+var commandSeparators = regexp.MustCompile("&&|\\|\\||[;|&\\n`]|\\$\\(")
+
+// envAssignment matches a VAR=value word of a simple command.
+var envAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// commandWrappers run the command following their own options, and shells
+// the script passed with -c; rules match the command they run.
+var (
+	commandWrappers = map[string]bool{"sudo": true, "env": true, "command": true, "exec": true, "nohup": true, "time": true, "nice": true, "xargs": true}
+	shells          = map[string]bool{"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true}
+)
+
+// wrapperValueOptions are the wrapper options that take a value, e.g.
+// sudo -u, env -u, nice -n, xargs -I.
+var wrapperValueOptions = map[string]bool{"-u": true, "-g": true, "-n": true, "-I": true, "-L": true, "-P": true, "-d": true, "-s": true, "-E": true, "-a": true}
+
+// shellKeywords may precede a command without being one, e.g. "then rm x".
+var shellKeywords = map[string]bool{"if": true, "then": true, "elif": true, "else": true, "do": true, "while": true, "until": true}
+
+// gitValueOptions are the git global options that take a value, e.g.
+// git -C dir push.
+var gitValueOptions = map[string]bool{"-C": true, "-c": true, "--git-dir": true, "--work-tree": true, "--namespace": true}
+
+// findExecEnd matches the escaped or quoted ";" ending a find -exec action,
+// which must not split the command line. It is replaced by execEnd.
+var findExecEnd = regexp.MustCompile(`\\;|';'|";"`)
+
+const execEnd = "\x00"
+
+// shellScriptFlag matches a short-option cluster holding -c, e.g. -c or -lc.
+var shellScriptFlag = regexp.MustCompile(`^-[a-zA-Z]*c[a-zA-Z]*$`)
+
+// ToolApproval is a tool call waiting for a user's decision.
+type ToolApproval struct {
+	ID        string                 `json:"approval_id"`
+	Tool      string                 `json:"tool"`
+	Input     map[string]interface{} `json:"input"`
+	Reason    string                 `json:"reason"` // the rule that matched
+	CreatedAt time.Time              `json:"created_at"`
+	ExpiresAt time.Time              `json:"expires_at"`
+}
+
+// Detail is a one-line description of the call, e.g. the command or file path.
+func (a ToolApproval) Detail() string {
+	for _, k := range []string{"command", "file_path", "notebook_path"} {
+		if v, ok := a.Input[k].(string); ok && v != "" {
+			return v
+		}
+	}
+	return a.Tool
+}
+
+type verdict int
+
+const (
+	verdictAllow verdict = iota
+	verdictDeny
+	verdictAsk
+)
+
+type pendingApproval struct {
+	ToolApproval
+	sess  agent.Session
+	input json.RawMessage
+	timer *time.Timer
+}
+
+// approvalGate decides the permission requests of one task's agent sessions.
+type approvalGate struct {
+	commands []string
+	paths    []string
+	timeout  time.Duration
+	deadline time.Time // end of the run; approvals expire by then at the latest
+	workDir  string
+	allowed  map[string]bool // gated tools the tool policy allows
+	mcp      map[string]bool // allowed MCP servers → read-only
+
+	mu      sync.Mutex
+	pending map[string]*pendingApproval
+}
+
+// newApprovalGate returns nil unless p enables approval.
+func newApprovalGate(p *model.ApprovalPolicy) *approvalGate {
+	if p == nil || !p.Enabled {
+		return nil
+	}
+	g := &approvalGate{
+		commands: p.Commands,
+		paths:    p.Paths,
+		timeout:  time.Duration(p.TimeoutMinutes) * time.Minute,
+		allowed:  make(map[string]bool),
+		mcp:      make(map[string]bool),
+		pending:  make(map[string]*pendingApproval),
+	}
+	if len(g.commands) == 0 {
+		g.commands = DefaultApprovalCommands
+	}
+	if len(g.paths) == 0 {
+		g.paths = DefaultApprovalPaths
+	}
+	if g.timeout <= 0 {
+		g.timeout = defaultApprovalTimeout
+	}
+	return g
+}
+
+// attach routes req's gated tools and MCP servers through the gate.
+func (g *approvalGate) attach(req *agent.Request) {
+	g.workDir = req.WorkDir
+	var tools []string
+	for _, t := range req.AllowedTools {
+		if gatedTools[t] {
+			g.allowed[t] = true
+			continue
+		}
+		if name, ok := strings.CutPrefix(t, "mcp__"); ok {
+			if srv, ok := req.MCPServers[name]; ok {
+				g.mcp[name] = srv.ReadOnly
+				continue
+			}
+		}
+		tools = append(tools, t)
+	}
+	req.AllowedTools = tools
+	req.PermissionPrompt = true
+}
+
+// decide classifies a tool call: tools outside the policy are refused, risky
+// calls of allowed tools need a user's approval, the rest run.
+func (g *approvalGate) decide(tool string, input json.RawMessage) (verdict, string) {
+	if rest, ok := strings.CutPrefix(tool, "mcp__"); ok {
+		server, _, _ := strings.Cut(rest, "__")
+		readOnly, allowed := g.mcp[server]
+		switch {
+		case !allowed:
+			return verdictDeny, ""
+		case readOnly:
+			return verdictAllow, ""
+		}
+		return verdictAsk, "调用 MCP server " + server + " 的工具"
+	}
+	if !g.allowed[tool] {
+		return verdictDeny, ""
+	}
+	var in struct {
+		Command      string `json:"command"`
+		FilePath     string `json:"file_path"`
+		NotebookPath string `json:"notebook_path"`
+	}
+	json.Unmarshal(input, &in)
+
+	if tool == "Bash" {
+		if rule := g.matchCommand(in.Command); rule != "" {
+			return verdictAsk, "命令匹配 " + rule
+		}
+		return verdictAllow, ""
+	}
+	file := in.FilePath
+	if file == "" {
+		file = in.NotebookPath
+	}
+	if rule := g.matchPath(file); rule == outsideWorkspace {
+		return verdictAsk, "写入工作区之外的文件"
+	} else if rule != "" {
+		return verdictAsk, "路径匹配 " + rule
+	}
+	return verdictAllow, ""
+}
+
+// matchCommand returns the rule matching any simple command of line.
+func (g *approvalGate) matchCommand(line string) string {
+	line = findExecEnd.ReplaceAllString(line, " "+execEnd+" ")
+	for _, part := range commandSeparators.Split(line, -1) {
+		if rule := g.matchSimpleCommand(strings.Fields(part)); rule != "" {
+			return rule
+		}
+	}
+	return ""
+}
+
+// matchSimpleCommand returns the rule matching the command words, looking
+// through grouping, shell keywords, wrappers, shell -c scripts and find
+// actions to the commands they run. Binaries given by path match by name, so
+// /bin/rm matches "rm", and git rules match past git's global options.
+func (g *approvalGate) matchSimpleCommand(words []string) string {
+	cmd := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.Trim(w, `"'`); w != "" {
+			cmd = append(cmd, w)
+		}
+	}
+	for len(cmd) > 0 {
+		if w := strings.TrimLeft(cmd[0], "({!"); w != cmd[0] {
+			if cmd[0] = w; w == "" {
+				cmd = cmd[1:]
+			}
+			continue
+		}
+		if envAssignment.MatchString(cmd[0]) || shellKeywords[cmd[0]] {
+			cmd = cmd[1:]
+			continue
+		}
+		if !commandWrappers[path.Base(cmd[0])] {
+			break
+		}
+		cmd = cmd[1:]
+		for len(cmd) > 0 && strings.HasPrefix(cmd[0], "-") {
+			if wrapperValueOptions[cmd[0]] && len(cmd) > 1 {
+				cmd = cmd[1:]
+			}
+			cmd = cmd[1:]
+		}
+	}
+	if len(cmd) == 0 {
+		return ""
+	}
+	cmd[0] = path.Base(cmd[0])
+
+	switch {
+	case shells[cmd[0]]:
+		for i, w := range cmd[1:] {
+			if shellScriptFlag.MatchString(w) {
+				return g.matchCommand(strings.Join(cmd[i+2:], " "))
+			}
+		}
+	case cmd[0] == "git":
+		args := cmd[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			if gitValueOptions[args[0]] && len(args) > 1 {
+				args = args[1:]
+			}
+			args = args[1:]
+		}
+		cmd = append([]string{"git"}, args...)
+	case cmd[0] == "find":
+		for i, w := range cmd {
+			switch w {
+			case "-delete":
+				if rule := g.matchRule("rm"); rule != "" {
+					return rule
+				}
+			case "-exec", "-execdir", "-ok", "-okdir":
+				end := i + 1
+				for end < len(cmd) && cmd[end] != execEnd && cmd[end] != "+" {
+					end++
+				}
+				if rule := g.matchSimpleCommand(cmd[i+1 : end]); rule != "" {
+					return rule
+				}
+			}
+		}
+	}
+	return g.matchRule(strings.Join(cmd, " "))
+}
+
+// matchRule returns the rule cmd starts with.
+func (g *approvalGate) matchRule(cmd string) string {
+	for _, rule := range g.commands {
+		if cmd == rule || strings.HasPrefix(cmd, rule+" ") {
+			return rule
+		}
+	}
+	return ""
+}
+
+const outsideWorkspace = "<outside>"
+
+// matchPath returns the rule matching file. Files outside the workspace
+// always need approval.
+func (g *approvalGate) matchPath(file string) string {
+	if file == "" {
+		return ""
+	}
+	abs := file
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(g.workDir, file)
+	}
+	rel, err := filepath.Rel(g.workDir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return outsideWorkspace
+	}
+	rel = filepath.ToSlash(rel)
+	for _, rule := range g.paths {
+		if matchGlob(rule, rel) {
+			return rule
+		}
+	}
+	return ""
+}
+
+// matchGlob matches rel against a path.Match pattern; "dir/**" matches
+// everything below dir and a pattern without "/" also matches base names.
+func matchGlob(pattern, rel string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return rel == dir || strings.HasPrefix(rel, dir+"/")
+	}
+	if ok, _ := path.Match(pattern, rel); ok {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return false
+}
+
+// add registers a pending approval; onExpire runs if nobody answers in time.
+func (g *approvalGate) add(sess agent.Session, event *agent.Event, reason string, onExpire func(id string)) ToolApproval {
+	var input map[string]interface{}
+	json.Unmarshal(event.Input, &input)
+	now := time.Now()
+	expires := now.Add(g.timeout)
+	if !g.deadline.IsZero() && g.deadline.Before(expires) {
+		expires = g.deadline
+	}
+	p := &pendingApproval{
+		ToolApproval: ToolApproval{
+			ID:        event.RequestID,
+			Tool:      event.ToolName,
+			Input:     input,
+			Reason:    reason,
+			CreatedAt: now,
+			ExpiresAt: expires,
+		},
+		sess:  sess,
+		input: event.Input,
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p.timer = time.AfterFunc(expires.Sub(now), func() { onExpire(p.ID) })
+	g.pending[p.ID] = p
+	return p.ToolApproval
+}
+
+// take removes and returns a pending approval.
+func (g *approvalGate) take(id string) (*pendingApproval, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.pending[id]
+	if !ok {
+		return nil, ErrApprovalNotFound
+	}
+	delete(g.pending, id)
+	p.timer.Stop()
+	return p, nil
+}
+
+// drop removes the approvals of a finished session and returns their IDs.
+func (g *approvalGate) drop(sess agent.Session) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ids []string
+	for id, p := range g.pending {
+		if p.sess == sess {
+			p.timer.Stop()
+			delete(g.pending, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (g *approvalGate) list() []ToolApproval {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	list := make([]ToolApproval, 0, len(g.pending))
+	for _, p := range g.pending {
+		list = append(list, p.ToolApproval)
+	}
+	return list
+}
+
+// attachGate puts the project's approval gate, if any, in front of the
+// session started with req.
+func (e *Executor) attachGate(req *agent.Request) {
+	if e.toolPolicy == nil {
+		return
+	}
+	if e.gate = newApprovalGate(e.toolPolicy.Approval); e.gate == nil {
+		return
+	}
+	e.gate.deadline = e.deadline
+	if p, ok := e.backend.(agent.PermissionPrompter); ok && p.SupportsPermissionPrompt() {
+		e.gate.attach(req)
+	} else {
+		e.gate = nil
+		e.broadcastLog("warn", "claude", fmt.Sprintf("Agent 后端 %s 不支持工具调用审批，审批模式未生效", e.backend.Name()), nil)
+	}
+}
+
+// handlePermission answers a permission request of sess, or parks it until a
+// user decides.
+func (e *Executor) handlePermission(sess agent.Session, event *agent.Event) {
+	v, reason := verdictDeny, ""
+	if e.gate != nil {
+		v, reason = e.gate.decide(event.ToolName, event.Input)
+	}
+	switch v {
+	case verdictAllow:
+		sess.RespondPermission(event.RequestID, true, event.Input, "")
+	case verdictDeny:
+		sess.RespondPermission(event.RequestID, false, event.Input, fmt.Sprintf("工具 %s 未被项目工具策略允许", event.ToolName))
+		e.broadcastLog("warn", "claude", fmt.Sprintf("已拒绝未授权的工具调用: %s", event.ToolName), nil)
+	case verdictAsk:
+		approval := e.gate.add(sess, event, reason, func(id string) {
+			e.ResolveApproval(id, false, 0, "系统", "审批超时，已自动拒绝")
+		})
+		id := e.eventID.Add(1)
+		e.hub.Broadcast(int64(e.task.ID), sse.Event{ID: id, Type: "approval_required", Data: approval})
+		e.broadcastStatus("running", fmt.Sprintf("等待审批: %s %s", approval.Tool, truncateStr(approval.Detail(), 100)))
+		if e.onApproval != nil {
+			go e.onApproval(approval)
+		}
+	}
+}
+
+// ResolveApproval approves or denies a pending tool call. Denied calls are
+// refused and the agent carries on without them.
+func (e *Executor) ResolveApproval(approvalID string, approve bool, userID uint, userName, comment string) error {
+	if e.gate == nil {
+		return ErrApprovalNotFound
+	}
+	p, err := e.gate.take(approvalID)
+	if err != nil {
+		return err
+	}
+	message := "用户拒绝了该操作"
+	if comment != "" {
+		message += ": " + comment
+	}
+	if err := p.sess.RespondPermission(p.ID, approve, p.input, message); err != nil {
+		return err
+	}
+
+	id := e.eventID.Add(1)
+	e.hub.Broadcast(int64(e.task.ID), sse.Event{ID: id, Type: "approval_resolved", Data: map[string]interface{}{
+		"approval_id": p.ID,
+		"tool":        p.Tool,
+		"approved":    approve,
+		"user_id":     userID,
+		"user_name":   userName,
+		"comment":     comment,
+	}})
+	decision := "拒绝"
+	if approve {
+		decision = "批准"
+	}
+	e.broadcastLog("info", "claude", fmt.Sprintf("%s %s了工具调用: %s %s", userName, decision, p.Tool, truncateStr(p.Detail(), 200)), nil)
+	return nil
+}
+
+// PendingApprovals lists the tool calls waiting for a decision.
+func (e *Executor) PendingApprovals() []ToolApproval {
+	if e.gate == nil {
+		return []ToolApproval{}
+	}
+	return e.gate.list()
+}
@@ -0,0 +1,97 @@
+package codegen
+
+import "testing"
+
+func TestMatchCommand(t *testing.T) {
+	g := &approvalGate{commands: DefaultApprovalCommands, workDir: "/work/task"}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"ls -la", ""},
+		{"echo rm", ""},
+		{"go test ./...", ""},
+		{"rm -rf x", "rm"},
+		{"FOO=1 rm x", "rm"},
+		{"/bin/rm x", "rm"},
+		{"sudo -u root /usr/bin/rm x", "rm"},
+		{"env FOO=1 rm x", "rm"},
+		{"command rm x", "rm"},
+		{"nice -n 5 npm i", "npm i"},
+		{"ls | xargs rm", "rm"},
+		{"ls | xargs -I {} rm {}", "rm"},
+		{"make && rm x", "rm"},
+		{"echo $(rm x)", "rm"},
+
+		// grouping and shell keywords
+		{"(rm -rf x)", "rm"},
+		{"( rm -rf x )", "rm"},
+		{"{ rm -rf x; }", "rm"},
+		{"! rm x", "rm"},
+		{"if true; then rm -rf x; fi", "rm"},
+		{"if rm x; then echo ok; fi", "rm"},
+		{"if false; then :; else rm x; fi", "rm"},
+		{"for f in *; do rm $f; done", "rm"},
+		{"while true; do git push; done", "git push"},
+		{"until git push; do sleep 1; done", "git push"},
+
+		// git global options
+		{"git push origin main", "git push"},
+		{"git -C . push", "git push"},
+		{"git -c user.name=x push", "git push"},
+		{"git --no-pager push", "git push"},
+		{"git --git-dir .git --work-tree . push", "git push"},
+		{"git --git-dir=.git push", "git push"},
+		{"git -C . reset --hard HEAD~1", "git reset --hard"},
+		{"git -C . reset --soft HEAD~1", ""},
+		{"git -C . status", ""},
+
+		// shell scripts
+		{`bash -c "rm -rf x"`, "rm"},
+		{`sh -lc 'git push origin'`, "git push"},
+		{`bash -ec "make; rm x"`, "rm"},
+		{`bash --rcfile rc -c "rm x"`, "rm"},
+		{`bash --norc script.sh`, ""},
+		{`bash -x script.sh --cleanup`, ""},
+
+		// find actions
+		{"find . -delete", "rm"},
+		{"find . -name '*.go'", ""},
+		{`find . -name x -exec rm {} \;`, "rm"},
+		{`find . -name x -exec rm {} \; -print`, "rm"},
+		{"find . -name x -exec rm {} ';'", "rm"},
+		{"find . -name x -exec rm {} +", "rm"},
+		{"find . -name x -execdir rm {} +", "rm"},
+		{`find . -exec echo {} \; -delete`, "rm"},
+		{`find . -exec echo {} \;`, ""},
+	}
+	for _, tt := range tests {
+		if got := g.matchCommand(tt.line); got != tt.want {
+			t.Errorf("matchCommand(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	g := &approvalGate{paths: DefaultApprovalPaths, workDir: "/work/task"}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"", ""},
+		{"main.go", ""},
+		{"/work/task/internal/x.go", ""},
+		{"deploy/k8s.yaml", "deploy/**"},
+		{"/work/task/.github/workflows/ci.yml", ".github/**"},
+		{"sub/Jenkinsfile", "Jenkinsfile"},
+		{"../../etc/passwd", outsideWorkspace},
+		{"./../task/ok.go", ""},
+		{"/etc/passwd", outsideWorkspace},
+		{"/work/task-2/x.go", outsideWorkspace},
+	}
+	for _, tt := range tests {
+		if got := g.matchPath(tt.file); got != tt.want {
+			t.Errorf("matchPath(%q) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
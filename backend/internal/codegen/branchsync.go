@@ -0,0 +1,181 @@
+package codegen
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codeMaster/backend/internal/agent"
+	"github.com/codeMaster/backend/internal/gitops"
+	"github.com/codeMaster/backend/internal/model"
+)
+
+// ValidateBranchSync checks a project branch sync setting before it is stored.
+func ValidateBranchSync(p *model.BranchSync) error {
+	switch p.Strategy {
+	case "", model.BranchSyncMerge, model.BranchSyncRebase:
+	default:
+		return fmt.Errorf("strategy 取值必须为 merge / rebase")
+	}
+	switch p.OnConflict {
+	case "", model.SyncConflictReport, model.SyncConflictAgent:
+	default:
+		return fmt.Errorf("on_conflict 取值必须为 report / agent")
+	}
+	return nil
+}
+
+// syncBranch brings the checked-out requirement branch up to date with the
+// source branch per the project's branch sync setting. Conflicts are either
+// reported, leaving the branch as it was, or handed to a dedicated agent
+// session. It returns false once the task failed or was cancelled.
+func (e *Executor) syncBranch(ctx context.Context, workDir, token string) (bool, error) {
+	if e.branchSync == nil || e.branchSync.Strategy == "" {
+		return true, nil
+	}
+	if err := gitops.Unshallow(ctx, workDir, e.repo.GitURL, token); err != nil {
+		e.broadcastLog("warn", "clone", "获取完整提交历史失败，跳过分支同步", map[string]interface{}{"error": err.Error()})
+		return true, nil
+	}
+	branchSHA, err := gitops.RevParse(ctx, workDir, "HEAD")
+	if err != nil {
+		return false, e.fail("读取分支提交失败: " + err.Error())
+	}
+	sourceSHA, err := gitops.RevParse(ctx, workDir, e.task.SourceBranch)
+	if err != nil {
+		return false, e.fail("读取源分支提交失败: " + err.Error())
+	}
+
+	e.broadcastLog("info", "clone", "正在同步源分支的最新提交", map[string]interface{}{
+		"strategy":   e.branchSync.Strategy,
+		"source":     e.task.SourceBranch,
+		"source_sha": sourceSHA,
+	})
+	byAgent := e.branchSync.OnConflict == model.SyncConflictAgent
+	unsign, err := e.sign(ctx, workDir)
+	if err != nil {
+		return false, e.fail("配置提交签名失败: " + err.Error())
+	}
+	res, err := gitops.SyncBranch(ctx, workDir, e.task.SourceBranch, e.branchSync.Strategy, byAgent)
+	unsign()
+	if err != nil {
+		e.broadcastLog("error", "clone", "同步源分支失败", map[string]interface{}{"error": err.Error()})
+		return false, e.fail("同步源分支失败: " + err.Error())
+	}
+
+	result := &model.BranchSyncResult{
+		Strategy:  res.Strategy,
+		SourceSHA: sourceSHA,
+		BranchSHA: branchSHA,
+		Conflicts: res.Conflicts,
+	}
+	switch {
+	case res.Updated:
+		result.Status = model.BranchSyncUpdated
+		e.synced = true
+		if res.Strategy == model.BranchSyncRebase {
+			e.pushLease = branchSHA
+		}
+		e.broadcastLog("info", "clone", "已同步源分支的最新提交", map[string]interface{}{"strategy": res.Strategy})
+	case len(res.Conflicts) == 0:
+		result.Status = model.BranchSyncUpToDate
+		e.broadcastLog("info", "clone", "需求分支已包含源分支的最新提交", nil)
+	case !byAgent:
+		result.Status = model.BranchSyncConflict
+		e.broadcastLog("warn", "clone", "需求分支与源分支存在冲突，本次迭代基于原分支继续", map[string]interface{}{
+			"conflicts": res.Conflicts,
+		})
+	default:
+		if ok, err := e.resolveConflicts(ctx, workDir, res.Conflicts); !ok {
+			result.Status = model.BranchSyncConflict
+			e.db.Model(e.task).Update("branch_sync", model.JSONBranchSyncResult{Data: result})
+			return false, err
+		}
+		result.Status = model.BranchSyncResolved
+		e.synced = true
+	}
+	e.db.Model(e.task).Update("branch_sync", model.JSONBranchSyncResult{Data: result})
+	return true, nil
+}
+
+// resolveConflicts runs a fresh agent session on the merge SyncBranch left in
+// progress and commits it once no conflict markers remain. Its cost counts
+// towards the task. It returns false once the task failed or was cancelled.
+func (e *Executor) resolveConflicts(ctx context.Context, workDir string, conflicts []string) (bool, error) {
+	e.updateStatus("running")
+	e.broadcastStatus("running", "正在由 Agent 解决与源分支的合并冲突...")
+	e.broadcastLog("info", "claude", fmt.Sprintf("合并源分支出现 %d 个冲突文件，启动独立会话解决", len(conflicts)), map[string]interface{}{
+		"conflicts": conflicts,
+	})
+
+	req := agent.Request{
+		Prompt:      BuildConflictPrompt(e.task.SourceBranch, conflicts),
+		WorkDir:     workDir,
+		MaxTurns:    e.maxTurns,
+		Model:       e.modelName,
+		APIKey:      e.apiKey,
+		BaseURL:     e.baseURL,
+		HomeDir:     e.sessionDir,
+		TimeoutMin:  e.timeoutMin,
+		Sandbox:     e.sandbox,
+		Interactive: true,
+	}
+	agent.ResolveTools(e.toolPolicy, false).Apply(&req)
+	e.attachGate(&req)
+
+	sess, err := e.backend.Start(ctx, req)
+	if err != nil {
+		return false, e.fail("启动冲突解决会话失败: " + err.Error())
+	}
+	e.session.Store(&sess)
+	e.pid.Store(int32(sess.PID()))
+
+	st := &streamState{meter: agent.NewCostMeter(), auxiliary: true}
+	st.meter.AddSettled(e.task.ClaudeCostUSD)
+	result, err := e.streamSession(sess, st)
+	// The iteration's own session adds to this
+	e.task.ClaudeCostUSD = st.meter.Total()
+	e.db.Model(e.task).Update("claude_cost_usd", e.task.ClaudeCostUSD)
+	if st.overBudget {
+		return false, e.fail(e.budget.exceededMessage(st.meter.Total()))
+	}
+	if err != nil {
+		if e.cancelled.Load() {
+			return false, nil
+		}
+		return false, e.fail("冲突解决失败: " + e.agentFailReason(result, err))
+	}
+
+	if left := gitops.ConflictMarkers(workDir, conflicts); len(left) > 0 {
+		e.broadcastLog("error", "claude", "仍有文件包含冲突标记", map[string]interface{}{"files": left})
+		return false, e.fail(fmt.Sprintf("Agent 未能解决全部合并冲突: %v", left))
+	}
+	// The session may have concluded the merge itself
+	if _, err := gitops.RevParse(ctx, workDir, "MERGE_HEAD"); err == nil {
+		unsign, err := e.sign(ctx, workDir)
+		if err != nil {
+			return false, e.fail("配置提交签名失败: " + err.Error())
+		}
+		_, err = gitops.CommitMerge(ctx, workDir)
+		unsign()
+		if err != nil {
+			return false, e.fail("提交合并结果失败: " + err.Error())
+		}
+	}
+	e.broadcastLog("info", "claude", "合并冲突已解决", map[string]interface{}{"cost_usd": st.meter.Total()})
+	return true, nil
+}
+
+// sign enables commit signing in workDir until the returned func is called.
+// The key is only written for the executor's own commits, so agent sessions
+// never get to read it.
+func (e *Executor) sign(ctx context.Context, workDir string) (func(), error) {
+	return e.identity.Sign(ctx, workDir, workDir+".keys")
+}
+
+// push pushes the requirement branch, with a lease when it was rebased.
+func (e *Executor) push(ctx context.Context, workDir, token string) error {
+	if e.pushLease != "" {
+		return gitops.PushWithLease(ctx, workDir, e.task.TargetBranch, e.pushLease, e.repo.GitURL, token, e.useLocalGit)
+	}
+	return gitops.Push(ctx, workDir, e.task.TargetBranch, e.repo.GitURL, token, e.useLocalGit)
+}
@@ -0,0 +1,29 @@
+package codegen
+
+import "fmt"
+
+// Budget caps what a single task may spend. A zero LimitUSD means unlimited.
+type Budget struct {
+	LimitUSD  float64
+	Scope     string  // task / project / user: which limit LimitUSD was derived from
+	WarnRatio float64 // fraction of LimitUSD at which OnWarn fires once; 0 disables
+	// OnWarn is called (at most once per task) when spend crosses WarnRatio,
+	// and with exceeded=true when the task is stopped for going over budget.
+	OnWarn func(spentUSD, limitUSD float64, exceeded bool)
+}
+
+// ScopeLabel returns the display name of the budget scope.
+func (b Budget) ScopeLabel() string {
+	switch b.Scope {
+	case "project":
+		return "项目月度"
+	case "user":
+		return "用户月度"
+	default:
+		return "单任务"
+	}
+}
+
+func (b Budget) exceededMessage(spent float64) string {
+	return fmt.Sprintf("已超出%s预算: 花费 $%.4f / 上限 $%.2f", b.ScopeLabel(), spent, b.LimitUSD)
+}
@@ -0,0 +1,232 @@
+package codegen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codeMaster/backend/internal/agent"
+	"github.com/codeMaster/backend/internal/gitops"
+	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/pkg/claude"
+)
+
+// commitPlanDiffLimit caps the diff handed to the agent writing commit
+// messages; it can read the files for anything cut off.
+const commitPlanDiffLimit = 60000
+
+// ValidateCommitPolicy checks a project commit policy before it is stored.
+func ValidateCommitPolicy(p *model.CommitPolicy) error {
+	switch p.Split {
+	case "", model.CommitSplitModule, model.CommitSplitAgent:
+		return nil
+	}
+	return fmt.Errorf("split 取值必须为 module / agent，为空时提交为一个 commit")
+}
+
+// commitGroup is a set of changed files committed together.
+type commitGroup struct {
+	Name    string   `json:"-"` // module the files belong to
+	Files   []string `json:"files"`
+	Message string   `json:"message"`
+}
+
+// commitChanges commits the changes of the run per the project's commit
+// policy, with message, the rendered commit template, unless the agent
+// writes the messages. It returns the new head and whether there was
+// anything to commit.
+func (e *Executor) commitChanges(ctx context.Context, workDir, message string, st *streamState) (string, bool, error) {
+	files, err := gitops.StageAll(ctx, workDir)
+	if err != nil || len(files) == 0 {
+		return "", false, err
+	}
+
+	p := e.commitPolicy
+	if p == nil {
+		p = &model.CommitPolicy{}
+	}
+	groups := []commitGroup{{Files: files}}
+	if p.Split == model.CommitSplitModule {
+		var modules []model.AnalysisModule
+		if a := e.repo.AnalysisResult.Data; a != nil {
+			modules = a.Modules
+		}
+		groups = groupByModule(files, modules)
+	}
+	for i := range groups {
+		groups[i].Message = groupMessage(message, groups[i].Name, len(groups))
+	}
+
+	if p.Split == model.CommitSplitAgent || p.GenerateMessages {
+		planned, err := e.planCommits(ctx, workDir, files, groups, p.Split == model.CommitSplitAgent, message, st)
+		switch {
+		case e.cancelled.Load() || st.overBudget:
+			return "", false, err
+		case err != nil:
+			e.broadcastLog("warn", "push", "Agent 未能给出提交方案，按提交信息模板提交", map[string]interface{}{"error": err.Error()})
+		default:
+			groups = planned
+		}
+	}
+
+	unsign, err := e.sign(ctx, workDir)
+	if err != nil {
+		return "", false, fmt.Errorf("配置提交签名失败: %w", err)
+	}
+	defer unsign()
+	var head string
+	for _, g := range groups {
+		if head, err = gitops.CommitPaths(ctx, workDir, g.Files, e.identity.Message(g.Message)); err != nil {
+			return "", false, err
+		}
+	}
+	return head, true, nil
+}
+
+// planCommits asks a read-only agent session for commit messages written from
+// the staged diff: one per group, or, with split, a grouping of files into
+// logical commits as well. Files the agent leaves out are committed last.
+func (e *Executor) planCommits(ctx context.Context, workDir string, files []string, groups []commitGroup, split bool, example string, st *streamState) ([]commitGroup, error) {
+	diff, err := gitops.StagedDiff(ctx, workDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(diff) > commitPlanDiffLimit {
+		diff = diff[:commitPlanDiffLimit] + "\n... (diff 过长已截断)"
+	}
+	var fixed [][]string
+	if !split {
+		for _, g := range groups {
+			fixed = append(fixed, g.Files)
+		}
+	}
+
+	e.broadcastStatus("running", "正在根据变更内容生成提交信息...")
+	req := agent.Request{
+		Prompt:     BuildCommitPlanPrompt(files, fixed, e.redactor.String(diff), example),
+		WorkDir:    workDir,
+		MaxTurns:   e.maxTurns,
+		Model:      e.modelName,
+		APIKey:     e.apiKey,
+		BaseURL:    e.baseURL,
+		HomeDir:    e.sessionDir,
+		TimeoutMin: e.timeoutMin,
+		Sandbox:    e.sandbox,
+	}
+	agent.ResolveTools(e.toolPolicy, true).Apply(&req)
+	sess, err := e.backend.Start(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	e.session.Store(&sess)
+	e.pid.Store(int32(sess.PID()))
+	// Shares the run's meter, but must not replace its session for resuming
+	st.auxiliary = true
+	result, err := e.streamSession(sess, st)
+	st.auxiliary = false
+	if err != nil {
+		return nil, errors.New(e.agentFailReason(result, err))
+	}
+
+	var plan struct {
+		Commits []commitGroup `json:"commits"`
+	}
+	raw := claude.ExtractJSON([]byte(result.Text))
+	if raw == nil || json.Unmarshal(raw, &plan) != nil || len(plan.Commits) == 0 {
+		return nil, errors.New("输出中没有可解析的提交方案")
+	}
+	for _, c := range plan.Commits {
+		if strings.TrimSpace(c.Message) == "" {
+			return nil, errors.New("提交方案中存在空的提交信息")
+		}
+	}
+	if !split {
+		if len(plan.Commits) != len(groups) {
+			return nil, fmt.Errorf("提交方案有 %d 个提交，应为 %d 个", len(plan.Commits), len(groups))
+		}
+		planned := make([]commitGroup, len(groups))
+		for i, g := range groups {
+			planned[i] = commitGroup{Name: g.Name, Files: g.Files, Message: strings.TrimSpace(plan.Commits[i].Message)}
+		}
+		return planned, nil
+	}
+
+	// Every changed file goes into exactly one commit
+	left := make(map[string]bool, len(files))
+	for _, f := range files {
+		left[f] = true
+	}
+	var planned []commitGroup
+	for _, c := range plan.Commits {
+		g := commitGroup{Message: strings.TrimSpace(c.Message)}
+		for _, f := range c.Files {
+			if left[f] {
+				g.Files = append(g.Files, f)
+				delete(left, f)
+			}
+		}
+		if len(g.Files) > 0 {
+			planned = append(planned, g)
+		}
+	}
+	if len(planned) == 0 {
+		return nil, errors.New("提交方案未包含任何变更文件")
+	}
+	if len(left) > 0 {
+		rest := commitGroup{Message: groupMessage(example, "others", 2)}
+		for _, f := range files {
+			if left[f] {
+				rest.Files = append(rest.Files, f)
+			}
+		}
+		planned = append(planned, rest)
+	}
+	return planned, nil
+}
+
+// groupByModule groups files by the deepest analyzed module containing them,
+// else by their top-level directory, in the order they are first seen.
+func groupByModule(files []string, modules []model.AnalysisModule) []commitGroup {
+	var groups []commitGroup
+	index := make(map[string]int)
+	for _, f := range files {
+		name := ""
+		for _, m := range modules {
+			dir := strings.Trim(strings.TrimPrefix(m.Path, "./"), "/")
+			if dir != "" && len(dir) > len(name) && (f == dir || strings.HasPrefix(f, dir+"/")) {
+				name = dir
+			}
+		}
+		if name == "" {
+			if top, _, ok := strings.Cut(f, "/"); ok {
+				name = top
+			} else {
+				name = "root"
+			}
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, commitGroup{Name: name})
+		}
+		groups[i].Files = append(groups[i].Files, f)
+	}
+	return groups
+}
+
+// groupMessage names the module in the subject of the template message when
+// the changes are split into several commits.
+func groupMessage(message, name string, groups int) string {
+	if groups < 2 || name == "" {
+		return message
+	}
+	subject, body, found := strings.Cut(message, "\n")
+	subject = fmt.Sprintf("%s (%s)", subject, name)
+	if !found {
+		return subject
+	}
+	return subject + "\n" + body
+}
@@ -0,0 +1,191 @@
+package codegen
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/codeMaster/backend/internal/gitops"
+	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/internal/sse"
+)
+
+// maxViolationFiles caps the files listed per violation in logs and messages.
+const maxViolationFiles = 20
+
+// ValidateDiffPolicy checks a project diff policy before it is stored.
+func ValidateDiffPolicy(p *model.DiffPolicy) error {
+	switch p.Action {
+	case "", model.DiffPolicyFail, model.DiffPolicyQuarantine:
+	default:
+		return fmt.Errorf("action 取值必须为 fail / quarantine")
+	}
+	if p.MaxFiles < 0 || p.MaxLines < 0 {
+		return fmt.Errorf("max_files / max_lines 不能为负数")
+	}
+	for _, g := range p.ForbiddenPaths {
+		if strings.TrimSpace(g) == "" {
+			return fmt.Errorf("禁止路径不能为空")
+		}
+		if _, err := path.Match(strings.TrimSuffix(g, "/**"), ""); err != nil {
+			return fmt.Errorf("无效的路径规则: %q", g)
+		}
+	}
+	return nil
+}
+
+// CheckDiffPolicy evaluates changed files against p and returns the broken
+// rules, each with the offending files.
+func CheckDiffPolicy(p *model.DiffPolicy, files []model.DiffFile) []model.DiffViolation {
+	if p == nil {
+		return nil
+	}
+	var violations []model.DiffViolation
+
+	if len(p.ForbiddenPaths) > 0 {
+		var hits []string
+		for _, f := range files {
+			for _, g := range p.ForbiddenPaths {
+				if matchGlob(g, f.Path) || (f.OldPath != "" && matchGlob(g, f.OldPath)) {
+					hits = append(hits, f.Path)
+					break
+				}
+			}
+		}
+		if len(hits) > 0 {
+			violations = append(violations, model.DiffViolation{
+				Rule:    "forbidden_path",
+				Message: fmt.Sprintf("修改了禁止变更的路径 (%d 个文件)", len(hits)),
+				Files:   hits,
+			})
+		}
+	}
+
+	if p.MaxFiles > 0 && len(files) > p.MaxFiles {
+		violations = append(violations, model.DiffViolation{
+			Rule:    "max_files",
+			Message: fmt.Sprintf("变更文件数 %d 超过上限 %d", len(files), p.MaxFiles),
+			Files:   diffPaths(files),
+		})
+	}
+
+	if p.MaxLines > 0 {
+		lines := 0
+		for _, f := range files {
+			lines += f.Additions + f.Deletions
+		}
+		if lines > p.MaxLines {
+			violations = append(violations, model.DiffViolation{
+				Rule:    "max_lines",
+				Message: fmt.Sprintf("变更行数 %d 超过上限 %d", lines, p.MaxLines),
+				Files:   diffPaths(files),
+			})
+		}
+	}
+
+	if p.BlockBinary {
+		var hits []string
+		for _, f := range files {
+			if f.Binary {
+				hits = append(hits, f.Path)
+			}
+		}
+		if len(hits) > 0 {
+			violations = append(violations, model.DiffViolation{
+				Rule:    "binary",
+				Message: fmt.Sprintf("包含 %d 个二进制文件", len(hits)),
+				Files:   hits,
+			})
+		}
+	}
+	return violations
+}
+
+func diffPaths(files []model.DiffFile) []string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	return paths
+}
+
+// violationSummary joins the violations into one line for error messages.
+func violationSummary(violations []model.DiffViolation) string {
+	parts := make([]string, len(violations))
+	for i, v := range violations {
+		files := v.Files
+		more := ""
+		if len(files) > maxViolationFiles {
+			more = fmt.Sprintf(" 等 %d 个", len(files))
+			files = files[:maxViolationFiles]
+		}
+		parts[i] = fmt.Sprintf("%s: %s%s", v.Message, strings.Join(files, ", "), more)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// enforceDiffPolicy checks the changes this run committed on top of baseSHA.
+// It returns false after failing or quarantining the task; the commit must
+// then not be pushed.
+func (e *Executor) enforceDiffPolicy(ctx context.Context, workDir, baseSHA, commitSHA string, costUSD float64, diffStat *model.DiffStat) (bool, error) {
+	if e.diffPolicy == nil {
+		return true, nil
+	}
+	files, err := gitops.GetDiffFiles(ctx, workDir, baseSHA, commitSHA)
+	if err != nil {
+		e.broadcastLog("error", "push", "读取变更文件失败", map[string]interface{}{"error": err.Error()})
+		return false, e.fail("变更策略检查失败: " + err.Error())
+	}
+	violations := CheckDiffPolicy(e.diffPolicy, files)
+	if len(violations) == 0 {
+		e.broadcastLog("info", "push", "变更策略检查通过", map[string]interface{}{"files": len(files)})
+		return true, nil
+	}
+
+	action := e.diffPolicy.Action
+	if action == "" {
+		action = model.DiffPolicyFail
+	}
+	errMsg := "变更违反项目策略，未推送: " + violationSummary(violations)
+	e.broadcastLog("error", "push", "变更违反项目策略", map[string]interface{}{
+		"violations": violations,
+		"action":     action,
+	})
+
+	updates := map[string]interface{}{
+		"claude_cost_usd":   costUSD,
+		"policy_violations": model.DiffViolations(violations),
+	}
+	if diffStat != nil {
+		updates["diff_stat"] = model.JSONDiffStat{Data: diffStat}
+	}
+	if action != model.DiffPolicyQuarantine {
+		e.db.Model(e.task).Updates(updates)
+		return false, e.fail(errMsg)
+	}
+
+	// Quarantine: keep the commit in the workspace until someone releases it
+	completedAt := time.Now()
+	updates["status"] = "quarantined"
+	updates["error_message"] = errMsg
+	updates["commit_sha"] = commitSHA
+	updates["base_sha"] = baseSHA
+	updates["completed_at"] = &completedAt
+	e.db.Model(e.task).Updates(updates)
+	e.db.Model(&model.Requirement{}).Where("id = ?", e.requirement.ID).Update("status", "draft")
+
+	errID := e.eventID.Add(1)
+	e.hub.Broadcast(int64(e.task.ID), sse.Event{ID: errID, Type: "task_error", Data: map[string]interface{}{
+		"message":    errMsg,
+		"violations": violations,
+	}})
+	doneID := e.eventID.Add(1)
+	e.hub.Broadcast(int64(e.task.ID), sse.Event{ID: doneID, Type: "done", Data: map[string]interface{}{
+		"task_id": e.task.ID,
+		"status":  "quarantined",
+	}})
+	e.persistEvents()
+	return false, nil
+}
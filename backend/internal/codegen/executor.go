@@ -1,24 +1,24 @@
 package codegen
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/codeMaster/backend/internal/agent"
+	"github.com/codeMaster/backend/internal/diffstore"
 	"github.com/codeMaster/backend/internal/gitops"
 	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/internal/sandbox"
+	"github.com/codeMaster/backend/internal/secrets"
 	"github.com/codeMaster/backend/internal/sse"
+	"github.com/codeMaster/backend/pkg/claude"
 	"github.com/codeMaster/backend/pkg/encrypt"
 	"github.com/codeMaster/backend/pkg/feishu"
 	"gorm.io/gorm"
@@ -34,6 +34,8 @@ type Executor struct {
 	useLocalGit  bool
 	sessionDir      string
 	resumeSessionID string
+	resumeWorkDir   string
+	resumeHomeDir   string
 	task         *model.CodegenTask
 	requirement  *model.Requirement
 	repo         *model.Repository
@@ -43,6 +45,24 @@ type Executor struct {
 	baseURL      string
 	modelName    string // user's preferred model (e.g. "claude-sonnet-4-20250514")
 	gitToken     string // user's personal git token (plaintext); takes priority over repo.AccessToken
+	backend      agent.Backend
+	budget       Budget
+	verifyMaxFix  int
+	verifyTimeout time.Duration
+	sandbox       *sandbox.Policy
+	toolPolicy    *model.ToolPolicy
+	diffPolicy    *model.DiffPolicy
+	branchSync    *model.BranchSync
+	commitMessage string
+	identity      *gitops.Identity
+	commitPolicy  *model.CommitPolicy
+	synced        bool   // the branch was moved onto the source branch before the run
+	pushLease     string // remote branch head to lease against once synced by rebase
+	redactor      *secrets.Redactor // masks secrets and injected credentials in stored output
+	gate          *approvalGate
+	deadline      time.Time // when the run times out
+	onApproval    func(ToolApproval)
+	session      atomic.Pointer[agent.Session]
 	eventID      atomic.Int64
 	pid          atomic.Int32
 	cancelled    atomic.Bool
@@ -56,8 +76,10 @@ type ExecutorConfig struct {
 	TimeoutMin   int
 	WorkDir      string
 	UseLocalGit  bool
-	SessionDir      string
+	SessionDir      string // the task's agent HOME, see TaskHomeDir
 	ResumeSessionID string
+	ResumeWorkDir   string // workspace the resumed session was started in
+	ResumeHomeDir   string // HOME the resumed session was recorded under
 	Task         *model.CodegenTask
 	Requirement  *model.Requirement
 	Repo         *model.Repository
@@ -67,6 +89,18 @@ type ExecutorConfig struct {
 	BaseURL      string
 	ModelName    string // user's preferred model
 	GitToken     string // user's personal git token (plaintext)
+	Backend      agent.Backend
+	Budget       Budget
+	VerifyMaxFixAttempts int           // default fix iterations when the repo doesn't set one
+	VerifyTimeout        time.Duration // default per-command timeout
+	Sandbox              *sandbox.Policy
+	ToolPolicy           *model.ToolPolicy // project tool policy; nil = default tools
+	DiffPolicy           *model.DiffPolicy // checked before pushing; nil = no limits
+	BranchSync           *model.BranchSync // syncs existing branches with the source branch; nil = never
+	CommitMessage        string            // rendered from the project's commit template; "" = default
+	Identity             *gitops.Identity  // who commits are attributed to; nil = the bot
+	CommitPolicy         *model.CommitPolicy // how changes are split into commits; nil = one commit
+	OnApprovalRequired   func(ToolApproval) // called when a tool call waits for approval
 }
 
 func NewExecutor(cfg ExecutorConfig) *Executor {
@@ -80,6 +114,8 @@ func NewExecutor(cfg ExecutorConfig) *Executor {
 		useLocalGit:     cfg.UseLocalGit,
 		sessionDir:      cfg.SessionDir,
 		resumeSessionID: cfg.ResumeSessionID,
+		resumeWorkDir:   cfg.ResumeWorkDir,
+		resumeHomeDir:   cfg.ResumeHomeDir,
 		task:            cfg.Task,
 		requirement:     cfg.Requirement,
 		repo:            cfg.Repo,
@@ -89,22 +125,36 @@ func NewExecutor(cfg ExecutorConfig) *Executor {
 		baseURL:         cfg.BaseURL,
 		modelName:       cfg.ModelName,
 		gitToken:        cfg.GitToken,
+		backend:         cfg.Backend,
+		budget:          cfg.Budget,
+		verifyMaxFix:    cfg.VerifyMaxFixAttempts,
+		verifyTimeout:   cfg.VerifyTimeout,
+		sandbox:         cfg.Sandbox,
+		toolPolicy:      cfg.ToolPolicy,
+		diffPolicy:      cfg.DiffPolicy,
+		branchSync:      cfg.BranchSync,
+		commitMessage:   cfg.CommitMessage,
+		identity:        cfg.Identity,
+		commitPolicy:    cfg.CommitPolicy,
+		onApproval:      cfg.OnApprovalRequired,
 	}
 }
 
 func (e *Executor) Run(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(e.timeoutMin)*time.Minute)
 	defer cancel()
+	e.deadline, _ = ctx.Deadline()
 
 	// Phase 1: Clone
 	e.updateStatus("cloning")
 	e.broadcastStatus("cloning", "正在克隆仓库...")
 
-	// Use stable workDir per requirement (ensures Claude session path encoding is consistent for --resume)
-	workDir := filepath.Join(e.workDir, "codegen", fmt.Sprintf("req-%d", e.requirement.ID))
-	// Clean and recreate (don't defer removal — keep for session resume)
+	// Each task gets its own workspace; the GC removes it once the task is done.
+	// The path is recorded so later tasks can resume this task's session.
+	workDir := TaskWorkDir(e.workDir, e.requirement.ID, e.task.ID)
 	os.RemoveAll(workDir)
 	os.MkdirAll(workDir, 0o755)
+	e.db.Model(e.task).Update("work_dir", workDir)
 
 	// Resolve git token: prefer user's personal token, fall back to repo's encrypted token
 	token := e.gitToken
@@ -119,6 +169,8 @@ func (e *Executor) Run(ctx context.Context) error {
 	} else {
 		log.Printf("[executor] using user's personal GitToken (len=%d)", len(token))
 	}
+	e.maskInjected(token)
+	defer e.hub.ForgetValues(int64(e.task.ID))
 
 	e.broadcastLog("info", "clone", "开始克隆仓库", map[string]interface{}{
 		"git_url":  e.repo.GitURL,
@@ -133,7 +185,7 @@ func (e *Executor) Run(ctx context.Context) error {
 	}
 	e.broadcastLog("info", "clone", "仓库克隆完成", nil)
 
-	if err := gitops.ConfigUser(ctx, workDir); err != nil {
+	if err := e.identity.Configure(ctx, workDir); err != nil {
 		return e.fail("配置 git user 失败: " + err.Error())
 	}
 
@@ -150,236 +202,188 @@ func (e *Executor) Run(ctx context.Context) error {
 		e.broadcastLog("info", "clone", "已切换到远程已有分支，基于上次结果继续开发", map[string]interface{}{
 			"branch": e.task.TargetBranch,
 		})
+		// Planning only reads the code, the coding pass syncs before it pushes
+		if !(e.task.Mode == model.TaskModePlan && e.task.PlanStatus == "") {
+			if ok, err := e.syncBranch(ctx, workDir, token); !ok {
+				return err
+			}
+		}
 	}
 
-	// Phase 2: Fetch latest doc content + Build prompt
-	docContent := e.fetchDocContent()
+	// The diff policy applies to what this run adds on top of the branch;
+	// recorded with the commit so the task's commits can be exported
+	baseSHA, err := gitops.RevParse(ctx, workDir, "HEAD")
+	if err != nil {
+		return e.fail("读取分支提交失败: " + err.Error())
+	}
 
-	var analysisResult *model.AnalysisResult
-	if e.repo.AnalysisResult.Data != nil {
-		analysisResult = e.repo.AnalysisResult.Data
+	// Phase 2: Fetch latest doc content + Build prompt.
+	// Plan-first tasks run twice: a read-only planning pass, then, once the
+	// plan is approved, a coding pass resuming the planning session.
+	planning := e.task.Mode == model.TaskModePlan && e.task.PlanStatus == ""
+	var prompt string
+	if e.task.PlanStatus == model.PlanStatusApproved && e.task.Plan.Data != nil {
+		prompt = BuildPlanApprovedPrompt(e.task.Plan.Data, e.task.PlanComment)
+	} else {
+		docContent := e.fetchDocContent()
+
+		var analysisResult *model.AnalysisResult
+		if e.repo.AnalysisResult.Data != nil {
+			analysisResult = e.repo.AnalysisResult.Data
+		}
+		input := PromptInput{
+			RepoAnalysis: analysisResult,
+			Requirement:  e.requirement,
+			ExtraContext: e.extraContext,
+			DocContent:   docContent,
+		}
+		if planning {
+			prompt = BuildPlanPrompt(input)
+		} else {
+			prompt = BuildPrompt(input)
+		}
 	}
-	prompt := BuildPrompt(PromptInput{
-		RepoAnalysis: analysisResult,
-		Requirement:  e.requirement,
-		ExtraContext: e.extraContext,
-		DocContent:   docContent,
-	})
 	e.db.Model(e.task).Update("prompt", prompt)
 
 	// Phase 3: Execute Claude Code
 	e.updateStatus("running")
-	now := time.Now()
-	e.db.Model(e.task).Update("started_at", &now)
-
-	var args []string
+	if e.task.StartedAt == nil {
+		now := time.Now()
+		e.db.Model(e.task).Update("started_at", &now)
+	}
+
+	req := agent.Request{
+		Prompt:       prompt,
+		WorkDir:      workDir,
+		MaxTurns:     e.maxTurns,
+		Model:        e.modelName,
+		APIKey:       e.apiKey,
+		BaseURL:      e.baseURL,
+		HomeDir:      e.sessionDir,
+		TimeoutMin:   e.timeoutMin,
+		Sandbox:      e.sandbox,
+		Interactive:  true,
+	}
+	// The planning pass only reads the repository
+	tools := agent.ResolveTools(e.toolPolicy, planning)
+	tools.Apply(&req)
+	if !planning {
+		e.attachGate(&req)
+	}
+
+	var sess agent.Session
 	if e.resumeSessionID != "" {
 		// Resume mode: continue from a previous session
-		args = []string{
-			"--resume", e.resumeSessionID,
-			"-p", prompt,
-			"--output-format", "stream-json",
-			"--verbose",
-			"--allowedTools", "Read,Write,Edit,Glob,Grep,Bash",
-			"--max-turns", strconv.Itoa(e.maxTurns),
-		}
 		log.Printf("[executor] 使用会话恢复模式, session_id=%s", e.resumeSessionID)
-	} else {
-		args = []string{
-			"-p", prompt,
-			"--output-format", "stream-json",
-			"--verbose",
-			"--allowedTools", "Read,Write,Edit,Glob,Grep,Bash",
-			"--max-turns", strconv.Itoa(e.maxTurns),
+		e.relocateSession(workDir)
+		sess, err = e.backend.Resume(ctx, e.resumeSessionID, req)
+		if errors.Is(err, agent.ErrResumeUnsupported) {
+			e.broadcastLog("warn", "claude", fmt.Sprintf("Agent 后端 %s 不支持会话恢复，改为新会话执行", e.backend.Name()), nil)
+			e.resumeSessionID = ""
+			sess, err = e.backend.Start(ctx, req)
 		}
+	} else {
+		sess, err = e.backend.Start(ctx, req)
 	}
-	if e.modelName != "" {
-		args = append(args, "--model", e.modelName)
-	}
-	e.broadcastLog("info", "claude", "Claude Code 启动参数", map[string]interface{}{
-		"command":          "claude",
-		"args":             args,
-		"work_dir":         workDir,
-		"timeout_min":      e.timeoutMin,
-		"resume_session":   e.resumeSessionID,
-	})
-
-	cmd := exec.CommandContext(ctx, "claude", args...)
-	cmd.Dir = workDir
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("CLAUDE_CODE_MAX_TIMEOUT=%d", e.timeoutMin*60*1000),
-	)
-	if e.sessionDir != "" {
-		cmd.Env = append(cmd.Env, "HOME="+e.sessionDir)
-	}
-	if e.apiKey != "" {
-		cmd.Env = append(cmd.Env, "ANTHROPIC_API_KEY="+e.apiKey)
-	}
-	if e.baseURL != "" {
-		cmd.Env = append(cmd.Env, "ANTHROPIC_BASE_URL="+e.baseURL)
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		e.broadcastLog("error", "claude", "获取 stdout 失败", map[string]interface{}{"error": err.Error()})
-		return e.fail("获取 stdout 失败: " + err.Error())
-	}
-
-	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		e.broadcastLog("error", "claude", "获取 stderr 失败", map[string]interface{}{"error": err.Error()})
-		return e.fail("获取 stderr 失败: " + err.Error())
-	}
-
-	if err := cmd.Start(); err != nil {
 		e.broadcastLog("error", "claude", "启动 Claude Code 失败", map[string]interface{}{"error": err.Error()})
 		return e.fail("启动 Claude Code 失败: " + err.Error())
 	}
+	e.session.Store(&sess)
 
-	e.pid.Store(int32(cmd.Process.Pid))
+	e.broadcastLog("info", "claude", "Claude Code 启动参数", map[string]interface{}{
+		"backend":        e.backend.Name(),
+		"command":        sess.Command()[0],
+		"args":           sess.Command()[1:],
+		"work_dir":       workDir,
+		"timeout_min":    e.timeoutMin,
+		"resume_session": e.resumeSessionID,
+		"budget_usd":     e.budget.LimitUSD,
+		"budget_scope":   e.budget.Scope,
+		"sandbox":        e.sandbox.Summary(),
+		"tools":          tools.Summary(),
+		"approval":       e.gate != nil,
+	})
+
+	e.pid.Store(int32(sess.PID()))
 	if e.resumeSessionID != "" {
 		e.broadcastLog("info", "claude", fmt.Sprintf("使用会话恢复模式，从 session %s 继续", e.resumeSessionID), map[string]interface{}{
 			"session_id": e.resumeSessionID,
 		})
 	}
 	e.broadcastLog("info", "claude", "Claude Code 进程已启动", map[string]interface{}{
-		"pid": cmd.Process.Pid,
+		"pid": sess.PID(),
 	})
 	e.broadcastStatus("running", "Claude Code 已启动，正在分析项目...")
 
-	// Capture stderr in background
-	var stderrBuf bytes.Buffer
-	var stderrDone sync.WaitGroup
-	stderrDone.Add(1)
-	go func() {
-		defer stderrDone.Done()
-		io.Copy(&stderrBuf, stderr)
-	}()
-
 	// Phase 4: Stream reading
-	scanner := bufio.NewScanner(stdout)
-	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
-
-	filesWritten := 0
-	filesRead := 0
-	filesEdited := 0
-	turnsUsed := 0
-	var costUSD float64
-	var lastLines []string // keep last N stdout lines for debugging
-
-	for scanner.Scan() {
-		if e.cancelled.Load() {
-			break
-		}
-
-		line := scanner.Text()
-
-		// Keep last 20 raw lines for debugging on failure
-		lastLines = append(lastLines, line)
-		if len(lastLines) > 20 {
-			lastLines = lastLines[1:]
-		}
-
-		event, err := ParseStreamJSON(line)
-		if err != nil || event == nil {
-			continue
-		}
-
-		// Capture session_id from system/init — don't broadcast to client
-		if event.Type == "system_init" {
-			if event.SessionID != "" {
-				e.db.Model(e.task).Update("session_id", event.SessionID)
-			}
-			continue
-		}
-
-		sseData := event.ToSSEData()
-		id := e.eventID.Add(1)
-		e.hub.Broadcast(int64(e.task.ID), sse.Event{
-			ID:   id,
-			Type: "output",
-			Data: sseData,
-		})
-
-		switch event.Type {
-		case "tool_use":
-			turnsUsed++
-			switch event.ToolName {
-			case "Write":
-				filesWritten++
-			case "Read", "Glob", "Grep":
-				filesRead++
-			case "Edit":
-				filesEdited++
-			}
-			pid := e.eventID.Add(1)
-			e.hub.Broadcast(int64(e.task.ID), sse.Event{
-				ID:   pid,
-				Type: "progress",
-				Data: map[string]interface{}{
-					"files_read":     filesRead,
-					"files_written":  filesWritten,
-					"files_edited":   filesEdited,
-					"turns_used":     turnsUsed,
-					"max_turns":      e.maxTurns,
-					"current_action": fmt.Sprintf("%s %s", event.ToolName, event.FilePath),
-				},
-			})
-		case "result":
-			costUSD = event.CostUSD
-		}
+	st := &streamState{meter: agent.NewCostMeter()}
+	// The coding pass of a plan-first task adds to what planning cost
+	st.meter.AddSettled(e.task.ClaudeCostUSD)
+	result, err := e.streamSession(sess, st)
+	if st.overBudget {
+		return e.fail(e.budget.exceededMessage(st.meter.Total()))
 	}
-
-	// Wait for stderr goroutine to finish before calling cmd.Wait
-	stderrDone.Wait()
-
-	if err := cmd.Wait(); err != nil {
+	if err != nil {
 		if e.cancelled.Load() {
 			return nil
 		}
-
-		stderrStr := strings.TrimSpace(stderrBuf.String())
-
-		// Build detailed error log
-		detail := map[string]interface{}{
-			"exit_error": err.Error(),
-		}
-		if stderrStr != "" {
-			detail["stderr"] = truncateStr(stderrStr, 4000)
-		}
-		if len(lastLines) > 0 {
-			detail["last_stdout_lines"] = lastLines
-		}
-
-		// Determine a human-readable failure reason from stderr
-		failReason := "Claude Code 执行失败: " + err.Error()
-		if stderrStr != "" {
-			e.broadcastLog("error", "claude", "Claude Code stderr 输出", map[string]interface{}{
-				"stderr": truncateStr(stderrStr, 4000),
-			})
-			// Use the first meaningful line of stderr as the failure summary
-			if firstLine := firstNonEmptyLine(stderrStr); firstLine != "" {
-				failReason = "Claude Code 执行失败: " + truncateStr(firstLine, 200)
-			}
-		}
-
-		e.broadcastLog("error", "claude", "Claude Code 执行失败", detail)
-		return e.fail(failReason)
+		return e.fail(e.agentFailReason(result, err))
 	}
 
 	e.broadcastLog("info", "claude", "Claude Code 执行完成", map[string]interface{}{
-		"cost_usd": costUSD,
+		"cost_usd": st.meter.Total(),
 	})
 
-	// Phase 5: Add, commit, collect diff, and push
-	commitMsg := fmt.Sprintf("feat: %s\n\nGenerated by CodeMaster (task #%d)", e.requirement.Title, e.task.ID)
-	commitSHA, hasChanges, err := gitops.AddAndCommit(ctx, workDir, commitMsg)
+	if planning {
+		return e.finishPlanning(result, st.meter.Total())
+	}
+
+	// Phase 5: Verify build/lint/test, resuming the session to fix failures
+	sessionID := result.SessionID
+	if sessionID == "" {
+		sessionID = e.resumeSessionID
+	}
+	verify, err := e.verify(ctx, workDir, req, sessionID, st)
+	if e.cancelled.Load() {
+		return nil
+	}
+	if st.overBudget {
+		return e.fail(e.budget.exceededMessage(st.meter.Total()))
+	}
+	if err != nil {
+		return e.fail(err.Error())
+	}
+	if verify != nil && !verify.Passed && !e.repo.VerifyConfig.Data.AllowPushOnFailure {
+		e.db.Model(e.task).Update("claude_cost_usd", st.meter.Total())
+		return e.fail(fmt.Sprintf("验证未通过 (已自动修复 %d 次): %s", verify.FixAttempts, verify.FailedSummary()))
+	}
+
+	// Phase 6: Commit per the project's commit policy, collect diff, and push
+	commitMsg := e.commitMessage
+	if commitMsg == "" {
+		commitMsg, _ = RenderCommitMessage(nil, NewNamingVars(e.requirement, e.task.ID))
+	}
+	commitSHA, hasChanges, err := e.commitChanges(ctx, workDir, commitMsg, st)
+	if e.cancelled.Load() {
+		return nil
+	}
+	if st.overBudget {
+		return e.fail(e.budget.exceededMessage(st.meter.Total()))
+	}
 	if err != nil {
 		e.broadcastLog("error", "push", "提交代码失败", map[string]interface{}{"error": err.Error()})
 		return e.fail("git commit 失败: " + err.Error())
 	}
+	costUSD := st.meter.Total()
 	if !hasChanges {
 		e.broadcastLog("info", "push", "Claude Code 未产生任何代码变更，视为完成", nil)
+		if e.synced {
+			// The branch still moved onto the latest source branch
+			if err := e.push(ctx, workDir, token); err != nil {
+				return e.fail("push 失败: " + err.Error())
+			}
+		}
 
 		// No changes — mark as completed with zero diff
 		completedAt := time.Now()
@@ -405,37 +409,54 @@ func (e *Executor) Run(ctx context.Context) error {
 		e.persistEvents()
 		return nil
 	}
-	e.broadcastLog("info", "push", "代码已提交", map[string]interface{}{"message": commitMsg})
+	commits, err := gitops.CommitsBetween(ctx, workDir, baseSHA, commitSHA)
+	if err != nil {
+		return e.fail("读取提交记录失败: " + err.Error())
+	}
+	e.task.Commits = commits
+	e.db.Model(e.task).Update("commits", commits)
+	e.broadcastLog("info", "push", fmt.Sprintf("代码已提交，共 %d 个 commit", len(commits)), map[string]interface{}{"commits": commits})
 
 	diffStat, _ := gitops.GetDiffStat(ctx, workDir, e.task.SourceBranch, e.task.TargetBranch)
-	diffFiles, _ := gitops.GetDiffFiles(ctx, workDir, e.task.SourceBranch, e.task.TargetBranch)
-	for i := range diffFiles {
-		if content, err := gitops.GetDiffContent(ctx, workDir, e.task.SourceBranch, e.task.TargetBranch, diffFiles[i].Path); err == nil {
-			diffFiles[i].Diff = content
+	if diffStat != nil {
+		for i := range diffStat.Files {
+			if content, err := gitops.GetFileDiff(ctx, workDir, e.task.SourceBranch, e.task.TargetBranch, diffStat.Files[i]); err == nil {
+				diffStat.Files[i].Diff = e.redactor.String(content)
+			}
 		}
 	}
+
+	// Patches of rejected or quarantined runs stay inline in the task row
+	// rather than in shared blobs; a released run is offloaded then
+	if ok, err := e.scanSecrets(ctx, workDir, baseSHA, commitSHA, costUSD, diffStat); !ok {
+		return err
+	}
+	if ok, err := e.enforceDiffPolicy(ctx, workDir, baseSHA, commitSHA, costUSD, diffStat); !ok {
+		return err
+	}
 	if diffStat != nil {
-		diffStat.Files = diffFiles
+		diffstore.Offload(e.db, diffStat.Files)
 	}
 
 	e.broadcastLog("info", "push", "正在推送代码到远程仓库", map[string]interface{}{
 		"branch": e.task.TargetBranch,
 	})
 
-	if err := gitops.Push(ctx, workDir, e.task.TargetBranch, e.repo.GitURL, token, e.useLocalGit); err != nil {
+	if err := e.push(ctx, workDir, token); err != nil {
 		e.broadcastLog("error", "push", "代码推送失败", map[string]interface{}{"error": err.Error()})
 		return e.fail("push 失败: " + err.Error())
 	}
 
 	e.broadcastLog("info", "push", "代码推送完成", nil)
 
-	// Phase 6: Complete
+	// Phase 7: Complete
 	completedAt := time.Now()
 	updates := map[string]interface{}{
 		"status":         "completed",
 		"completed_at":   &completedAt,
 		"claude_cost_usd": costUSD,
 		"commit_sha":     commitSHA,
+		"base_sha":       baseSHA,
 	}
 	if diffStat != nil {
 		updates["diff_stat"] = model.JSONDiffStat{Data: diffStat}
@@ -464,21 +485,182 @@ func (e *Executor) Run(ctx context.Context) error {
 	return nil
 }
 
+// streamState carries progress counters and spend across the sessions of one
+// task: the initial run plus any verify fix iterations.
+type streamState struct {
+	filesRead    int
+	filesWritten int
+	filesEdited  int
+	turnsUsed    int
+	meter        *agent.CostMeter
+	budgetWarned bool
+	overBudget   bool
+	// auxiliary sessions (conflict resolution, commit planning) are not the
+	// task's conversation, so their IDs are not kept for resuming
+	auxiliary bool
+}
+
+// streamSession relays a session's events to SSE, tracking progress and spend,
+// and waits for it to exit. When the budget runs out the session is cancelled
+// and st.overBudget is set.
+func (e *Executor) streamSession(sess agent.Session, st *streamState) (*agent.Result, error) {
+	for event := range sess.Events() {
+		if e.cancelled.Load() || st.overBudget {
+			// Keep draining so the reader can finish once the process exits
+			continue
+		}
+
+		if event.Type == "permission_request" {
+			e.handlePermission(sess, event)
+			continue
+		}
+
+		// Capture session_id from system/init — don't broadcast to client
+		if event.Type == "system_init" {
+			if event.SessionID != "" && !st.auxiliary {
+				e.db.Model(e.task).Update("session_id", event.SessionID)
+			}
+			continue
+		}
+
+		// Track spend live so budgets are enforced mid-session
+		if prev := st.meter.Total(); st.meter.Add(event) != prev {
+			spent := st.meter.Total()
+			e.db.Model(e.task).Update("claude_cost_usd", spent)
+			if event.Usage != nil && e.checkBudget(spent, &st.budgetWarned) {
+				st.overBudget = true
+				e.broadcastLog("error", "claude", "任务花费超出预算，正在终止 Claude Code", map[string]interface{}{
+					"cost_usd":     spent,
+					"budget_usd":   e.budget.LimitUSD,
+					"budget_scope": e.budget.Scope,
+				})
+				sess.Cancel()
+				continue
+			}
+		}
+
+		sseData := event.ToSSEData()
+		id := e.eventID.Add(1)
+		e.hub.Broadcast(int64(e.task.ID), sse.Event{
+			ID:   id,
+			Type: "output",
+			Data: sseData,
+		})
+
+		if event.Type == "tool_use" {
+			st.turnsUsed++
+			switch event.ToolName {
+			case "Write":
+				st.filesWritten++
+			case "Read", "Glob", "Grep":
+				st.filesRead++
+			case "Edit":
+				st.filesEdited++
+			}
+			pid := e.eventID.Add(1)
+			e.hub.Broadcast(int64(e.task.ID), sse.Event{
+				ID:   pid,
+				Type: "progress",
+				Data: map[string]interface{}{
+					"files_read":     st.filesRead,
+					"files_written":  st.filesWritten,
+					"files_edited":   st.filesEdited,
+					"turns_used":     st.turnsUsed,
+					"max_turns":      e.maxTurns,
+					"cost_usd":       st.meter.Total(),
+					"budget_usd":     e.budget.LimitUSD,
+					"current_action": fmt.Sprintf("%s %s", event.ToolName, event.FilePath),
+				},
+			})
+		}
+	}
+
+	if e.gate != nil {
+		// The process is gone, nobody can act on its open requests anymore
+		for _, id := range e.gate.drop(sess) {
+			eid := e.eventID.Add(1)
+			e.hub.Broadcast(int64(e.task.ID), sse.Event{ID: eid, Type: "approval_resolved", Data: map[string]interface{}{
+				"approval_id": id,
+				"approved":    false,
+				"comment":     "会话已结束",
+			}})
+		}
+	}
+
+	result, err := sess.Wait()
+	if st.overBudget {
+		e.db.Model(e.task).Update("claude_cost_usd", st.meter.Total())
+	}
+	return result, err
+}
+
+// agentFailReason logs the details of a failed agent run and returns a
+// human-readable failure summary.
+func (e *Executor) agentFailReason(result *agent.Result, err error) string {
+	stderrStr := result.Stderr
+
+	// Build detailed error log
+	detail := map[string]interface{}{
+		"exit_error": err.Error(),
+	}
+	if stderrStr != "" {
+		detail["stderr"] = truncateStr(stderrStr, 4000)
+	}
+	if len(result.LastLines) > 0 {
+		detail["last_stdout_lines"] = result.LastLines
+	}
+
+	// Determine a human-readable failure reason from stderr
+	failReason := "Claude Code 执行失败: " + err.Error()
+	if stderrStr != "" {
+		e.broadcastLog("error", "claude", "Claude Code stderr 输出", map[string]interface{}{
+			"stderr": truncateStr(stderrStr, 4000),
+		})
+		// Use the first meaningful line of stderr as the failure summary
+		if firstLine := firstNonEmptyLine(stderrStr); firstLine != "" {
+			failReason = "Claude Code 执行失败: " + truncateStr(firstLine, 200)
+		}
+	}
+
+	e.broadcastLog("error", "claude", "Claude Code 执行失败", detail)
+	return failReason
+}
+
+// checkBudget fires the warning callback once spend crosses the warn ratio
+// and reports whether the task has exhausted its budget.
+func (e *Executor) checkBudget(spent float64, warned *bool) bool {
+	b := e.budget
+	if b.LimitUSD <= 0 {
+		return false
+	}
+	if spent >= b.LimitUSD {
+		if b.OnWarn != nil {
+			go b.OnWarn(spent, b.LimitUSD, true)
+		}
+		return true
+	}
+	if !*warned && b.WarnRatio > 0 && spent >= b.LimitUSD*b.WarnRatio {
+		*warned = true
+		e.broadcastLog("warn", "claude", fmt.Sprintf("任务花费已达%s预算的 %.0f%%", b.ScopeLabel(), b.WarnRatio*100), map[string]interface{}{
+			"cost_usd":   spent,
+			"budget_usd": b.LimitUSD,
+		})
+		if b.OnWarn != nil {
+			go b.OnWarn(spent, b.LimitUSD, false)
+		}
+	}
+	return false
+}
+
 func (e *Executor) Cancel() error {
 	e.cancelled.Store(true)
-	pid := e.pid.Load()
-	if pid == 0 {
+	sess := e.session.Load()
+	if sess == nil {
 		return fmt.Errorf("task not running")
 	}
-	process, err := os.FindProcess(int(pid))
-	if err != nil {
+	if err := (*sess).Cancel(); err != nil {
 		return err
 	}
-	process.Signal(os.Interrupt)
-
-	time.AfterFunc(3*time.Second, func() {
-		process.Kill()
-	})
 
 	e.updateStatus("cancelled")
 	completedAt := time.Now()
@@ -495,6 +677,30 @@ func (e *Executor) Cancel() error {
 	return nil
 }
 
+// SendInput forwards a follow-up instruction to the running agent session and
+// records it in the task's event stream.
+func (e *Executor) SendInput(message string, userID uint, userName string) error {
+	sess := e.session.Load()
+	if sess == nil || e.cancelled.Load() {
+		return agent.ErrSessionFinished
+	}
+	if err := (*sess).Send(message); err != nil {
+		return err
+	}
+	id := e.eventID.Add(1)
+	e.hub.Broadcast(int64(e.task.ID), sse.Event{
+		ID:   id,
+		Type: "user_input",
+		Data: map[string]interface{}{
+			"message":   message,
+			"user_id":   userID,
+			"user_name": userName,
+			"sent_at":   time.Now(),
+		},
+	})
+	return nil
+}
+
 func (e *Executor) GetPID() int32 {
 	return e.pid.Load()
 }
@@ -544,6 +750,7 @@ func (e *Executor) broadcastLog(level, phase, message string, detail map[string]
 }
 
 func (e *Executor) fail(errMsg string) error {
+	errMsg = e.redactor.String(errMsg)
 	e.db.Model(e.task).Updates(map[string]interface{}{
 		"status":        "failed",
 		"error_message": errMsg,
@@ -624,3 +831,53 @@ func firstNonEmptyLine(s string) string {
 	}
 	return ""
 }
+
+// relocateSession makes the resumed session, recorded in an earlier task's
+// workspace and HOME, resumable from workDir in this task's HOME.
+func (e *Executor) relocateSession(workDir string) {
+	r, ok := e.backend.(agent.SessionRelocator)
+	if !ok || e.resumeWorkDir == "" {
+		return
+	}
+	if err := r.RelocateSession(e.resumeHomeDir, e.sessionDir, e.resumeSessionID, e.resumeWorkDir, workDir); err != nil {
+		e.broadcastLog("warn", "claude", "迁移历史会话到新工作区失败，恢复可能找不到会话", map[string]interface{}{
+			"session_id": e.resumeSessionID,
+			"from":       e.resumeWorkDir,
+			"error":      err.Error(),
+		})
+	}
+}
+
+// finishPlanning stores the plan produced by the planning pass and parks the
+// task until a reviewer approves or rejects it.
+func (e *Executor) finishPlanning(result *agent.Result, costUSD float64) error {
+	plan := &model.ImplementationPlan{}
+	if raw := claude.ExtractJSON([]byte(result.Text)); raw == nil || json.Unmarshal(raw, plan) != nil || len(plan.Steps) == 0 {
+		// Keep whatever the agent wrote so the reviewer can still edit it into shape
+		e.broadcastLog("warn", "plan", "未能解析结构化方案，已保存原始输出", nil)
+		plan = &model.ImplementationPlan{Summary: strings.TrimSpace(result.Text)}
+	}
+
+	e.db.Model(e.task).Updates(map[string]interface{}{
+		"status":          "awaiting_approval",
+		"plan":            model.JSONImplementationPlan{Data: plan},
+		"plan_status":     model.PlanStatusPending,
+		"claude_cost_usd": costUSD,
+	})
+	e.broadcastLog("info", "plan", "实现方案已生成，等待评审", map[string]interface{}{
+		"steps": len(plan.Steps),
+	})
+
+	id := e.eventID.Add(1)
+	e.hub.Broadcast(int64(e.task.ID), sse.Event{ID: id, Type: "plan", Data: map[string]interface{}{
+		"plan":     plan,
+		"cost_usd": costUSD,
+	}})
+	doneID := e.eventID.Add(1)
+	e.hub.Broadcast(int64(e.task.ID), sse.Event{ID: doneID, Type: "done", Data: map[string]interface{}{
+		"task_id": e.task.ID,
+		"status":  "awaiting_approval",
+	}})
+	e.persistEvents()
+	return nil
+}
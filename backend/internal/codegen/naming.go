@@ -0,0 +1,221 @@
+package codegen
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/codeMaster/backend/internal/model"
+)
+
+// Built-in templates, used for the parts a project leaves empty.
+const (
+	DefaultBranchTemplate  = "code-master/req-{req_id}"
+	DefaultCommitTemplate  = "{type}: {title}\n\nGenerated by CodeMaster (task #{task_id})"
+	DefaultMRTitleTemplate = "{type}(req-{req_id}): {title}"
+)
+
+// maxSlugLen caps {slug} so branch names stay readable.
+const maxSlugLen = 40
+
+var templateVar = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// templateVars lists the variables of each template kind. The branch is
+// named once per requirement, so it cannot depend on the task.
+var templateVars = map[string][]string{
+	"branch":   {"req_id", "slug", "assignee", "type"},
+	"commit":   {"req_id", "slug", "assignee", "type", "title", "task_id"},
+	"mr_title": {"req_id", "slug", "assignee", "type", "title", "task_id"},
+}
+
+// NamingVars are the values templates are rendered with.
+type NamingVars struct {
+	RequirementID uint
+	Title         string
+	Type          string
+	Assignee      string
+	TaskID        uint
+}
+
+// NewNamingVars collects the template values of a requirement; its Assignee
+// must be loaded.
+func NewNamingVars(requirement *model.Requirement, taskID uint) NamingVars {
+	v := NamingVars{
+		RequirementID: requirement.ID,
+		Title:         requirement.Title,
+		Type:          requirement.Type,
+		TaskID:        taskID,
+	}
+	if v.Type == "" {
+		v.Type = "feat"
+	}
+	if a := requirement.Assignee; a != nil {
+		// The mailbox name is usually the handle people know each other by
+		if at := strings.IndexByte(a.Email, '@'); at > 0 {
+			v.Assignee = a.Email[:at]
+		} else {
+			v.Assignee = a.Name
+		}
+	}
+	return v
+}
+
+func (v NamingVars) lookup(name string) string {
+	switch name {
+	case "req_id":
+		return strconv.FormatUint(uint64(v.RequirementID), 10)
+	case "slug":
+		if s := Slugify(v.Title); s != "" {
+			return s
+		}
+		return fmt.Sprintf("req-%d", v.RequirementID)
+	case "assignee":
+		return v.Assignee
+	case "type":
+		return v.Type
+	case "title":
+		return v.Title
+	case "task_id":
+		return strconv.FormatUint(uint64(v.TaskID), 10)
+	}
+	return ""
+}
+
+// Slugify keeps the ASCII letters and digits of s, lowercased and joined by
+// dashes, e.g. "Add OAuth2 login 登录" becomes "add-oauth2-login".
+func Slugify(s string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	slug := sb.String()
+	if len(slug) > maxSlugLen {
+		slug = slug[:maxSlugLen]
+		if i := strings.LastIndexByte(slug, '-'); i > maxSlugLen/2 {
+			slug = slug[:i]
+		}
+		slug = strings.TrimRight(slug, "-")
+	}
+	return slug
+}
+
+// ValidateGitTemplates checks project templates before they are stored: only
+// known variables, and the branch template must give a valid branch name.
+func ValidateGitTemplates(t *model.GitTemplates) error {
+	for _, kt := range [][2]string{{"branch", t.Branch}, {"commit", t.Commit}, {"mr_title", t.MRTitle}} {
+		kind, tmpl := kt[0], kt[1]
+		if tmpl == "" {
+			continue
+		}
+		if strings.TrimSpace(tmpl) == "" {
+			return fmt.Errorf("%s 模板不能只包含空白", kind)
+		}
+		for _, m := range templateVar.FindAllStringSubmatch(tmpl, -1) {
+			if !slices.Contains(templateVars[kind], m[1]) {
+				return fmt.Errorf("%s 模板不支持变量 {%s}，可用变量: {%s}", kind, m[1], strings.Join(templateVars[kind], "} {"))
+			}
+		}
+	}
+	if t.MRTitle != "" && strings.Contains(t.MRTitle, "\n") {
+		return fmt.Errorf("mr_title 模板不能包含换行")
+	}
+	if t.Branch != "" {
+		sample := NamingVars{RequirementID: 1, Title: "sample", Type: "feat", Assignee: "dev"}
+		if _, err := RenderBranch(t, sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderBranch names the branch of a requirement. Values are made safe for
+// ref names; the result must still be a valid branch name.
+func RenderBranch(t *model.GitTemplates, v NamingVars) (string, error) {
+	tmpl := DefaultBranchTemplate
+	if t != nil && t.Branch != "" {
+		tmpl = t.Branch
+	}
+	name := render(tmpl, func(name string) string { return refSafe(v.lookup(name)) })
+	if !ValidBranchName(name) {
+		return "", fmt.Errorf("分支名模板生成的分支名 %q 无效", name)
+	}
+	return name, nil
+}
+
+// RenderCommitMessage renders the commit message of a task.
+func RenderCommitMessage(t *model.GitTemplates, v NamingVars) (string, error) {
+	tmpl := DefaultCommitTemplate
+	if t != nil && t.Commit != "" {
+		tmpl = t.Commit
+	}
+	msg := strings.TrimSpace(render(tmpl, v.lookup))
+	if msg == "" {
+		return "", fmt.Errorf("提交信息模板生成的提交信息为空")
+	}
+	return msg, nil
+}
+
+// RenderMRTitle renders the title of a task's merge request.
+func RenderMRTitle(t *model.GitTemplates, v NamingVars) (string, error) {
+	tmpl := DefaultMRTitleTemplate
+	if t != nil && t.MRTitle != "" {
+		tmpl = t.MRTitle
+	}
+	title := strings.TrimSpace(render(tmpl, v.lookup))
+	if title == "" {
+		return "", fmt.Errorf("合并请求标题模板生成的标题为空")
+	}
+	return title, nil
+}
+
+func render(tmpl string, value func(string) string) string {
+	return templateVar.ReplaceAllStringFunc(tmpl, func(m string) string {
+		return value(m[1 : len(m)-1])
+	})
+}
+
+// refSafe replaces what git does not allow in ref names with dashes.
+func refSafe(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f || strings.ContainsRune("~^:?*[\\/", r) {
+			return '-'
+		}
+		return r
+	}, s)
+	for strings.Contains(s, "..") {
+		s = strings.ReplaceAll(s, "..", ".")
+	}
+	return strings.ReplaceAll(s, "@{", "@-")
+}
+
+// ValidBranchName follows `git check-ref-format --branch`.
+func ValidBranchName(name string) bool {
+	if name == "" || name == "@" || strings.HasPrefix(name, "-") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "@{") ||
+		strings.Contains(name, "//") {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r == 0x7f || strings.ContainsRune("~^:?*[\\", r) {
+			return false
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return false
+		}
+	}
+	return true
+}
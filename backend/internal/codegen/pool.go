@@ -1,40 +1,112 @@
 package codegen
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/codeMaster/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type TaskFunc func()
+// ErrNotQueued is returned when a queue item does not exist or was already claimed by a worker.
+var ErrNotQueued = errors.New("queue item not found or already claimed")
+
+// waitingStatuses are the queue states an item can be in before a worker claims it.
+var waitingStatuses = []string{"queued", "paused"}
+
+// TaskHandler executes a claimed queue item. The pool removes the item from
+// the queue once the handler returns.
+type TaskHandler func(item *model.CodegenQueueItem)
 
+// claimWindow bounds how many queued items are considered per scheduling decision.
+const claimWindow = 200
+
+// Pool is a fixed set of workers consuming the persisted codegen queue.
+// Pending work lives in the codegen_queue table, so it survives restarts.
+//
+// Items are scheduled by requirement priority first. Within a priority, the
+// project and then the user with the fewest running tasks go first, so a
+// single submitter cannot monopolize the workers. Optional per-project and
+// per-user caps hold back items whose owner already has enough tasks running.
+// At most one task per requirement runs at a time, since they share a branch.
 type Pool struct {
-	maxWorkers int
-	taskQueue  chan TaskFunc
-	wg         sync.WaitGroup
-	quit       chan struct{}
+	db            *gorm.DB
+	maxWorkers    int
+	maxPerProject int
+	maxPerUser    int
+	pollInterval  time.Duration
+	claimMu       sync.Mutex
+	wake          chan struct{}
+	wg            sync.WaitGroup
+	quit          chan struct{}
+}
+
+type PoolConfig struct {
+	MaxWorkers    int
+	MaxPerProject int // 0 = unlimited
+	MaxPerUser    int // 0 = unlimited
+}
+
+func NewPool(db *gorm.DB, cfg PoolConfig) *Pool {
+	if cfg.MaxWorkers < 1 {
+		cfg.MaxWorkers = 1
+	}
+	return &Pool{
+		db:            db,
+		maxWorkers:    cfg.MaxWorkers,
+		maxPerProject: cfg.MaxPerProject,
+		maxPerUser:    cfg.MaxPerUser,
+		pollInterval:  5 * time.Second,
+		wake:          make(chan struct{}, cfg.MaxWorkers),
+		quit:          make(chan struct{}),
+	}
 }
 
-func NewPool(maxWorkers int) *Pool {
-	p := &Pool{
-		maxWorkers: maxWorkers,
-		taskQueue:  make(chan TaskFunc, 100),
-		quit:       make(chan struct{}),
+// PriorityRank maps a requirement priority ("p0", "p1", ...) to its sort rank.
+// Unknown values rank as p1, the requirement default.
+func PriorityRank(priority string) int {
+	p := strings.ToLower(strings.TrimSpace(priority))
+	if n, err := strconv.Atoi(strings.TrimPrefix(p, "p")); err == nil && strings.HasPrefix(p, "p") && n >= 0 {
+		return n
 	}
-	p.start()
-	return p
+	return 1
 }
 
-func (p *Pool) start() {
+// Start launches the workers. It must be called once, after any startup
+// reconciliation of the queue has been done.
+func (p *Pool) Start(handler TaskHandler) {
 	for i := 0; i < p.maxWorkers; i++ {
 		p.wg.Add(1)
 		go func() {
 			defer p.wg.Done()
+			ticker := time.NewTicker(p.pollInterval)
+			defer ticker.Stop()
 			for {
-				select {
-				case task, ok := <-p.taskQueue:
-					if !ok {
+				// Drain the queue before going back to sleep
+				for {
+					item, err := p.claimNext()
+					if err != nil {
+						if !errors.Is(err, gorm.ErrRecordNotFound) {
+							log.Printf("[pool] claim queue item failed: %v", err)
+						}
+						break
+					}
+					p.run(handler, item)
+					select {
+					case <-p.quit:
 						return
+					default:
 					}
-					task()
+				}
+				select {
+				case <-p.wake:
+				case <-ticker.C:
 				case <-p.quit:
 					return
 				}
@@ -43,13 +115,352 @@ func (p *Pool) start() {
 	}
 }
 
-func (p *Pool) Submit(task TaskFunc) int {
-	p.taskQueue <- task
-	return len(p.taskQueue)
+func (p *Pool) run(handler TaskHandler, item *model.CodegenQueueItem) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[pool] task %d panicked: %v", item.TaskID, r)
+			// Nothing is left to finish the task: fail it and free the requirement
+			now := time.Now()
+			res := p.db.Model(&model.CodegenTask{}).
+				Where("id = ? AND status IN ?", item.TaskID, []string{"pending", "cloning", "running", "awaiting_approval"}).
+				Updates(map[string]interface{}{
+					"status":        "failed",
+					"error_message": fmt.Sprintf("执行器异常退出: %v", r),
+					"completed_at":  &now,
+				})
+			if res.RowsAffected > 0 {
+				p.db.Model(&model.Requirement{}).Where("id = ? AND status = ?", item.RequirementID, "generating").Update("status", "draft")
+			}
+		}
+		p.db.Delete(&model.CodegenQueueItem{}, item.ID)
+	}()
+	handler(item)
+}
+
+// claimNext atomically moves the next schedulable queued item to "claimed".
+// Returns gorm.ErrRecordNotFound when nothing can be scheduled right now.
+func (p *Pool) claimNext() (*model.CodegenQueueItem, error) {
+	// Serialize scheduling decisions so concurrency caps are not overrun
+	p.claimMu.Lock()
+	defer p.claimMu.Unlock()
+
+	var item model.CodegenQueueItem
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		var candidates []model.CodegenQueueItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", "queued").
+			Order("priority asc, sort_key asc, id asc").
+			Limit(claimWindow).
+			Find(&candidates).Error; err != nil {
+			return err
+		}
+		if len(candidates) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var running []model.CodegenQueueItem
+		if err := tx.Select("project_id", "user_id", "requirement_id").Where("status = ?", "claimed").Find(&running).Error; err != nil {
+			return err
+		}
+		byProject := make(map[uint]int)
+		byUser := make(map[uint]int)
+		busy := make(map[uint]bool)
+		for _, r := range running {
+			byProject[r.ProjectID]++
+			byUser[r.UserID]++
+			busy[r.RequirementID] = true
+		}
+
+		picked := pickCandidate(candidates, byProject, byUser, busy, p.maxPerProject, p.maxPerUser)
+		if picked < 0 {
+			return gorm.ErrRecordNotFound
+		}
+		item = candidates[picked]
+
+		now := time.Now()
+		item.Status = "claimed"
+		item.ClaimedAt = &now
+		item.Attempts++
+		return tx.Model(&item).Updates(map[string]interface{}{
+			"status":     item.Status,
+			"claimed_at": item.ClaimedAt,
+			"attempts":   item.Attempts,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// pickCandidate returns the index of the item to run next, or -1 if every
+// candidate is held back by a concurrency cap or a running task of the same
+// requirement. Candidates must be ordered by priority, then queue order.
+func pickCandidate(candidates []model.CodegenQueueItem, byProject, byUser map[uint]int, busy map[uint]bool, maxPerProject, maxPerUser int) int {
+	best := -1
+	for i, c := range candidates {
+		if busy[c.RequirementID] {
+			continue
+		}
+		if maxPerProject > 0 && byProject[c.ProjectID] >= maxPerProject {
+			continue
+		}
+		if maxPerUser > 0 && c.UserID > 0 && byUser[c.UserID] >= maxPerUser {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		b := candidates[best]
+		if c.Priority != b.Priority {
+			// Candidates are sorted, so nothing after this can beat best
+			break
+		}
+		if byProject[c.ProjectID] < byProject[b.ProjectID] ||
+			(byProject[c.ProjectID] == byProject[b.ProjectID] && byUser[c.UserID] < byUser[b.UserID]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Submit persists a queue item and wakes an idle worker.
+// Returns the number of queued items ahead of this one.
+func (p *Pool) Submit(item *model.CodegenQueueItem) (int, error) {
+	item.Status = "queued"
+	if item.EnqueuedAt.IsZero() {
+		item.EnqueuedAt = time.Now()
+	}
+	item.SortKey = item.EnqueuedAt.UnixNano()
+	if err := p.db.Create(item).Error; err != nil {
+		return 0, err
+	}
+
+	var pos int64
+	p.db.Model(&model.CodegenQueueItem{}).
+		Where("status = ? AND (priority < ? OR (priority = ? AND sort_key < ?))", "queued", item.Priority, item.Priority, item.SortKey).
+		Count(&pos)
+
+	p.signal()
+	return int(pos), nil
+}
+
+// Remove deletes a queue item that has not been claimed yet.
+// Returns false if the item was already picked up by a worker.
+func (p *Pool) Remove(taskID uint) bool {
+	res := p.db.Where("task_id = ? AND status IN ?", taskID, waitingStatuses).Delete(&model.CodegenQueueItem{})
+	return res.RowsAffected > 0
+}
+
+// Waiting returns the unclaimed queue items in execution order, optionally
+// restricted to the given projects (nil means all projects). Positions are
+// counted over the whole queue and over each project's items.
+func (p *Pool) Waiting(projectIDs []uint) ([]model.CodegenQueueItem, error) {
+	var all []model.CodegenQueueItem
+	if err := p.db.Select("task_id", "project_id").Where("status IN ?", waitingStatuses).
+		Order("priority asc, sort_key asc, id asc").Find(&all).Error; err != nil {
+		return nil, err
+	}
+
+	query := p.db.Where("status IN ?", waitingStatuses)
+	if projectIDs != nil {
+		query = query.Where("project_id IN ?", projectIDs)
+	}
+	var items []model.CodegenQueueItem
+	if err := query.Preload("Requirement").Preload("Project").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	byTask := make(map[uint]*model.CodegenQueueItem, len(items))
+	for i := range items {
+		byTask[items[i].TaskID] = &items[i]
+	}
+
+	// Items enqueued between the two queries have no position and are left out
+	ordered := make([]model.CodegenQueueItem, 0, len(items))
+	perProject := make(map[uint]int)
+	for i, it := range all {
+		perProject[it.ProjectID]++
+		if item, ok := byTask[it.TaskID]; ok {
+			item.Position = i + 1
+			item.ProjectPosition = perProject[it.ProjectID]
+			ordered = append(ordered, *item)
+		}
+	}
+	return ordered, nil
+}
+
+// Get returns the unclaimed queue item of a task.
+func (p *Pool) Get(taskID uint) (*model.CodegenQueueItem, error) {
+	var item model.CodegenQueueItem
+	if err := p.db.Preload("Project").Where("task_id = ? AND status IN ?", taskID, waitingStatuses).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotQueued
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Running returns the number of items currently claimed by workers.
+func (p *Pool) Running() int {
+	var count int64
+	p.db.Model(&model.CodegenQueueItem{}).Where("status = ?", "claimed").Count(&count)
+	return int(count)
+}
+
+// MaxWorkers returns the configured number of workers.
+func (p *Pool) MaxWorkers() int {
+	return p.maxWorkers
+}
+
+// SetPaused pauses or resumes an unclaimed item. Paused items keep their
+// place in the queue but are skipped by workers.
+func (p *Pool) SetPaused(taskID uint, paused bool) error {
+	from, to := "queued", "paused"
+	if !paused {
+		from, to = "paused", "queued"
+	}
+	res := p.db.Model(&model.CodegenQueueItem{}).
+		Where("task_id = ? AND status IN ?", taskID, waitingStatuses).
+		Update("status", to)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// Either already in the target state or no longer waiting
+		if _, err := p.Get(taskID); err != nil {
+			return err
+		}
+	}
+	if from == "paused" {
+		p.signal()
+	}
+	return nil
+}
+
+// Move places an unclaimed item at the given 1-based position among the
+// waiting items. Positions out of range are clamped. The moved item takes
+// the priority of its new neighbour, so a manual reorder wins over the
+// requirement priority it was queued with.
+//
+// With withinProject set, position counts among the waiting items of the
+// item's project only, and those items trade places among themselves: items
+// of other projects keep theirs.
+func (p *Pool) Move(taskID uint, position int, withinProject bool) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		var items []model.CodegenQueueItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status IN ?", waitingStatuses).
+			Order("priority asc, sort_key asc, id asc").
+			Find(&items).Error; err != nil {
+			return err
+		}
+
+		idx := -1
+		for i := range items {
+			if items[i].TaskID == taskID {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return ErrNotQueued
+		}
+
+		// Reuse the existing keys in order so the moved block stays ahead of later submissions
+		keys := make([]int64, len(items))
+		for i := range items {
+			keys[i] = items[i].SortKey
+			if i > 0 && keys[i] <= keys[i-1] {
+				keys[i] = keys[i-1] + 1
+			}
+		}
+
+		// slots are the places being reordered: the whole queue, or the
+		// places held by the project's items
+		var slots []int
+		for i := range items {
+			if !withinProject || items[i].ProjectID == items[idx].ProjectID {
+				slots = append(slots, i)
+			}
+		}
+		from := 0
+		for j, i := range slots {
+			if i == idx {
+				from = j
+			}
+		}
+		if position < 1 {
+			position = 1
+		}
+		if position > len(slots) {
+			position = len(slots)
+		}
+
+		moving := make([]model.CodegenQueueItem, 0, len(slots))
+		for j, i := range slots {
+			if j != from {
+				moving = append(moving, items[i])
+			}
+		}
+		moving = append(moving[:position-1], append([]model.CodegenQueueItem{items[idx]}, moving[position-1:]...)...)
+
+		ordered := append([]model.CodegenQueueItem{}, items...)
+		priorities := make([]int, len(items))
+		for i := range items {
+			priorities[i] = items[i].Priority
+		}
+		for j, i := range slots {
+			ordered[i] = moving[j]
+			if withinProject {
+				// Each item takes over the priority of the place it moves into
+				priorities[i] = items[i].Priority
+			} else {
+				priorities[i] = moving[j].Priority
+			}
+		}
+		if !withinProject {
+			pos := position - 1
+			if pos+1 < len(ordered) {
+				priorities[pos] = ordered[pos+1].Priority
+			} else if pos > 0 {
+				priorities[pos] = ordered[pos-1].Priority
+			}
+		}
+
+		for i := range ordered {
+			updates := map[string]interface{}{}
+			if ordered[i].SortKey != keys[i] {
+				updates["sort_key"] = keys[i]
+			}
+			if ordered[i].Priority != priorities[i] {
+				updates["priority"] = priorities[i]
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			if err := tx.Model(&model.CodegenQueueItem{}).Where("id = ?", ordered[i].ID).
+				Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// signal wakes an idle worker, if any.
+func (p *Pool) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
 }
 
 func (p *Pool) QueueSize() int {
-	return len(p.taskQueue)
+	var count int64
+	p.db.Model(&model.CodegenQueueItem{}).Where("status = ?", "queued").Count(&count)
+	return int(count)
 }
 
 func (p *Pool) Shutdown() {
@@ -18,7 +18,90 @@ func BuildPrompt(input PromptInput) string {
 	var sb strings.Builder
 
 	sb.WriteString("你是一位资深软件工程师，正在为一个实际项目编写代码。请严格遵循项目现有的技术栈和代码风格。\n\n")
+	writeRequirementContext(&sb, input)
 
+	sb.WriteString("## 编码要求\n\n")
+	sb.WriteString("1. 在编写代码前，先阅读相关现有文件了解项目结构和风格\n")
+	sb.WriteString("2. 严格遵循项目现有代码风格（命名、目录结构、错误处理方式）\n")
+	sb.WriteString("3. 为新增功能编写单元测试\n")
+	sb.WriteString("4. 完成编码后执行编译/构建命令确保无语法错误\n")
+	sb.WriteString("5. 不要修改与需求无关的文件\n")
+
+	return sb.String()
+}
+
+// BuildPlanPrompt asks for an implementation plan only, as JSON matching
+// model.ImplementationPlan. The plan phase runs with read-only tools.
+func BuildPlanPrompt(input PromptInput) string {
+	var sb strings.Builder
+
+	sb.WriteString("你是一位资深软件工程师，需要为一个实际项目的需求制定实现方案。本阶段只阅读代码、输出方案，不要修改任何文件。\n\n")
+	writeRequirementContext(&sb, input)
+
+	sb.WriteString("## 方案要求\n\n")
+	sb.WriteString("1. 先阅读相关现有文件，了解项目结构、风格以及可复用的已有代码\n")
+	sb.WriteString("2. 按实施顺序拆分步骤，每一步写明要新增或修改的文件\n")
+	sb.WriteString("3. 指出潜在风险和对现有功能的影响\n")
+	sb.WriteString("4. 列出需要补充的测试\n\n")
+	sb.WriteString("## 输出格式\n\n")
+	sb.WriteString("最终只输出如下 JSON，不要包含其它内容:\n\n")
+	sb.WriteString("```json\n")
+	sb.WriteString(`{
+  "summary": "方案概述",
+  "steps": [
+    {"title": "步骤标题", "description": "具体做法", "files": ["path/to/file.go"]}
+  ],
+  "risks": ["风险或影响"],
+  "tests": ["需要补充的测试"]
+}`)
+	sb.WriteString("\n```\n")
+
+	return sb.String()
+}
+
+// BuildPlanApprovedPrompt resumes the plan session with the approved (and
+// possibly edited) plan and asks it to implement it.
+func BuildPlanApprovedPrompt(plan *model.ImplementationPlan, comment string) string {
+	var sb strings.Builder
+	sb.WriteString("你的实现方案已经评审通过，请严格按照下面的最终方案编写代码。方案可能被评审人修改过，以此版本为准。\n\n")
+	sb.WriteString("## 最终方案\n\n")
+	sb.WriteString(plan.Summary)
+	sb.WriteString("\n\n")
+	for i, step := range plan.Steps {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, step.Title))
+		if step.Description != "" {
+			sb.WriteString(fmt.Sprintf("   %s\n", step.Description))
+		}
+		if len(step.Files) > 0 {
+			sb.WriteString(fmt.Sprintf("   涉及文件: %s\n", strings.Join(step.Files, ", ")))
+		}
+	}
+	if len(plan.Risks) > 0 {
+		sb.WriteString("\n注意风险:\n")
+		for _, r := range plan.Risks {
+			sb.WriteString(fmt.Sprintf("- %s\n", r))
+		}
+	}
+	if len(plan.Tests) > 0 {
+		sb.WriteString("\n需要补充的测试:\n")
+		for _, t := range plan.Tests {
+			sb.WriteString(fmt.Sprintf("- %s\n", t))
+		}
+	}
+	if comment != "" {
+		sb.WriteString("\n## 评审意见\n\n")
+		sb.WriteString(comment)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n## 编码要求\n\n")
+	sb.WriteString("1. 严格遵循项目现有代码风格（命名、目录结构、错误处理方式）\n")
+	sb.WriteString("2. 为新增功能编写单元测试\n")
+	sb.WriteString("3. 完成编码后执行编译/构建命令确保无语法错误\n")
+	sb.WriteString("4. 不要修改方案和需求以外的文件\n")
+	return sb.String()
+}
+
+func writeRequirementContext(sb *strings.Builder, input PromptInput) {
 	if input.RepoAnalysis != nil {
 		sb.WriteString("## 项目上下文\n\n")
 		if len(input.RepoAnalysis.TechStack) > 0 {
@@ -61,13 +144,81 @@ func BuildPrompt(input PromptInput) string {
 		sb.WriteString(input.ExtraContext)
 		sb.WriteString("\n\n")
 	}
+}
 
-	sb.WriteString("## 编码要求\n\n")
-	sb.WriteString("1. 在编写代码前，先阅读相关现有文件了解项目结构和风格\n")
-	sb.WriteString("2. 严格遵循项目现有代码风格（命名、目录结构、错误处理方式）\n")
-	sb.WriteString("3. 为新增功能编写单元测试\n")
-	sb.WriteString("4. 完成编码后执行编译/构建命令确保无语法错误\n")
-	sb.WriteString("5. 不要修改与需求无关的文件\n")
+// BuildVerifyFixPrompt asks the resumed session to fix a failed verify command.
+func BuildVerifyFixPrompt(run *model.VerifyRun) string {
+	var sb strings.Builder
+	sb.WriteString("你刚才完成的代码未通过项目的验证命令，请修复。\n\n")
+	sb.WriteString(fmt.Sprintf("## 失败命令: %s\n\n", run.Name))
+	sb.WriteString(fmt.Sprintf("```\n$ %s\n```\n\n", run.Command))
+	sb.WriteString(fmt.Sprintf("退出码: %d\n\n", run.ExitCode))
+	sb.WriteString("## 输出\n\n```\n")
+	sb.WriteString(run.Output)
+	sb.WriteString("\n```\n\n")
+	sb.WriteString("## 修复要求\n\n")
+	sb.WriteString("1. 根据输出定位根因并修改代码，确保该命令能够通过\n")
+	sb.WriteString("2. 不要删除、跳过或弱化已有的测试和检查规则来绕过失败\n")
+	sb.WriteString("3. 修复后自行运行该命令确认通过\n")
+	return sb.String()
+}
+
+// BuildConflictPrompt asks a fresh session to resolve the conflicts of
+// merging the source branch into the requirement branch.
+func BuildConflictPrompt(sourceBranch string, conflicts []string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("当前分支正在合并源分支 %s 的最新提交，以下文件存在合并冲突，请解决。\n\n", sourceBranch))
+	sb.WriteString("## 冲突文件\n\n")
+	for _, f := range conflicts {
+		sb.WriteString(fmt.Sprintf("- %s\n", f))
+	}
+	sb.WriteString("\n## 解决要求\n\n")
+	sb.WriteString("1. 逐个查看冲突标记 (<<<<<<< / ======= / >>>>>>>)，理解双方改动的意图后合并，同时保留两边需要的功能\n")
+	sb.WriteString("2. 解决后文件中不能残留任何冲突标记\n")
+	sb.WriteString("3. 不要修改冲突文件以外的内容，不要执行 git commit、git merge --abort 或 git reset\n")
+	return sb.String()
+}
 
+// BuildCommitPlanPrompt asks for commit messages written from the staged
+// diff, as JSON. groups fixes which files go into each commit; without it the
+// agent also splits the changes into logical commits. example is the message
+// the project's commit template gives, for its conventions.
+func BuildCommitPlanPrompt(files []string, groups [][]string, diff, example string) string {
+	var sb strings.Builder
+	sb.WriteString("本次代码生成的改动已暂存，需要提交到 Git。请根据实际改动编写提交信息。本阶段只阅读代码，不要修改任何文件，也不要执行 git 命令。\n\n")
+	if len(groups) > 0 {
+		sb.WriteString("## 提交划分\n\n")
+		sb.WriteString(fmt.Sprintf("改动按以下划分提交为 %d 个 commit，请按顺序为每个 commit 编写提交信息，不要调整划分:\n\n", len(groups)))
+		for i, g := range groups {
+			sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, strings.Join(g, ", ")))
+		}
+	} else {
+		sb.WriteString("## 改动文件\n\n")
+		for _, f := range files {
+			sb.WriteString(fmt.Sprintf("- %s\n", f))
+		}
+		sb.WriteString("\n## 提交划分\n\n")
+		sb.WriteString("请把改动拆分为若干个逻辑独立、便于逐个 review 的 commit，按合理的先后顺序排列 (例如先基础设施和数据模型，再业务逻辑，最后测试和文档)。每个文件只能属于一个 commit，所有改动文件都必须包含在内。改动本身很小时一个 commit 即可。\n")
+	}
+	sb.WriteString("\n## 提交信息要求\n\n")
+	sb.WriteString("1. 描述该 commit 实际做了什么，而不是复述需求\n")
+	sb.WriteString("2. 第一行为不超过 72 个字符的摘要，需要时空一行后补充说明\n")
+	sb.WriteString("3. 遵循项目的提交信息格式，以下是按项目模板生成的示例:\n\n")
+	sb.WriteString("```\n")
+	sb.WriteString(example)
+	sb.WriteString("\n```\n\n")
+	sb.WriteString("## 暂存的改动\n\n")
+	sb.WriteString("```diff\n")
+	sb.WriteString(diff)
+	sb.WriteString("\n```\n\n")
+	sb.WriteString("## 输出格式\n\n")
+	sb.WriteString("最终只输出如下 JSON，不要包含其它内容:\n\n")
+	sb.WriteString("```json\n")
+	sb.WriteString(`{
+  "commits": [
+    {"files": ["path/to/file.go"], "message": "提交信息"}
+  ]
+}`)
+	sb.WriteString("\n```\n")
 	return sb.String()
 }
@@ -0,0 +1,69 @@
+package codegen
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codeMaster/backend/internal/gitops"
+	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/internal/secrets"
+)
+
+// maskInjected registers the credentials handed to this task, so they are
+// masked in its events, logs and stored output whatever the agent does with
+// them.
+func (e *Executor) maskInjected(token string) {
+	values := []string{token, e.gitToken, e.apiKey}
+	if e.identity != nil && e.identity.Signing != nil {
+		values = append(values, e.identity.Signing.PrivateKey)
+	}
+	if e.toolPolicy != nil {
+		for _, srv := range e.toolPolicy.MCPServers {
+			for _, v := range srv.Env {
+				values = append(values, v)
+			}
+			for _, v := range srv.Headers {
+				values = append(values, v)
+			}
+		}
+	}
+	e.redactor = secrets.NewRedactor(values...)
+	e.hub.MaskValues(int64(e.task.ID), values...)
+}
+
+// scanSecrets refuses to push commits that add secrets. It returns false
+// after failing the task.
+func (e *Executor) scanSecrets(ctx context.Context, workDir, baseSHA, commitSHA string, costUSD float64, diffStat *model.DiffStat) (bool, error) {
+	diff, err := gitops.GetDiffContent(ctx, workDir, baseSHA, commitSHA, "")
+	if err != nil {
+		e.broadcastLog("error", "push", "读取变更内容失败", map[string]interface{}{"error": err.Error()})
+		return false, e.fail("敏感信息检查失败: " + err.Error())
+	}
+	findings := secrets.ScanDiff(diff)
+	if len(findings) == 0 {
+		return true, nil
+	}
+
+	locations := make([]string, len(findings))
+	for i, f := range findings {
+		locations[i] = f.String()
+	}
+	violation := model.DiffViolation{
+		Rule:    "secret",
+		Message: fmt.Sprintf("新增内容包含 %d 处疑似密钥或凭据", len(findings)),
+		Files:   locations,
+	}
+	e.broadcastLog("error", "push", "变更中检测到敏感信息，已阻止推送", map[string]interface{}{
+		"findings": findings,
+	})
+
+	updates := map[string]interface{}{
+		"claude_cost_usd":   costUSD,
+		"policy_violations": model.DiffViolations{violation},
+	}
+	if diffStat != nil {
+		updates["diff_stat"] = model.JSONDiffStat{Data: diffStat}
+	}
+	e.db.Model(e.task).Updates(updates)
+	return false, e.fail("变更包含敏感信息，未推送: " + violationSummary([]model.DiffViolation{violation}))
+}
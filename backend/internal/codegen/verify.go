@@ -0,0 +1,211 @@
+package codegen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/codeMaster/backend/internal/agent"
+	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/internal/sandbox"
+	"github.com/codeMaster/backend/internal/sse"
+)
+
+const (
+	defaultVerifyTimeout = 10 * time.Minute
+	verifyOutputLimit    = 8000
+)
+
+// verify runs the repository's verify commands and, while they fail, resumes the
+// Claude session with the failure output for a bounded number of fix iterations.
+// It returns a nil result when the repository has no verify commands. The result
+// is persisted on the task; an error means the task cannot continue (the fix
+// session failed to start or exited abnormally).
+func (e *Executor) verify(ctx context.Context, workDir string, req agent.Request, sessionID string, st *streamState) (*model.VerifyResult, error) {
+	cfg := e.repo.VerifyConfig.Data
+	if cfg == nil || len(cfg.Commands) == 0 {
+		return nil, nil
+	}
+	maxFix := e.verifyMaxFix
+	if cfg.MaxFixAttempts != nil {
+		maxFix = *cfg.MaxFixAttempts
+	}
+
+	e.broadcastStatus("running", "正在验证生成结果...")
+	result := &model.VerifyResult{}
+	defer e.saveVerifyResult(result)
+
+	for attempt := 0; ; attempt++ {
+		failed := e.runVerifyCommands(ctx, workDir, cfg.Commands, attempt, result)
+		if failed == nil {
+			result.Passed = true
+			return result, nil
+		}
+		if e.cancelled.Load() || attempt >= maxFix {
+			return result, nil
+		}
+		if sessionID == "" {
+			e.broadcastLog("warn", "verify", "未获取到会话 ID，无法自动修复", nil)
+			return result, nil
+		}
+
+		result.FixAttempts++
+		e.broadcastLog("info", "verify", fmt.Sprintf("验证失败，恢复会话进行第 %d/%d 次自动修复", result.FixAttempts, maxFix), map[string]interface{}{
+			"command":    failed.Name,
+			"session_id": sessionID,
+		})
+		fixReq := req
+		fixReq.Prompt = BuildVerifyFixPrompt(failed)
+		sess, err := e.backend.Resume(ctx, sessionID, fixReq)
+		if errors.Is(err, agent.ErrResumeUnsupported) {
+			result.FixAttempts--
+			e.broadcastLog("warn", "verify", fmt.Sprintf("Agent 后端 %s 不支持会话恢复，跳过自动修复", e.backend.Name()), nil)
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("启动自动修复失败: %w", err)
+		}
+		e.session.Store(&sess)
+		e.pid.Store(int32(sess.PID()))
+
+		res, err := e.streamSession(sess, st)
+		if e.cancelled.Load() || st.overBudget {
+			return result, nil
+		}
+		if err != nil {
+			return result, errors.New(e.agentFailReason(res, err))
+		}
+		if res.SessionID != "" {
+			sessionID = res.SessionID
+		}
+	}
+}
+
+// runVerifyCommands runs the commands in order, stopping at the first failure,
+// which it returns. Every run is appended to result and streamed as a verify event.
+func (e *Executor) runVerifyCommands(ctx context.Context, workDir string, cmds []model.VerifyCommand, attempt int, result *model.VerifyResult) *model.VerifyRun {
+	for _, c := range cmds {
+		e.broadcastVerify(map[string]interface{}{
+			"status":  "running",
+			"attempt": attempt,
+			"name":    c.Name,
+			"command": c.Command,
+		})
+
+		run := runVerifyCommand(ctx, workDir, c, e.verifyTimeout, e.sandbox)
+		run.Attempt = attempt
+		run.Output = e.redactor.String(run.Output)
+		result.Runs = append(result.Runs, run)
+
+		data := map[string]interface{}{
+			"status":      "passed",
+			"attempt":     attempt,
+			"name":        run.Name,
+			"command":     run.Command,
+			"exit_code":   run.ExitCode,
+			"duration_ms": run.DurationMs,
+		}
+		if !run.Passed {
+			data["status"] = "failed"
+			data["output"] = run.Output
+			e.broadcastVerify(data)
+			return &result.Runs[len(result.Runs)-1]
+		}
+		e.broadcastVerify(data)
+	}
+	return nil
+}
+
+func (e *Executor) saveVerifyResult(result *model.VerifyResult) {
+	status := "failed"
+	if result.Passed {
+		status = "passed"
+	}
+	e.db.Model(e.task).Updates(map[string]interface{}{
+		"verify_status": status,
+		"verify_result": model.JSONVerifyResult{Data: result},
+	})
+	e.broadcastVerify(map[string]interface{}{
+		"status":       status,
+		"fix_attempts": result.FixAttempts,
+		"done":         true,
+	})
+	if result.Passed {
+		e.broadcastLog("info", "verify", "验证通过", map[string]interface{}{"fix_attempts": result.FixAttempts})
+	} else {
+		e.broadcastLog("error", "verify", "验证未通过", map[string]interface{}{"fix_attempts": result.FixAttempts})
+	}
+}
+
+func (e *Executor) broadcastVerify(data map[string]interface{}) {
+	id := e.eventID.Add(1)
+	e.hub.Broadcast(int64(e.task.ID), sse.Event{
+		ID:   id,
+		Type: "verify",
+		Data: data,
+	})
+}
+
+// runVerifyCommand runs one verify command through sh in the workspace (inside
+// the task's sandbox, if any) and keeps the tail of its output on failure.
+func runVerifyCommand(ctx context.Context, workDir string, c model.VerifyCommand, timeout time.Duration, sb *sandbox.Policy) model.VerifyRun {
+	if c.TimeoutSec > 0 {
+		timeout = time.Duration(c.TimeoutSec) * time.Second
+	}
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	run := model.VerifyRun{Name: c.Name, Command: c.Command, ExitCode: -1}
+	cmd, cleanup, err := sandbox.Command(cmdCtx, sb, sandbox.Spec{
+		Kind: sandbox.KindVerify,
+		Name: "sh",
+		Args: []string{"-c", c.Command},
+		Dir:  workDir,
+		Env:  sandbox.Env(sb, os.Environ()),
+	})
+	if err != nil {
+		run.Output = err.Error()
+		return run
+	}
+	defer cleanup()
+	// Don't hang on pipes held open by background children after a timeout kill
+	cmd.WaitDelay = 5 * time.Second
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	start := time.Now()
+	err = cmd.Run()
+	run = model.VerifyRun{
+		Name:       c.Name,
+		Command:    c.Command,
+		Passed:     err == nil,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		run.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			run.ExitCode = exitErr.ExitCode()
+		}
+		if cmdCtx.Err() == context.DeadlineExceeded {
+			fmt.Fprintf(&out, "\n命令执行超时 (%s)", timeout)
+		}
+		run.Output = tailStr(out.String(), verifyOutputLimit)
+	}
+	return run
+}
+
+func tailStr(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	return "(truncated)..." + s[len(s)-maxLen:]
+}
@@ -0,0 +1,295 @@
+package codegen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codeMaster/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// ErrWorkspaceBusy is returned when another task is already running on the
+// same requirement branch.
+var ErrWorkspaceBusy = errors.New("workspace is in use by another task")
+
+// TaskWorkDir returns the workspace of a codegen task. Every task gets its
+// own tree, so concurrent or consecutive runs never clobber each other.
+func TaskWorkDir(root string, requirementID, taskID uint) string {
+	return filepath.Join(root, "codegen", fmt.Sprintf("req-%d", requirementID), fmt.Sprintf("task-%d", taskID))
+}
+
+// TaskHomeDir returns the HOME the agent of a codegen task runs with, where
+// its sessions are recorded, or "" when sessions are not persisted. Tasks do
+// not share one, so a sandboxed agent can't read or tamper with the sessions
+// of others.
+func TaskHomeDir(sessionDir string, taskID uint) string {
+	if sessionDir == "" {
+		return ""
+	}
+	return filepath.Join(sessionDir, "tasks", fmt.Sprintf("task-%d", taskID))
+}
+
+// LegacyWorkDir is the shared per-requirement workspace used before tasks got
+// their own. Sessions of tasks without a recorded work dir were started there.
+func LegacyWorkDir(root string, requirementID uint) string {
+	return filepath.Join(root, "codegen", fmt.Sprintf("req-%d", requirementID))
+}
+
+// BranchLocks serializes runs on the same requirement branch within this
+// process. The pool already avoids scheduling them concurrently; this guards
+// against anything that slips past it.
+type BranchLocks struct {
+	mu   sync.Mutex
+	held map[string]uint // requirement/branch → task ID
+}
+
+func NewBranchLocks() *BranchLocks {
+	return &BranchLocks{held: make(map[string]uint)}
+}
+
+// TryLock claims the branch of a requirement for taskID. The returned func
+// releases it.
+func (l *BranchLocks) TryLock(requirementID uint, branch string, taskID uint) (func(), error) {
+	key := fmt.Sprintf("%d/%s", requirementID, branch)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if holder, ok := l.held[key]; ok && holder != taskID {
+		return nil, fmt.Errorf("%w (task %d)", ErrWorkspaceBusy, holder)
+	}
+	l.held[key] = taskID
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.held[key] == taskID {
+			delete(l.held, key)
+		}
+	}, nil
+}
+
+// WorkspaceGCConfig bounds the disk used by finished codegen workspaces.
+type WorkspaceGCConfig struct {
+	Retention  time.Duration // remove workspaces finished longer ago; 0 = keep
+	QuotaBytes int64         // remove oldest workspaces beyond this total; 0 = unlimited
+	Interval   time.Duration
+}
+
+// WorkspaceGC periodically removes the workspaces of finished tasks. Only the
+// trees are removed: tasks keep their recorded work dir so their sessions can
+// still be resumed from a new workspace.
+type WorkspaceGC struct {
+	db   *gorm.DB
+	root string
+	cfg  WorkspaceGCConfig
+	quit chan struct{}
+}
+
+func NewWorkspaceGC(db *gorm.DB, root string, cfg WorkspaceGCConfig) *WorkspaceGC {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	return &WorkspaceGC{db: db, root: root, cfg: cfg, quit: make(chan struct{})}
+}
+
+// Enabled reports whether any retention or quota limit is configured.
+func (g *WorkspaceGC) Enabled() bool {
+	return g.cfg.Retention > 0 || g.cfg.QuotaBytes > 0
+}
+
+// Start runs a collection immediately and then at every interval.
+func (g *WorkspaceGC) Start() {
+	if !g.Enabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(g.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			g.Collect()
+			select {
+			case <-ticker.C:
+			case <-g.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (g *WorkspaceGC) Stop() {
+	close(g.quit)
+}
+
+type workspaceEntry struct {
+	dir           string
+	requirementID uint
+	taskID        uint // 0 for a legacy per-requirement workspace
+	finishedAt    time.Time
+	size          int64
+}
+
+// Collect removes expired workspaces, then the oldest ones until the total
+// size fits the quota. Workspaces of queued or running tasks, and of
+// quarantined ones that may still be released, are never touched.
+func (g *WorkspaceGC) Collect() (removed int, freed int64) {
+	entries := g.scan()
+	if len(entries) == 0 {
+		return 0, 0
+	}
+
+	var active []model.CodegenTask
+	g.db.Select("id", "requirement_id").Where("status IN ?", []string{"pending", "cloning", "running", "awaiting_approval", "quarantined"}).Find(&active)
+	activeTasks := make(map[uint]bool, len(active))
+	activeReqs := make(map[uint]bool, len(active))
+	for _, t := range active {
+		activeTasks[t.ID] = true
+		activeReqs[t.RequirementID] = true
+	}
+
+	var taskIDs []uint
+	for _, e := range entries {
+		if e.taskID > 0 {
+			taskIDs = append(taskIDs, e.taskID)
+		}
+	}
+	completed := make(map[uint]time.Time)
+	if len(taskIDs) > 0 {
+		var tasks []model.CodegenTask
+		g.db.Select("id", "completed_at").Where("id IN ?", taskIDs).Find(&tasks)
+		for _, t := range tasks {
+			if t.CompletedAt != nil {
+				completed[t.ID] = *t.CompletedAt
+			}
+		}
+	}
+
+	var candidates []workspaceEntry
+	var total int64
+	for _, e := range entries {
+		total += e.size
+		if e.taskID > 0 && activeTasks[e.taskID] {
+			continue
+		}
+		// The legacy layout nests task workspaces below it, so it is only
+		// removed when no task of the requirement is active
+		if e.taskID == 0 && activeReqs[e.requirementID] {
+			continue
+		}
+		if t, ok := completed[e.taskID]; ok {
+			e.finishedAt = t
+		}
+		candidates = append(candidates, e)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].finishedAt.Before(candidates[j].finishedAt) })
+
+	now := time.Now()
+	for _, e := range candidates {
+		expired := g.cfg.Retention > 0 && now.Sub(e.finishedAt) > g.cfg.Retention
+		overQuota := g.cfg.QuotaBytes > 0 && total > g.cfg.QuotaBytes
+		if !expired && !overQuota {
+			continue
+		}
+		if err := g.remove(e); err != nil {
+			log.Printf("[workspace-gc] remove %s: %v", e.dir, err)
+			continue
+		}
+		total -= e.size
+		removed++
+		freed += e.size
+	}
+	if removed > 0 {
+		log.Printf("[workspace-gc] removed %d workspaces, freed %d MB, %d MB in use", removed, freed>>20, total>>20)
+	}
+	return removed, freed
+}
+
+// scan lists task workspaces (codegen/req-N/task-M) and legacy
+// per-requirement checkouts (codegen/req-N with its own .git).
+func (g *WorkspaceGC) scan() []workspaceEntry {
+	base := filepath.Join(g.root, "codegen")
+	reqDirs, err := os.ReadDir(base)
+	if err != nil {
+		return nil
+	}
+	var entries []workspaceEntry
+	for _, rd := range reqDirs {
+		reqID, ok := parseDirID(rd, "req-")
+		if !ok {
+			continue
+		}
+		reqPath := filepath.Join(base, rd.Name())
+		children, err := os.ReadDir(reqPath)
+		if err != nil {
+			continue
+		}
+		for _, c := range children {
+			if taskID, ok := parseDirID(c, "task-"); ok {
+				entries = append(entries, newWorkspaceEntry(filepath.Join(reqPath, c.Name()), reqID, taskID))
+			}
+		}
+		if _, err := os.Stat(filepath.Join(reqPath, ".git")); err == nil {
+			entries = append(entries, newWorkspaceEntry(reqPath, reqID, 0))
+		}
+	}
+	return entries
+}
+
+// remove deletes a workspace. For a legacy checkout only the repository files
+// are removed, leaving any task workspaces nested in it alone.
+func (g *WorkspaceGC) remove(e workspaceEntry) error {
+	if e.taskID > 0 {
+		return os.RemoveAll(e.dir)
+	}
+	children, err := os.ReadDir(e.dir)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		if _, ok := parseDirID(c, "task-"); ok {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(e.dir, c.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newWorkspaceEntry(dir string, requirementID, taskID uint) workspaceEntry {
+	e := workspaceEntry{dir: dir, requirementID: requirementID, taskID: taskID}
+	if info, err := os.Stat(dir); err == nil {
+		e.finishedAt = info.ModTime()
+	}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		// Task workspaces nested in a legacy checkout are counted separately
+		if taskID == 0 && d.IsDir() && path != dir && filepath.Dir(path) == dir && strings.HasPrefix(d.Name(), "task-") {
+			return filepath.SkipDir
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			e.size += info.Size()
+		}
+		return nil
+	})
+	return e
+}
+
+func parseDirID(d fs.DirEntry, prefix string) (uint, bool) {
+	if !d.IsDir() || !strings.HasPrefix(d.Name(), prefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(d.Name(), prefix), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -69,12 +69,68 @@ type JWTConfig struct {
 
 type CodegenConfig struct {
 	MaxWorkers       int                `mapstructure:"max_workers"`
+	MaxPerProject    int                `mapstructure:"max_per_project"` // 单项目最大并发任务数，0=不限制
+	MaxPerUser       int                `mapstructure:"max_per_user"`    // 单用户最大并发任务数，0=不限制
 	MaxTurns         int                `mapstructure:"max_turns"`
 	TimeoutMinutes   int                `mapstructure:"timeout_minutes"`
 	WorkDir          string             `mapstructure:"work_dir"`
 	UseLocalGit      bool               `mapstructure:"use_local_git"`
-	SessionDir       string             `mapstructure:"session_dir"` // Claude HOME 目录，用于持久化 session
+	SessionDir       string             `mapstructure:"session_dir"`   // Claude HOME 目录，用于持久化 session
+	GitCacheDir      string             `mapstructure:"git_cache_dir"` // 仓库裸镜像缓存目录，为空则每次直接浅克隆
 	GitDomainMapping []GitDomainMapping `mapstructure:"git_domain_mapping"`
+	Agent            AgentConfig        `mapstructure:"agent"`
+	Budget           BudgetConfig       `mapstructure:"budget"`
+	Verify           VerifyConfig       `mapstructure:"verify"`
+	Sandbox          SandboxConfig      `mapstructure:"sandbox"`
+	Workspace        WorkspaceConfig    `mapstructure:"workspace"`
+}
+
+// WorkspaceConfig bounds the disk used by per-task codegen workspaces.
+type WorkspaceConfig struct {
+	RetentionHours    int     `mapstructure:"retention_hours"`     // 任务结束超过该时长的工作区被清理，0=不按时间清理
+	QuotaGB           float64 `mapstructure:"quota_gb"`            // 工作区总大小上限，超出时从最旧的开始清理，0=不限制
+	GCIntervalMinutes int     `mapstructure:"gc_interval_minutes"` // 清理周期，0=60 分钟
+}
+
+// SandboxConfig is the default isolation policy for the agent and verify
+// commands (Linux only, requires bubblewrap). Projects may override it.
+type SandboxConfig struct {
+	Enabled        bool     `mapstructure:"enabled"`
+	Bwrap          string   `mapstructure:"bwrap"`           // bubblewrap 可执行文件，默认 bwrap
+	Network        string   `mapstructure:"network"`         // allow / deny / verify (仅验证命令断网)
+	CPUs           float64  `mapstructure:"cpus"`            // CPU 核数上限，0=不限制
+	MemoryMB       int      `mapstructure:"memory_mb"`       // 内存上限，0=不限制
+	PidsMax        int      `mapstructure:"pids_max"`        // 进程数上限，0=不限制
+	CgroupRoot     string   `mapstructure:"cgroup_root"`     // 已委派给服务的 cgroup v2 目录，为空则不做资源限制
+	ReadOnlyPaths  []string `mapstructure:"read_only_paths"` // 只读挂载的系统目录，为空使用默认值
+	WritablePaths  []string `mapstructure:"writable_paths"`  // 额外可写目录 (如构建缓存)
+	EnvPassthrough []string `mapstructure:"env_passthrough"` // 额外透传的环境变量名
+}
+
+// VerifyConfig holds defaults for the post-generation verify phase. The
+// commands themselves are configured per repository.
+type VerifyConfig struct {
+	MaxFixAttempts        int `mapstructure:"max_fix_attempts"`        // 验证失败后恢复会话自动修复的最大次数
+	CommandTimeoutSeconds int `mapstructure:"command_timeout_seconds"` // 单条验证命令默认超时，0=600 秒
+}
+
+// BudgetConfig holds the default spend limits (USD) for codegen tasks.
+// Projects may override the task limit and set their own monthly budget.
+type BudgetConfig struct {
+	TaskUSD        float64 `mapstructure:"task_usd"`         // 单任务默认上限，0=不限制
+	UserMonthlyUSD float64 `mapstructure:"user_monthly_usd"` // 单用户默认月度上限，0=不限制
+	WarnRatio      float64 `mapstructure:"warn_ratio"`       // 花费达到上限的该比例时发送预警，0=不预警
+}
+
+// AgentConfig selects the coding agent used for codegen, analysis and review.
+type AgentConfig struct {
+	Backend     string   `mapstructure:"backend"`      // claude (默认) / command
+	Name        string   `mapstructure:"name"`         // 显示名称，用于日志
+	Command     string   `mapstructure:"command"`      // claude: 可执行文件路径 (默认 claude); command: 必填
+	Args        []string `mapstructure:"args"`         // command: 启动参数，支持 {prompt} {model} {max_turns} {allowed_tools} {work_dir}
+	ResumeArgs  []string `mapstructure:"resume_args"`  // command: 恢复会话参数，额外支持 {session_id}；为空表示不支持恢复
+	PromptStdin bool     `mapstructure:"prompt_stdin"` // command: 通过 stdin 传入 prompt
+	Env         []string `mapstructure:"env"`          // command: 额外环境变量 (KEY=VALUE)
 }
 
 type GitDomainMapping struct {
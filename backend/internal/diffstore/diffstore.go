@@ -0,0 +1,162 @@
+// Package diffstore keeps the file patches of codegen diffs out of the task
+// rows. Patches are stored once per content (SHA-256) in the diff_blobs table
+// and fetched per file, optionally a page of hunks at a time.
+package diffstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/codeMaster/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNotFound is returned for a hash without a stored blob.
+var ErrNotFound = errors.New("diff blob not found")
+
+// Put stores content and returns its hash. Storing existing content is a no-op.
+func Put(db *gorm.DB, content string) (string, error) {
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+	blob := model.DiffBlob{Hash: hash, Content: content, Size: len(content)}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&blob).Error; err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Get returns the content stored under hash.
+func Get(db *gorm.DB, hash string) (string, error) {
+	var blob model.DiffBlob
+	if err := db.Where("hash = ?", hash).First(&blob).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return blob.Content, nil
+}
+
+// Offload moves the inline patches of files into blobs, leaving the hash and
+// hunk count in place. Files whose patch cannot be stored keep it inline.
+func Offload(db *gorm.DB, files []model.DiffFile) {
+	for i := range files {
+		f := &files[i]
+		if f.Diff == "" {
+			continue
+		}
+		hash, err := Put(db, f.Diff)
+		if err != nil {
+			continue
+		}
+		_, hunks := SplitHunks(f.Diff)
+		f.BlobHash, f.Hunks, f.Diff = hash, len(hunks), ""
+	}
+}
+
+// Patch returns the patch of f, from its blob or, for tasks stored before
+// blobs, inline.
+func Patch(db *gorm.DB, f model.DiffFile) (string, error) {
+	if f.BlobHash == "" {
+		return f.Diff, nil
+	}
+	return Get(db, f.BlobHash)
+}
+
+// SplitHunks splits a single-file patch into its header (diff --git, index,
+// ---/+++ lines) and hunks, each starting with its "@@" line.
+func SplitHunks(patch string) (string, []string) {
+	lines := strings.SplitAfter(patch, "\n")
+	var header strings.Builder
+	var hunks []string
+	var cur strings.Builder
+	for _, l := range lines {
+		if strings.HasPrefix(l, "@@") {
+			if cur.Len() > 0 {
+				hunks = append(hunks, cur.String())
+				cur.Reset()
+			}
+			cur.WriteString(l)
+			continue
+		}
+		if cur.Len() > 0 {
+			cur.WriteString(l)
+		} else {
+			header.WriteString(l)
+		}
+	}
+	if cur.Len() > 0 {
+		hunks = append(hunks, cur.String())
+	}
+	return header.String(), hunks
+}
+
+// TreeNode is a directory or file of a diff's file tree. Directory stats are
+// the sums over the files below them.
+type TreeNode struct {
+	Name      string      `json:"name"`
+	Path      string      `json:"path"`
+	Type      string      `json:"type"` // dir / file
+	Status    string      `json:"status,omitempty"`
+	Files     int         `json:"files,omitempty"` // dirs: changed files below
+	Additions int         `json:"additions"`
+	Deletions int         `json:"deletions"`
+	Children  []*TreeNode `json:"children,omitempty"`
+}
+
+// BuildTree arranges the changed files by directory, directories first, each
+// level sorted by name.
+func BuildTree(files []model.DiffFile) []*TreeNode {
+	root := &TreeNode{Type: "dir"}
+	for _, f := range files {
+		parts := strings.Split(f.Path, "/")
+		node := root
+		for i, name := range parts {
+			node.Files++
+			node.Additions += f.Additions
+			node.Deletions += f.Deletions
+			if i == len(parts)-1 {
+				node.Children = append(node.Children, &TreeNode{
+					Name:      name,
+					Path:      f.Path,
+					Type:      "file",
+					Status:    f.Status,
+					Additions: f.Additions,
+					Deletions: f.Deletions,
+				})
+				break
+			}
+			node = node.child(name, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	root.sort()
+	return root.Children
+}
+
+func (n *TreeNode) child(name, path string) *TreeNode {
+	for _, c := range n.Children {
+		if c.Type == "dir" && c.Name == name {
+			return c
+		}
+	}
+	c := &TreeNode{Name: name, Path: path, Type: "dir"}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *TreeNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if a.Type != b.Type {
+			return a.Type == "dir"
+		}
+		return a.Name < b.Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
@@ -27,10 +27,12 @@ func Push(ctx context.Context, repoDir, branch, gitURL, token string, useLocalGi
 
 	if useLocalGit {
 		// Use local git credentials — push via origin remote
-		unshallowCmd := exec.CommandContext(ctx, "git", "fetch", "--unshallow", "origin")
-		unshallowCmd.Dir = repoDir
-		unshallowCmd.Env = env
-		unshallowCmd.CombinedOutput() // ignore errors — may not be shallow
+		if isShallow(ctx, repoDir) {
+			unshallowCmd := exec.CommandContext(ctx, "git", "fetch", "--unshallow", "origin")
+			unshallowCmd.Dir = repoDir
+			unshallowCmd.Env = env
+			unshallowCmd.CombinedOutput() // best effort
+		}
 
 		pushCmd := "git push -u origin " + branch
 		log.Printf("[gitops.Push] exec: %s", pushCmd)
@@ -53,14 +55,17 @@ func Push(ctx context.Context, repoDir, branch, gitURL, token string, useLocalGi
 	if err != nil {
 		return fmt.Errorf("inject token: %w", err)
 	}
-	log.Printf("[gitops.Push] authURL=%s (original gitURL=%s)", authURL, gitURL)
-
-	// Unshallow if needed — shallow clones may fail to push
-	unshallowCmd := exec.CommandContext(ctx, "git", "-c", "credential.helper=",
-		"fetch", "--unshallow", authURL)
-	unshallowCmd.Dir = repoDir
-	unshallowCmd.Env = env
-	unshallowCmd.CombinedOutput() // ignore errors — may not be shallow
+	log.Printf("[gitops.Push] gitURL=%s", gitURL)
+
+	// Unshallow if needed — shallow clones may fail to push. Workspaces from
+	// the mirror cache already have full history.
+	if isShallow(ctx, repoDir) {
+		unshallowCmd := exec.CommandContext(ctx, "git", "-c", "credential.helper=",
+			"fetch", "--unshallow", authURL)
+		unshallowCmd.Dir = repoDir
+		unshallowCmd.Env = env
+		unshallowCmd.CombinedOutput() // best effort
+	}
 
 	// Push directly with auth URL, bypassing credential helpers
 	refspec := "HEAD:refs/heads/" + branch
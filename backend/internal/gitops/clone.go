@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -25,12 +26,32 @@ func Clone(ctx context.Context, gitURL, token, branch, destDir string) error {
 		return fmt.Errorf("inject token: %w", err)
 	}
 
-	args := []string{"-c", "credential.helper=", "clone", "--depth", "1", "--branch", branch, authURL, destDir}
-	cmd := exec.CommandContext(ctx, "git", args...)
-	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("git clone: %s: %w", strings.TrimSpace(string(output)), err)
+	// Prefer a workspace backed by the local mirror; fall back to a shallow
+	// clone from the remote if the mirror cannot be used.
+	mirrored := false
+	if mirrorDir != "" {
+		if err := cloneFromMirror(ctx, gitURL, token, branch, destDir); err != nil {
+			log.Printf("[gitops.Clone] mirror cache unavailable, falling back to direct clone: %v", err)
+		} else {
+			mirrored = true
+		}
+	}
+	if !mirrored {
+		args := []string{"-c", "credential.helper=", "clone", "--depth", "1", "--branch", branch, authURL, destDir}
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git clone: %s: %w", sanitize(string(output), token), err)
+		}
+	}
+
+	// Don't leave the token in .git/config where the agent can read it;
+	// fetch and push pass the auth URL explicitly.
+	setURL := exec.CommandContext(ctx, "git", "remote", "set-url", "origin", gitURL)
+	setURL.Dir = destDir
+	if output, err := setURL.CombinedOutput(); err != nil {
+		return fmt.Errorf("git remote set-url: %s: %w", strings.TrimSpace(string(output)), err)
 	}
 	return nil
 }
@@ -190,12 +211,18 @@ func FetchAndCheckout(ctx context.Context, repoDir, gitURL, token, branch string
 
 	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
 
-	fetchCmd := exec.CommandContext(ctx, "git", "-c", "credential.helper=",
-		"fetch", "--depth", "1", authURL, branch)
+	// Keep full clones (from the mirror cache) full; deepening is only
+	// needed for shallow ones
+	fetchArgs := []string{"-c", "credential.helper=", "fetch"}
+	if isShallow(ctx, repoDir) {
+		fetchArgs = append(fetchArgs, "--depth", "1")
+	}
+	fetchArgs = append(fetchArgs, authURL, branch)
+	fetchCmd := exec.CommandContext(ctx, "git", fetchArgs...)
 	fetchCmd.Dir = repoDir
 	fetchCmd.Env = env
 	if output, err := fetchCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git fetch %s: %s: %w", branch, strings.TrimSpace(string(output)), err)
+		return fmt.Errorf("git fetch %s: %s: %w", branch, sanitize(string(output), token), err)
 	}
 
 	checkoutCmd := exec.CommandContext(ctx, "git", "checkout", "-b", branch, "FETCH_HEAD")
@@ -208,6 +235,33 @@ func FetchAndCheckout(ctx context.Context, repoDir, gitURL, token, branch string
 	return nil
 }
 
+// FetchCommit makes sure commit sha is present in repoDir, fetching it from
+// the remote if needed. Used to diff commits that no branch points to anymore.
+func FetchCommit(ctx context.Context, repoDir, gitURL, token, sha string) error {
+	check := exec.CommandContext(ctx, "git", "cat-file", "-e", sha+"^{commit}")
+	check.Dir = repoDir
+	if check.Run() == nil {
+		return nil
+	}
+
+	authURL, err := injectToken(rewriteGitURL(gitURL), token)
+	if err != nil {
+		return fmt.Errorf("inject token: %w", err)
+	}
+	args := []string{"-c", "credential.helper=", "fetch"}
+	if isShallow(ctx, repoDir) {
+		args = append(args, "--depth", "1")
+	}
+	args = append(args, authURL, sha)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = repoDir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch %s: %s: %w", sha, sanitize(string(output), token), err)
+	}
+	return nil
+}
+
 func injectToken(gitURL, token string) (string, error) {
 	// Ensure .git suffix to avoid redirects that drop credentials
 	if !strings.HasSuffix(gitURL, ".git") {
@@ -0,0 +1,72 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/codeMaster/backend/internal/model"
+)
+
+// StageAll stages every change in the worktree and returns the changed paths.
+// Renames are listed as their old and new path so each can be committed.
+func StageAll(ctx context.Context, repoDir string) ([]string, error) {
+	if _, err := gitOutput(ctx, repoDir, "add", "-A"); err != nil {
+		return nil, err
+	}
+	out, err := gitOutput(ctx, repoDir, "diff", "--cached", "--name-only", "--no-renames", "-z")
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(out, "\x00"), "\x00"), nil
+}
+
+// StagedDiff returns the patch of the staged changes.
+func StagedDiff(ctx context.Context, repoDir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", "--cached", "-M")
+	cmd.Dir = repoDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git diff --cached: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return string(out), nil
+}
+
+// CommitPaths commits the changes of paths, leaving other staged changes for
+// later commits, and returns the commit SHA.
+func CommitPaths(ctx context.Context, repoDir string, paths []string, message string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "--literal-pathspecs", "commit", "-m", message, "--pathspec-from-file=-", "--pathspec-file-nul")
+	cmd.Dir = repoDir
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("git commit: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return RevParse(ctx, repoDir, "HEAD")
+}
+
+// CommitsBetween lists the commits reachable from head but not from base,
+// oldest first, with the files each one changed.
+func CommitsBetween(ctx context.Context, repoDir, base, head string) (model.TaskCommits, error) {
+	out, err := gitOutput(ctx, repoDir, "rev-list", "--reverse", base+".."+head)
+	if err != nil {
+		return nil, err
+	}
+	commits := model.TaskCommits{}
+	for _, sha := range strings.Fields(out) {
+		message, err := gitOutput(ctx, repoDir, "log", "-1", "--format=%B", sha)
+		if err != nil {
+			return nil, err
+		}
+		c := model.TaskCommit{SHA: sha, Message: message}
+		files, err := gitOutput(ctx, repoDir, "diff-tree", "--no-commit-id", "--name-only", "-r", "-z", sha)
+		if err != nil {
+			return nil, err
+		}
+		if files != "" {
+			c.Files = strings.Split(strings.TrimSuffix(files, "\x00"), "\x00")
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
@@ -10,67 +10,138 @@ import (
 	"github.com/codeMaster/backend/internal/model"
 )
 
+// GetDiffStat summarizes the changes of featureBranch against baseBranch. The
+// totals are derived from the per-file data, which is returned in Files.
 func GetDiffStat(ctx context.Context, repoDir, baseBranch, featureBranch string) (*model.DiffStat, error) {
-	cmd := exec.CommandContext(ctx, "git", "diff", "--stat", baseBranch+".."+featureBranch)
-	cmd.Dir = repoDir
-	output, err := cmd.CombinedOutput()
+	files, err := GetDiffFiles(ctx, repoDir, baseBranch, featureBranch)
 	if err != nil {
-		return nil, fmt.Errorf("git diff --stat: %s: %w", string(output), err)
+		return nil, err
 	}
-
-	stat := &model.DiffStat{}
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "file") && strings.Contains(line, "changed") {
-			parts := strings.Fields(line)
-			if len(parts) > 0 {
-				stat.FilesChanged, _ = strconv.Atoi(parts[0])
-			}
-			for i, p := range parts {
-				if strings.HasPrefix(p, "insertion") && i > 0 {
-					stat.Additions, _ = strconv.Atoi(parts[i-1])
-				}
-				if strings.HasPrefix(p, "deletion") && i > 0 {
-					stat.Deletions, _ = strconv.Atoi(parts[i-1])
-				}
-			}
-		}
+	stat := &model.DiffStat{FilesChanged: len(files), Files: files}
+	for _, f := range files {
+		stat.Additions += f.Additions
+		stat.Deletions += f.Deletions
 	}
 	return stat, nil
 }
 
+// GetDiffFiles lists the changed files with rename and copy detection. Status,
+// paths and modes come from --raw (--name-status plus file modes), line counts
+// from --numstat; both use -z so unusual paths survive.
 func GetDiffFiles(ctx context.Context, repoDir, baseBranch, featureBranch string) ([]model.DiffFile, error) {
-	cmd := exec.CommandContext(ctx, "git", "diff", "--numstat", baseBranch+".."+featureBranch)
+	rng := baseBranch + ".." + featureBranch
+	raw, err := diffZ(ctx, repoDir, "--raw", rng)
+	if err != nil {
+		return nil, err
+	}
+	numstat, err := diffZ(ctx, repoDir, "--numstat", rng)
+	if err != nil {
+		return nil, err
+	}
+
+	files := parseRaw(raw)
+	counts := parseNumstat(numstat)
+	for i := range files {
+		c, ok := counts[files[i].Path]
+		if !ok {
+			continue
+		}
+		if c.binary {
+			files[i].Binary = true
+		} else {
+			files[i].Additions, files[i].Deletions = c.additions, c.deletions
+		}
+	}
+	return files, nil
+}
+
+func diffZ(ctx context.Context, repoDir, format, rng string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", format, "-z", "-M", "-C", rng)
 	cmd.Dir = repoDir
-	output, err := cmd.CombinedOutput()
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
 	if err != nil {
-		return nil, fmt.Errorf("git diff --numstat: %s: %w", string(output), err)
+		return nil, fmt.Errorf("git diff %s: %s: %w", format, strings.TrimSpace(stderr.String()), err)
 	}
+	return strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00"), nil
+}
+
+var rawStatus = map[byte]string{
+	'A': model.DiffAdded,
+	'M': model.DiffModified,
+	'D': model.DiffDeleted,
+	'R': model.DiffRenamed,
+	'C': model.DiffCopied,
+	'T': model.DiffTypeChanged,
+}
 
+// parseRaw parses "git diff --raw -z": each entry is
+// ":oldmode newmode oldsha newsha STATUS[score]" followed by one path, or by
+// the old and new path for renames and copies.
+func parseRaw(fields []string) []model.DiffFile {
 	var files []model.DiffFile
-	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
-		if line == "" {
+	for i := 0; i < len(fields); i++ {
+		meta := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(meta) < 5 || i+1 >= len(fields) {
 			continue
 		}
-		parts := strings.Fields(line)
+		oldMode, newMode, code := meta[0], meta[1], meta[4]
+		f := model.DiffFile{Status: rawStatus[code[0]]}
+		if f.Status == "" {
+			f.Status = model.DiffModified
+		}
+		i++
+		f.Path = fields[i]
+		if code[0] == 'R' || code[0] == 'C' {
+			if i+1 >= len(fields) {
+				break
+			}
+			i++
+			f.OldPath, f.Path = f.Path, fields[i]
+			f.Similarity, _ = strconv.Atoi(code[1:])
+		}
+		if oldMode != newMode && oldMode != "000000" && newMode != "000000" {
+			f.OldMode, f.NewMode = oldMode, newMode
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+type lineCount struct {
+	additions, deletions int
+	binary               bool
+}
+
+// parseNumstat parses "git diff --numstat -z" into counts keyed by the new
+// path. Renames and copies have an empty path field followed by the old and
+// new path.
+func parseNumstat(fields []string) map[string]lineCount {
+	counts := make(map[string]lineCount)
+	for i := 0; i < len(fields); i++ {
+		parts := strings.SplitN(fields[i], "\t", 3)
 		if len(parts) < 3 {
 			continue
 		}
-		add, _ := strconv.Atoi(parts[0])
-		del, _ := strconv.Atoi(parts[1])
-		status := "modified"
-		if del == 0 && add > 0 {
-			status = "added"
+		path := parts[2]
+		if path == "" {
+			if i+2 >= len(fields) {
+				break
+			}
+			path = fields[i+2]
+			i += 2
+		}
+		var c lineCount
+		if parts[0] == "-" && parts[1] == "-" {
+			c.binary = true
+		} else {
+			c.additions, _ = strconv.Atoi(parts[0])
+			c.deletions, _ = strconv.Atoi(parts[1])
 		}
-		files = append(files, model.DiffFile{
-			Path:      parts[2],
-			Status:    status,
-			Additions: add,
-			Deletions: del,
-		})
+		counts[path] = c
 	}
-	return files, nil
+	return counts
 }
 
 func GetDiffContent(ctx context.Context, repoDir, baseBranch, featureBranch, filePath string) (string, error) {
@@ -86,3 +157,31 @@ func GetDiffContent(ctx context.Context, repoDir, baseBranch, featureBranch, fil
 	}
 	return string(output), nil
 }
+
+// GetFileDiff returns the patch of one changed file. Renamed files are diffed
+// against their old path so only the actual edits show up. The source of a
+// copy is left out: it still exists and any change to it is its own entry.
+func GetFileDiff(ctx context.Context, repoDir, baseBranch, featureBranch string, f model.DiffFile) (string, error) {
+	args := []string{"diff", "-M", "-C", baseBranch + ".." + featureBranch, "--", f.Path}
+	if f.Status == model.DiffRenamed && f.OldPath != "" {
+		args = append(args, f.OldPath)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = repoDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git diff: %s: %w", string(output), err)
+	}
+	return string(output), nil
+}
+
+// RevParse resolves rev to a commit SHA.
+func RevParse(ctx context.Context, repoDir, rev string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", rev+"^{commit}")
+	cmd.Dir = repoDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse %s: %s: %w", rev, strings.TrimSpace(string(out)), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
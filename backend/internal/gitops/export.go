@@ -0,0 +1,66 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FormatPatch returns the commits in base..tip as an mbox of patches, ready
+// for `git am`.
+func FormatPatch(ctx context.Context, repoDir, base, tip string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", "format-patch", "--stdout", base+".."+tip)
+	cmd.Dir = repoDir
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git format-patch: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return out, nil
+}
+
+// CreateBundle writes a git bundle of the commits in base..tip to dest, with
+// tip as refs/heads/<branch>. Receivers need base, e.g. from the source
+// branch. repoDir is only read: the ref lives in a scratch repository that
+// borrows its objects.
+func CreateBundle(ctx context.Context, repoDir, branch, base, tip, dest string) error {
+	gitDir, err := gitOutput(ctx, repoDir, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return err
+	}
+	scratch, err := os.MkdirTemp(filepath.Dir(dest), "bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	if _, err := gitOutput(ctx, scratch, "init", "--bare", "--quiet"); err != nil {
+		return err
+	}
+	alternates := filepath.Join(scratch, "objects", "info", "alternates")
+	if err := os.WriteFile(alternates, []byte(filepath.Join(gitDir, "objects")+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write alternates: %w", err)
+	}
+	ref := "refs/heads/" + branch
+	if _, err := gitOutput(ctx, scratch, "update-ref", ref, tip); err != nil {
+		return err
+	}
+	if _, err := gitOutput(ctx, scratch, "bundle", "create", "--quiet", dest, "^"+base, ref); err != nil {
+		return err
+	}
+	return nil
+}
+
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %w", args[0], strings.TrimSpace(string(out)), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
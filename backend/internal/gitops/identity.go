@@ -0,0 +1,217 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Person is a commit author or co-author.
+type Person struct {
+	Name  string
+	Email string
+}
+
+func (p Person) String() string {
+	return fmt.Sprintf("%s <%s>", p.Name, p.Email)
+}
+
+// SigningKey is an unencrypted private key commits are signed with.
+type SigningKey struct {
+	Format     string // ssh / openpgp
+	PrivateKey string
+}
+
+// Identity is who commits in a workspace. The bot set by ConfigUser is always
+// the committer; commits may be authored by or co-authored with a user, and
+// signed. A nil or zero Identity commits as the bot.
+type Identity struct {
+	Author   *Person // author of the commits instead of the bot
+	CoAuthor *Person // credited with a Co-authored-by trailer, see Message
+	Signing  *SigningKey
+}
+
+// Configure sets up repoDir to commit as id, unsigned; see Sign.
+func (id *Identity) Configure(ctx context.Context, repoDir string) error {
+	if err := ConfigUser(ctx, repoDir); err != nil {
+		return err
+	}
+	if id == nil || id.Author == nil {
+		return nil
+	}
+	if _, err := gitOutput(ctx, repoDir, "config", "author.name", id.Author.Name); err != nil {
+		return err
+	}
+	_, err := gitOutput(ctx, repoDir, "config", "author.email", id.Author.Email)
+	return err
+}
+
+// Sign makes commits in repoDir signed until cleanup is called. The key is
+// written to keyDir, which must be outside the worktree; keep the window
+// short wherever untrusted processes run in the worktree.
+func (id *Identity) Sign(ctx context.Context, repoDir, keyDir string) (cleanup func(), err error) {
+	if id == nil || id.Signing == nil {
+		return func() {}, nil
+	}
+	removeKey, err := configSigning(ctx, repoDir, keyDir, id.Signing)
+	if err != nil {
+		return func() {}, err
+	}
+	return func() {
+		removeKey()
+		// Leave nothing pointing at the removed key
+		for _, key := range []string{"commit.gpgsign", "gpg.format", "gpg.program", "user.signingkey"} {
+			exec.Command("git", "-C", repoDir, "config", "--unset", key).Run()
+		}
+	}, nil
+}
+
+// Message adds the co-author trailer, if any, to a commit message.
+func (id *Identity) Message(message string) string {
+	if id == nil || id.CoAuthor == nil {
+		return message
+	}
+	return strings.TrimRight(message, "\n") + "\n\nCo-authored-by: " + id.CoAuthor.String()
+}
+
+// configSigning makes every commit in repoDir signed with key.
+func configSigning(ctx context.Context, repoDir, keyDir string, key *SigningKey) (func(), error) {
+	if err := os.MkdirAll(keyDir, 0o700); err != nil {
+		return func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(keyDir) }
+
+	var config [][2]string
+	switch key.Format {
+	case "ssh":
+		keyFile := filepath.Join(keyDir, "signing_key")
+		if err := os.WriteFile(keyFile, []byte(normalizeKey(key.PrivateKey)), 0o600); err != nil {
+			cleanup()
+			return func() {}, err
+		}
+		config = [][2]string{{"gpg.format", "ssh"}, {"user.signingkey", keyFile}}
+	case "openpgp":
+		home := filepath.Join(keyDir, "gnupg")
+		keyID, err := importGPGKey(ctx, home, key.PrivateKey)
+		if err != nil {
+			cleanup()
+			return func() {}, err
+		}
+		cleanup = func() {
+			stopAgent := exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent")
+			stopAgent.Run()
+			os.RemoveAll(keyDir)
+		}
+		// git has no setting for the keyring, so point it at a wrapper
+		program := filepath.Join(keyDir, "gpg.sh")
+		script := fmt.Sprintf("#!/bin/sh\nexec gpg --homedir '%s' --batch \"$@\"\n", home)
+		if err := os.WriteFile(program, []byte(script), 0o700); err != nil {
+			cleanup()
+			return func() {}, err
+		}
+		config = [][2]string{{"gpg.format", "openpgp"}, {"gpg.program", program}, {"user.signingkey", keyID}}
+	default:
+		cleanup()
+		return func() {}, fmt.Errorf("unsupported signing format %q", key.Format)
+	}
+
+	config = append(config, [2]string{"commit.gpgsign", "true"})
+	for _, kv := range config {
+		if _, err := gitOutput(ctx, repoDir, "config", kv[0], kv[1]); err != nil {
+			cleanup()
+			return func() {}, err
+		}
+	}
+	return cleanup, nil
+}
+
+// InspectSigningKey checks that key is an unencrypted private key git can
+// sign with, and returns its ID (fingerprint for ssh) and public key.
+func InspectSigningKey(ctx context.Context, key SigningKey) (keyID, publicKey string, err error) {
+	dir, err := os.MkdirTemp("", "signing-key-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(dir)
+
+	switch key.Format {
+	case "ssh":
+		keyFile := filepath.Join(dir, "key")
+		if err := os.WriteFile(keyFile, []byte(normalizeKey(key.PrivateKey)), 0o600); err != nil {
+			return "", "", err
+		}
+		// An empty passphrase makes protected keys fail instead of prompting
+		pub, err := exec.CommandContext(ctx, "ssh-keygen", "-y", "-P", "", "-f", keyFile).Output()
+		if err != nil {
+			return "", "", fmt.Errorf("无法读取 SSH 私钥，私钥不能设置密码")
+		}
+		pubFile := keyFile + ".pub"
+		if err := os.WriteFile(pubFile, pub, 0o600); err != nil {
+			return "", "", err
+		}
+		fp, err := exec.CommandContext(ctx, "ssh-keygen", "-l", "-f", pubFile).Output()
+		if err != nil {
+			return "", "", fmt.Errorf("ssh-keygen -l: %w", err)
+		}
+		// "256 SHA256:... comment (ED25519)"
+		fields := strings.Fields(string(fp))
+		if len(fields) < 2 {
+			return "", "", fmt.Errorf("ssh-keygen -l: unexpected output %q", fp)
+		}
+		return fields[1], strings.TrimSpace(string(pub)), nil
+	case "openpgp":
+		home := filepath.Join(dir, "gnupg")
+		defer exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		keyID, err := importGPGKey(ctx, home, key.PrivateKey)
+		if err != nil {
+			return "", "", err
+		}
+		// A protected key would need a passphrase for every signature
+		probe := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--pinentry-mode", "error",
+			"--local-user", keyID, "--detach-sign", "--output", "-")
+		probe.Stdin = strings.NewReader("probe")
+		if err := probe.Run(); err != nil {
+			return "", "", fmt.Errorf("GPG 私钥无法用于签名，私钥不能设置密码")
+		}
+		pub, err := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--armor", "--export", keyID).Output()
+		if err != nil {
+			return "", "", fmt.Errorf("gpg --export: %w", err)
+		}
+		return keyID, strings.TrimSpace(string(pub)), nil
+	}
+	return "", "", fmt.Errorf("签名格式必须为 ssh / openpgp")
+}
+
+// importGPGKey imports an armored secret key into a new keyring at home and
+// returns the ID of the key that signs.
+func importGPGKey(ctx context.Context, home, armored string) (string, error) {
+	if err := os.MkdirAll(home, 0o700); err != nil {
+		return "", err
+	}
+	imp := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--import")
+	imp.Stdin = strings.NewReader(armored)
+	if err := imp.Run(); err != nil {
+		return "", fmt.Errorf("导入 GPG 私钥失败，请提供 ASCII armor 格式的私钥")
+	}
+	out, err := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--with-colons", "--list-secret-keys").Output()
+	if err != nil {
+		return "", fmt.Errorf("gpg --list-secret-keys: %w", err)
+	}
+	// sec:u:255:22:<key id>:... ; the primary key is listed first
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 4 && fields[0] == "sec" {
+			return fields[4], nil
+		}
+	}
+	return "", fmt.Errorf("GPG 密钥中没有私钥")
+}
+
+// normalizeKey restores the trailing newline ssh-keygen insists on; keys
+// pasted into forms often lose it or gain \r.
+func normalizeKey(key string) string {
+	return strings.TrimSpace(strings.ReplaceAll(key, "\r\n", "\n")) + "\n"
+}
@@ -0,0 +1,192 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// mirrorDir is the root of the bare mirror cache; empty disables the cache
+// and Clone falls back to a shallow clone from the remote.
+var mirrorDir string
+
+// mirrorLocks serializes fetches into the same mirror.
+var mirrorLocks sync.Map // mirror path → *sync.Mutex
+
+// mirrorRefspecs limits the mirror to branches and tags; platform refs such as
+// refs/merge-requests/* or refs/pull/* are not needed for workspaces.
+var mirrorRefspecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// InitMirrorCache enables the shared bare-mirror cache under dir.
+func InitMirrorCache(dir string) {
+	if dir == "" {
+		return
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		log.Printf("[gitops] mirror cache disabled: %v", err)
+		return
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		log.Printf("[gitops] mirror cache disabled: %v", err)
+		return
+	}
+	mirrorDir = abs
+	log.Printf("[gitops] mirror cache initialized: %s", mirrorDir)
+}
+
+// MirrorCacheDir returns the mirror cache root, or "" when the cache is disabled.
+func MirrorCacheDir() string {
+	return mirrorDir
+}
+
+// MirrorRepoDir returns the bare mirror directory of gitURL, or "" when the
+// cache is disabled. Workspaces cloned from the cache reference its objects,
+// so sandboxed processes need read access to it, and only to it.
+func MirrorRepoDir(gitURL string) string {
+	if mirrorDir == "" {
+		return ""
+	}
+	dir, err := mirrorPath(rewriteGitURL(gitURL))
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// mirrorPath maps a (rewritten) git URL to its bare mirror directory,
+// e.g. https://gitlab.example.com/group/app → <cache>/gitlab.example.com/group/app.git
+func mirrorPath(gitURL string) (string, error) {
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return "", err
+	}
+	p := strings.TrimSuffix(path.Clean("/"+u.Path), ".git")
+	if u.Host == "" || p == "/" {
+		return "", fmt.Errorf("cannot derive mirror path from %q", gitURL)
+	}
+	host := strings.ReplaceAll(u.Host, ":", "_")
+	return filepath.Join(mirrorDir, host, filepath.FromSlash(p)+".git"), nil
+}
+
+// SyncMirror creates or incrementally updates the bare mirror of gitURL and
+// returns its path. Concurrent callers for the same repository are serialized.
+func SyncMirror(ctx context.Context, gitURL, token string) (string, error) {
+	if mirrorDir == "" {
+		return "", fmt.Errorf("mirror cache is disabled")
+	}
+	gitURL = rewriteGitURL(gitURL)
+
+	dir, err := mirrorPath(gitURL)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := injectToken(gitURL, token)
+	if err != nil {
+		return "", fmt.Errorf("inject token: %w", err)
+	}
+
+	mu, _ := mirrorLocks.LoadOrStore(dir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	created := false
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); os.IsNotExist(err) {
+		if err := initMirror(ctx, dir, gitURL); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		created = true
+	}
+
+	args := append([]string{"-c", "credential.helper=", "fetch", "--prune", "--no-tags", authURL}, mirrorRefspecs...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if created {
+			os.RemoveAll(dir)
+		}
+		return "", fmt.Errorf("git fetch (mirror): %s: %w", sanitize(string(output), token), err)
+	}
+	log.Printf("[gitops] mirror synced: %s (created=%v)", dir, created)
+	return dir, nil
+}
+
+// MirrorWithCommits syncs the mirror of gitURL and makes sure it also holds
+// the given commits, which may no longer be on any branch.
+func MirrorWithCommits(ctx context.Context, gitURL, token string, shas ...string) (string, error) {
+	dir, err := SyncMirror(ctx, gitURL, token)
+	if err != nil {
+		return "", err
+	}
+	mu, _ := mirrorLocks.LoadOrStore(dir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+	for _, sha := range shas {
+		if err := FetchCommit(ctx, dir, gitURL, token, sha); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func initMirror(ctx context.Context, dir, gitURL string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	steps := [][]string{
+		{"init", "--bare", "--quiet"},
+		{"remote", "add", "origin", gitURL},
+		// Workspaces borrow objects from the mirror through alternates, so the
+		// mirror must never drop objects a workspace might still reference.
+		{"config", "gc.auto", "0"},
+		{"config", "gc.pruneExpire", "never"},
+	}
+	for _, args := range steps {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s (mirror): %s: %w", args[0], strings.TrimSpace(string(output)), err)
+		}
+	}
+	return nil
+}
+
+// cloneFromMirror syncs the mirror and creates a workspace that shares its
+// object store, so only new objects are ever downloaded from the remote.
+func cloneFromMirror(ctx context.Context, gitURL, token, branch, destDir string) error {
+	dir, err := SyncMirror(ctx, gitURL, token)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "git", "clone", "--shared", "--quiet", "--branch", branch, dir, destDir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(destDir)
+		return fmt.Errorf("git clone (mirror): %s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// isShallow reports whether repoDir is a shallow clone.
+func isShallow(ctx context.Context, repoDir string) bool {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--is-shallow-repository")
+	cmd.Dir = repoDir
+	out, err := cmd.Output()
+	return err != nil || strings.TrimSpace(string(out)) != "false"
+}
+
+func sanitize(output, token string) string {
+	output = strings.TrimSpace(output)
+	if token != "" {
+		output = strings.ReplaceAll(output, token, "***")
+	}
+	return output
+}
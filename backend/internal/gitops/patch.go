@@ -0,0 +1,50 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// IsMailbox reports whether patch is `git format-patch` output (an mbox of
+// commits) rather than a plain unified diff.
+func IsMailbox(patch []byte) bool {
+	return bytes.HasPrefix(patch, []byte("From ")) && bytes.Contains(patch, []byte("\nSubject: "))
+}
+
+// IsUnifiedDiff reports whether patch contains at least one file diff.
+func IsUnifiedDiff(patch []byte) bool {
+	return bytes.Contains(patch, []byte("diff --git ")) ||
+		(bytes.Contains(patch, []byte("\n--- ")) || bytes.HasPrefix(patch, []byte("--- "))) && bytes.Contains(patch, []byte("\n+++ "))
+}
+
+// AmPatches commits a format-patch series onto the current branch, keeping
+// the authors and messages of the patches. Falls back to a three-way merge
+// for patches made against an older base. Nothing is left behind on failure.
+func AmPatches(ctx context.Context, repoDir, patchFile string) error {
+	cmd := exec.CommandContext(ctx, "git", "am", "--3way", "--keep-cr", patchFile)
+	cmd.Dir = repoDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		abort := exec.CommandContext(ctx, "git", "am", "--abort")
+		abort.Dir = repoDir
+		abort.Run()
+		return fmt.Errorf("git am: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// ApplyDiff applies a unified diff to the working tree and index; committing
+// is up to the caller.
+func ApplyDiff(ctx context.Context, repoDir, patchFile string) error {
+	cmd := exec.CommandContext(ctx, "git", "apply", "--3way", "--index", "--whitespace=nowarn", patchFile)
+	cmd.Dir = repoDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		reset := exec.CommandContext(ctx, "git", "reset", "--hard", "--quiet")
+		reset.Dir = repoDir
+		reset.Run()
+		return fmt.Errorf("git apply: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
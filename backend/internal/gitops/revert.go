@@ -0,0 +1,97 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Unshallow fetches the full history of a shallow clone; rewriting history
+// needs the commits between the iterations.
+func Unshallow(ctx context.Context, repoDir, gitURL, token string) error {
+	if !isShallow(ctx, repoDir) {
+		return nil
+	}
+	authURL, err := injectToken(rewriteGitURL(gitURL), token)
+	if err != nil {
+		return fmt.Errorf("inject token: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "git", "-c", "credential.helper=", "fetch", "--unshallow", authURL)
+	cmd.Dir = repoDir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch --unshallow: %s: %w", sanitize(string(output), token), err)
+	}
+	return nil
+}
+
+// MergeBase returns the best common ancestor of a and b.
+func MergeBase(ctx context.Context, repoDir, a, b string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "merge-base", a, b)
+	cmd.Dir = repoDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git merge-base: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ResetHard moves the current branch to rev, discarding everything after it.
+func ResetHard(ctx context.Context, repoDir, rev string) error {
+	cmd := exec.CommandContext(ctx, "git", "reset", "--hard", "--quiet", rev)
+	cmd.Dir = repoDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git reset --hard: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// RevertRange commits a single revert of the commits in from..to on top of
+// the current branch and returns the new commit SHA.
+func RevertRange(ctx context.Context, repoDir, from, to, message string) (string, error) {
+	revert := exec.CommandContext(ctx, "git", "revert", "--no-commit", from+".."+to)
+	revert.Dir = repoDir
+	if out, err := revert.CombinedOutput(); err != nil {
+		abort := exec.CommandContext(ctx, "git", "revert", "--abort")
+		abort.Dir = repoDir
+		abort.Run()
+		return "", fmt.Errorf("git revert: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	sha, committed, err := AddAndCommit(ctx, repoDir, message)
+	if err != nil {
+		return "", err
+	}
+	if !committed {
+		return "", fmt.Errorf("git revert: nothing to revert")
+	}
+	return sha, nil
+}
+
+// PushWithLease updates branch on the remote to HEAD, possibly rewriting it,
+// but only if the remote branch still points at expected. A concurrent push
+// makes it fail instead of being overwritten.
+func PushWithLease(ctx context.Context, repoDir, branch, expected, gitURL, token string, useLocalGit bool) error {
+	ref := "refs/heads/" + branch
+	lease := "--force-with-lease=" + ref + ":" + expected
+	args := []string{"push", lease, "origin", "HEAD:" + ref}
+	if !useLocalGit {
+		// Same as Push: auth URL directly, no credential helpers
+		if token == "" {
+			return fmt.Errorf("token is empty, cannot push")
+		}
+		authURL, err := injectToken(rewriteGitURL(gitURL), token)
+		if err != nil {
+			return fmt.Errorf("inject token: %w", err)
+		}
+		args = []string{"-c", "credential.helper=", "push", lease, authURL, "HEAD:" + ref}
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = repoDir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git push --force-with-lease: %s: %w", sanitize(string(output), token), err)
+	}
+	return nil
+}
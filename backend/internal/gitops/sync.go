@@ -0,0 +1,128 @@
+package gitops
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/codeMaster/backend/internal/model"
+)
+
+// SyncResult is the outcome of SyncBranch.
+type SyncResult struct {
+	Updated   bool     // the branch moved
+	Conflicts []string // files that could not be merged; the branch did not move
+	Strategy  string   // strategy that produced the result
+}
+
+// IsAncestor reports whether commit a is reachable from b.
+func IsAncestor(ctx context.Context, repoDir, a, b string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "git", "merge-base", "--is-ancestor", a, b)
+	cmd.Dir = repoDir
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("git merge-base --is-ancestor: %w", err)
+}
+
+// SyncBranch brings the checked-out branch up to date with upstream using
+// model.BranchSyncMerge or model.BranchSyncRebase. On conflicts the branch is
+// restored, unless keepConflicts is set: then a conflicted rebase is retried
+// as a merge, which is left in progress for someone to resolve and commit
+// with CommitMerge. The repository needs the history of both sides.
+func SyncBranch(ctx context.Context, repoDir, upstream, strategy string, keepConflicts bool) (*SyncResult, error) {
+	upToDate, err := IsAncestor(ctx, repoDir, upstream, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if upToDate {
+		return &SyncResult{Strategy: strategy}, nil
+	}
+
+	if strategy == model.BranchSyncRebase {
+		out, err := gitRun(ctx, repoDir, "rebase", upstream)
+		if err == nil {
+			return &SyncResult{Updated: true, Strategy: strategy}, nil
+		}
+		conflicts := unmergedFiles(ctx, repoDir)
+		gitRun(ctx, repoDir, "rebase", "--abort")
+		if len(conflicts) == 0 {
+			return nil, fmt.Errorf("git rebase: %s: %w", out, err)
+		}
+		if !keepConflicts {
+			return &SyncResult{Conflicts: conflicts, Strategy: strategy}, nil
+		}
+		// A half-done rebase cannot be handed over; resolve a merge instead
+		strategy = model.BranchSyncMerge
+	}
+
+	out, err := gitRun(ctx, repoDir, "merge", "--no-ff", "--no-edit", upstream)
+	if err == nil {
+		return &SyncResult{Updated: true, Strategy: strategy}, nil
+	}
+	conflicts := unmergedFiles(ctx, repoDir)
+	if len(conflicts) == 0 || !keepConflicts {
+		gitRun(ctx, repoDir, "merge", "--abort")
+	}
+	if len(conflicts) == 0 {
+		return nil, fmt.Errorf("git merge: %s: %w", out, err)
+	}
+	return &SyncResult{Conflicts: conflicts, Strategy: strategy}, nil
+}
+
+// ConflictMarkers returns the files that still contain conflict markers.
+func ConflictMarkers(repoDir string, files []string) []string {
+	var left []string
+	for _, f := range files {
+		file, err := os.Open(filepath.Join(repoDir, f))
+		if err != nil {
+			continue // deleted while resolving
+		}
+		sc := bufio.NewScanner(file)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			line := sc.Text()
+			if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+				left = append(left, f)
+				break
+			}
+		}
+		file.Close()
+	}
+	return left
+}
+
+// CommitMerge stages everything and concludes a merge left in progress by
+// SyncBranch, returning the merge commit SHA.
+func CommitMerge(ctx context.Context, repoDir string) (string, error) {
+	if out, err := gitRun(ctx, repoDir, "add", "-A"); err != nil {
+		return "", fmt.Errorf("git add: %s: %w", out, err)
+	}
+	if out, err := gitRun(ctx, repoDir, "commit", "--no-edit"); err != nil {
+		return "", fmt.Errorf("git commit: %s: %w", out, err)
+	}
+	return RevParse(ctx, repoDir, "HEAD")
+}
+
+func unmergedFiles(ctx context.Context, repoDir string) []string {
+	out, err := gitRun(ctx, repoDir, "diff", "--name-only", "--diff-filter=U")
+	if err != nil || out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
+
+func gitRun(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
@@ -3,11 +3,15 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/codeMaster/backend/internal/diffstore"
 	"github.com/codeMaster/backend/internal/middleware"
+	"github.com/codeMaster/backend/internal/model"
 	"github.com/codeMaster/backend/internal/service"
 	"github.com/codeMaster/backend/internal/sse"
 	"github.com/gin-gonic/gin"
@@ -18,6 +22,7 @@ type CodegenHandler struct {
 	reqService     *service.RequirementService
 	repoService    *service.RepositoryService
 	reviewService  *service.ReviewService
+	authService    *service.AuthService
 }
 
 func NewCodegenHandler(
@@ -25,12 +30,14 @@ func NewCodegenHandler(
 	reqService *service.RequirementService,
 	repoService *service.RepositoryService,
 	reviewService *service.ReviewService,
+	authService *service.AuthService,
 ) *CodegenHandler {
 	return &CodegenHandler{
 		codegenService: codegenService,
 		reqService:     reqService,
 		repoService:    repoService,
 		reviewService:  reviewService,
+		authService:    authService,
 	}
 }
 
@@ -71,11 +78,20 @@ func (h *CodegenHandler) Generate(c *gin.Context) {
 		ExtraContext  string `json:"extra_context"`
 		SourceBranch  string `json:"source_branch"`
 		ResumeTaskID  *uint  `json:"resume_task_id"`
+		Mode          string `json:"mode"`
 	}
 	c.ShouldBindJSON(&body)
+	if body.Mode != model.TaskModeDirect && body.Mode != model.TaskModePlan {
+		BadRequest(c, 40001, "参数校验失败: mode 仅支持 plan 或留空")
+		return
+	}
 
-	task, queuePos, err := h.codegenService.TriggerGeneration(requirement, repo, body.ExtraContext, body.SourceBranch, middleware.GetCurrentUserID(c), body.ResumeTaskID)
+	task, queuePos, err := h.codegenService.TriggerGeneration(requirement, repo, body.ExtraContext, body.SourceBranch, middleware.GetCurrentUserID(c), body.ResumeTaskID, body.Mode)
 	if err != nil {
+		if code, msg := parseErrorCode(err); code != 50001 {
+			BadRequest(c, code, msg)
+			return
+		}
 		InternalError(c, err.Error())
 		return
 	}
@@ -85,6 +101,7 @@ func (h *CodegenHandler) Generate(c *gin.Context) {
 		"status":         task.Status,
 		"source_branch":  task.SourceBranch,
 		"target_branch":  task.TargetBranch,
+		"mode":           task.Mode,
 		"queue_position": queuePos,
 	})
 }
@@ -142,7 +159,7 @@ func (h *CodegenHandler) Stream(c *gin.Context) {
 		return
 	}
 
-	if task.Status == "completed" || task.Status == "failed" || task.Status == "cancelled" {
+	if task.Status == "completed" || task.Status == "failed" || task.Status == "cancelled" || task.Status == "awaiting_approval" || task.Status == "quarantined" {
 		fmt.Fprintf(c.Writer, "event: done\ndata: {\"status\":\"%s\",\"task_id\":%d}\n\n", task.Status, task.ID)
 		flusher.Flush()
 		return
@@ -219,12 +236,46 @@ func (h *CodegenHandler) GetTask(c *gin.Context) {
 	if task.CommitSHA != "" {
 		data["commit_sha"] = task.CommitSHA
 	}
+	if task.BaseSHA != "" {
+		data["base_sha"] = task.BaseSHA
+	}
+	if len(task.Commits) > 0 {
+		data["commits"] = task.Commits
+	}
 	if task.SessionID != "" {
 		data["session_id"] = task.SessionID
 	}
 	if task.ResumeTaskID != nil {
 		data["resume_task_id"] = *task.ResumeTaskID
 	}
+	if task.RevertedAt != nil {
+		data["revert_mode"] = task.RevertMode
+		data["revert_sha"] = task.RevertSHA
+		data["reverted_by"] = task.RevertedBy
+		data["reverted_at"] = task.RevertedAt
+	}
+	if task.BranchSync.Data != nil {
+		data["branch_sync"] = task.BranchSync.Data
+	}
+	if task.BudgetUSD > 0 {
+		data["budget_usd"] = task.BudgetUSD
+	}
+	if task.VerifyStatus != "" {
+		data["verify_status"] = task.VerifyStatus
+		data["verify_result"] = task.VerifyResult.Data
+	}
+	if task.Mode != "" {
+		data["mode"] = task.Mode
+		data["plan"] = task.Plan.Data
+		data["plan_status"] = task.PlanStatus
+		if task.PlanReviewedBy != nil {
+			data["plan_reviewed_by"] = *task.PlanReviewedBy
+			data["plan_reviewed_at"] = task.PlanReviewedAt
+		}
+		if task.PlanComment != "" {
+			data["plan_comment"] = task.PlanComment
+		}
+	}
 	if task.Requirement != nil {
 		data["requirement"] = gin.H{"id": task.Requirement.ID, "title": task.Requirement.Title}
 	}
@@ -282,24 +333,40 @@ func (h *CodegenHandler) ListTasks(c *gin.Context) {
 		if t.SessionID != "" {
 			item["session_id"] = t.SessionID
 		}
+		if t.VerifyStatus != "" {
+			item["verify_status"] = t.VerifyStatus
+		}
+		if t.Mode != "" {
+			item["mode"] = t.Mode
+			item["plan_status"] = t.PlanStatus
+		}
 		if t.ResumeTaskID != nil {
 			item["resume_task_id"] = *t.ResumeTaskID
 		}
+		if t.RevertedAt != nil {
+			item["revert_mode"] = t.RevertMode
+			item["reverted_at"] = t.RevertedAt
+		}
 		list = append(list, item)
 	}
 	SuccessPaged(c, list, total, page, pageSize)
 }
 
+// Hunk pagination of GET /codegen/:id/diff/file
+const (
+	defaultHunkLimit = 50
+	maxHunkLimit     = 200
+)
+
 // GET /codegen/:id/diff
 func (h *CodegenHandler) GetDiff(c *gin.Context) {
 	taskID := parseID(c.Param("id"))
-	task, err := h.codegenService.GetTask(taskID)
-	if err != nil {
-		NotFound(c, 40405, "生成任务不存在")
+	task, ok := h.diffTask(c, taskID)
+	if !ok {
 		return
 	}
-	if task.Status != "completed" {
-		BadRequest(c, 40003, "任务尚未完成，无法获取 diff")
+	if file := c.Query("file"); file != "" {
+		h.writeDiffFile(c, task.DiffStat.Data, file)
 		return
 	}
 
@@ -307,25 +374,233 @@ func (h *CodegenHandler) GetDiff(c *gin.Context) {
 		"target_branch": task.TargetBranch,
 		"base_branch":   task.SourceBranch,
 	}
-	if task.DiffStat.Data != nil {
-		files := make([]gin.H, 0)
-		for _, f := range task.DiffStat.Data.Files {
-			item := gin.H{
-				"path":      f.Path,
-				"status":    f.Status,
-				"additions": f.Additions,
-				"deletions": f.Deletions,
-			}
-			if f.Diff != "" {
-				item["diff"] = f.Diff
-			}
-			files = append(files, item)
+	if stat := task.DiffStat.Data; stat != nil {
+		files := make([]gin.H, 0, len(stat.Files))
+		for _, f := range stat.Files {
+			files = append(files, diffFileItem(f))
 		}
+		data["files_changed"] = stat.FilesChanged
+		data["additions"] = stat.Additions
+		data["deletions"] = stat.Deletions
 		data["files"] = files
+		data["tree"] = diffstore.BuildTree(stat.Files)
 	}
 	Success(c, data)
 }
 
+// GET /codegen/:id/diff/file?path=
+func (h *CodegenHandler) GetDiffFile(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+	task, ok := h.diffTask(c, taskID)
+	if !ok {
+		return
+	}
+	path := c.Query("path")
+	if path == "" {
+		BadRequest(c, 40001, "参数校验失败: path 不能为空")
+		return
+	}
+	h.writeDiffFile(c, task.DiffStat.Data, path)
+}
+
+func (h *CodegenHandler) diffTask(c *gin.Context, taskID uint) (*model.CodegenTask, bool) {
+	task, err := h.codegenService.GetTask(taskID)
+	if err != nil {
+		NotFound(c, 40405, "生成任务不存在")
+		return nil, false
+	}
+	// Quarantined tasks keep their diff for whoever decides on the release
+	if task.Status != "completed" && task.Status != "quarantined" {
+		BadRequest(c, 40003, "任务尚未完成，无法获取 diff")
+		return nil, false
+	}
+	return task, true
+}
+
+// writeDiffFile responds with the patch of one file, a page of hunks at a
+// time: hunk_offset (default 0) and hunk_limit (default 50, max 200).
+func (h *CodegenHandler) writeDiffFile(c *gin.Context, stat *model.DiffStat, path string) {
+	var file *model.DiffFile
+	if stat != nil {
+		for i, f := range stat.Files {
+			if f.Path == path {
+				file = &stat.Files[i]
+				break
+			}
+		}
+	}
+	if file == nil {
+		NotFound(c, 40408, "文件不在本次变更中")
+		return
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("hunk_offset", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("hunk_limit", strconv.Itoa(defaultHunkLimit)))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultHunkLimit
+	}
+	if limit > maxHunkLimit {
+		limit = maxHunkLimit
+	}
+
+	patch, err := h.codegenService.DiffPatch(*file)
+	if err != nil {
+		InternalError(c, "读取 diff 失败: "+err.Error())
+		return
+	}
+	header, hunks := diffstore.SplitHunks(patch)
+	total := len(hunks)
+	end := offset + limit
+	if offset > total {
+		offset = total
+	}
+	if end > total {
+		end = total
+	}
+
+	item := diffFileItem(*file)
+	item["header"] = header
+	item["hunks"] = hunks[offset:end]
+	item["hunk_total"] = total
+	item["hunk_offset"] = offset
+	item["hunk_limit"] = limit
+	item["has_more"] = end < total
+	Success(c, item)
+}
+
+// GET /codegen/:id/compare?base_task_id=
+func (h *CodegenHandler) Compare(c *gin.Context) {
+	cmp, ok := h.compare(c)
+	if !ok {
+		return
+	}
+	if file := c.Query("file"); file != "" {
+		h.writeDiffFile(c, cmp.Stat, file)
+		return
+	}
+
+	files := make([]gin.H, 0, len(cmp.Stat.Files))
+	for _, f := range cmp.Stat.Files {
+		files = append(files, diffFileItem(f))
+	}
+	Success(c, gin.H{
+		"base_task_id":  cmp.Base.ID,
+		"head_task_id":  cmp.Head.ID,
+		"base_commit":   cmp.Base.CommitSHA,
+		"head_commit":   cmp.Head.CommitSHA,
+		"base_from":     cmp.BaseFrom,
+		"files_changed": cmp.Stat.FilesChanged,
+		"additions":     cmp.Stat.Additions,
+		"deletions":     cmp.Stat.Deletions,
+		"files":         files,
+		"tree":          diffstore.BuildTree(cmp.Stat.Files),
+	})
+}
+
+// GET /codegen/:id/compare/file?base_task_id=&path=
+func (h *CodegenHandler) CompareFile(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" {
+		BadRequest(c, 40001, "参数校验失败: path 不能为空")
+		return
+	}
+	cmp, ok := h.compare(c)
+	if !ok {
+		return
+	}
+	h.writeDiffFile(c, cmp.Stat, path)
+}
+
+func (h *CodegenHandler) compare(c *gin.Context) (*service.IterationCompare, bool) {
+	taskID := parseID(c.Param("id"))
+	var baseTaskID *uint
+	if v := c.Query("base_task_id"); v != "" {
+		id := parseID(v)
+		if id == 0 {
+			BadRequest(c, 40001, "参数校验失败: base_task_id 无效")
+			return nil, false
+		}
+		baseTaskID = &id
+	}
+	cmp, err := h.codegenService.CompareIterations(taskID, baseTaskID)
+	if err != nil {
+		code, msg := parseErrorCode(err)
+		switch {
+		case code == 40405:
+			NotFound(c, code, msg)
+		case code != 50001:
+			BadRequest(c, code, msg)
+		default:
+			InternalError(c, "对比迭代失败: "+err.Error())
+		}
+		return nil, false
+	}
+	return cmp, true
+}
+
+func diffFileItem(f model.DiffFile) gin.H {
+	item := gin.H{
+		"path":      f.Path,
+		"status":    f.Status,
+		"additions": f.Additions,
+		"deletions": f.Deletions,
+	}
+	if f.OldPath != "" {
+		item["old_path"] = f.OldPath
+		item["similarity"] = f.Similarity
+	}
+	if f.Binary {
+		item["binary"] = true
+	}
+	if f.OldMode != "" {
+		item["old_mode"] = f.OldMode
+		item["new_mode"] = f.NewMode
+	}
+	if f.Hunks > 0 {
+		item["hunk_total"] = f.Hunks
+	}
+	return item
+}
+
+// GET /codegen/:id/patch
+func (h *CodegenHandler) Patch(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+	task, patch, err := h.codegenService.ExportPatch(taskID)
+	if err != nil {
+		writeExportError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="task-%d.patch"`, task.ID))
+	c.Data(http.StatusOK, "text/x-patch; charset=utf-8", patch)
+}
+
+// GET /codegen/:id/bundle
+func (h *CodegenHandler) Bundle(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+	task, path, cleanup, err := h.codegenService.ExportBundle(taskID)
+	if err != nil {
+		writeExportError(c, err)
+		return
+	}
+	defer cleanup()
+	c.Header("Content-Type", "application/octet-stream")
+	c.FileAttachment(path, fmt.Sprintf("task-%d.bundle", task.ID))
+}
+
+func writeExportError(c *gin.Context, err error) {
+	code, msg := parseErrorCode(err)
+	switch {
+	case code == 40405:
+		NotFound(c, code, msg)
+	case code != 50001:
+		BadRequest(c, code, msg)
+	default:
+		InternalError(c, "导出失败: "+err.Error())
+	}
+}
+
 // GET /codegen/:id/log
 func (h *CodegenHandler) GetLog(c *gin.Context) {
 	taskID := parseID(c.Param("id"))
@@ -399,6 +674,11 @@ func (h *CodegenHandler) ManualSubmit(c *gin.Context) {
 		return
 	}
 
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		h.submitPatch(c, requirement, repo)
+		return
+	}
+
 	var body struct {
 		SourceBranch  string `json:"source_branch"`
 		CommitMessage string `json:"commit_message"`
@@ -408,6 +688,10 @@ func (h *CodegenHandler) ManualSubmit(c *gin.Context) {
 
 	task, err := h.codegenService.ManualSubmit(requirement, repo, body.SourceBranch, body.CommitMessage, body.CommitURL, middleware.GetCurrentUserID(c))
 	if err != nil {
+		if code, msg := parseErrorCode(err); code != 50001 {
+			BadRequest(c, code, msg)
+			return
+		}
 		InternalError(c, err.Error())
 		return
 	}
@@ -420,6 +704,116 @@ func (h *CodegenHandler) ManualSubmit(c *gin.Context) {
 	})
 }
 
+// maxPatchSize caps uploaded patches of manual submissions.
+const maxPatchSize = 10 << 20
+
+// submitPatch handles a manual submission uploaded as a patch file (form
+// field "patch") with optional source_branch and commit_message fields.
+func (h *CodegenHandler) submitPatch(c *gin.Context, requirement *model.Requirement, repo *model.Repository) {
+	if h.reqService.HasRunningTask(requirement.ID) {
+		BadRequest(c, 40003, "该需求已有生成任务正在运行中")
+		return
+	}
+	fh, err := c.FormFile("patch")
+	if err != nil {
+		BadRequest(c, 40001, "参数校验失败: 请上传补丁文件 (patch)")
+		return
+	}
+	if fh.Size > maxPatchSize {
+		BadRequest(c, 40001, "参数校验失败: 补丁文件不能超过 10MB")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		InternalError(c, err.Error())
+		return
+	}
+	patch, err := io.ReadAll(io.LimitReader(f, maxPatchSize))
+	f.Close()
+	if err != nil {
+		InternalError(c, err.Error())
+		return
+	}
+
+	task, err := h.codegenService.ApplyPatch(requirement, repo, c.PostForm("source_branch"), c.PostForm("commit_message"), patch, middleware.GetCurrentUserID(c))
+	if err != nil {
+		if code, msg := parseErrorCode(err); code != 50001 {
+			BadRequest(c, code, msg)
+			return
+		}
+		InternalError(c, err.Error())
+		return
+	}
+
+	Success(c, gin.H{
+		"task_id":       task.ID,
+		"status":        task.Status,
+		"source_branch": task.SourceBranch,
+		"target_branch": task.TargetBranch,
+		"commit_sha":    task.CommitSHA,
+		"commits":       task.Commits,
+	})
+}
+
+// POST /requirements/:id/sync-branch
+func (h *CodegenHandler) SyncBranch(c *gin.Context) {
+	reqID := parseID(c.Param("id"))
+
+	requirement, err := h.reqService.GetByID(reqID)
+	if err != nil {
+		NotFound(c, 40404, "需求不存在")
+		return
+	}
+	if requirement.RepositoryID == nil {
+		BadRequest(c, 40004, "需求未关联代码仓库，请先关联仓库")
+		return
+	}
+	repo, err := h.repoService.GetByID(*requirement.RepositoryID)
+	if err != nil {
+		InternalError(c, "仓库不存在")
+		return
+	}
+	if h.reqService.HasRunningTask(requirement.ID) {
+		BadRequest(c, 40003, "该需求已有生成任务正在运行中")
+		return
+	}
+
+	var body struct {
+		Strategy     string `json:"strategy"`
+		SourceBranch string `json:"source_branch"`
+	}
+	c.ShouldBindJSON(&body)
+
+	res, err := h.codegenService.SyncRequirementBranch(requirement, repo, body.SourceBranch, body.Strategy, middleware.GetCurrentUserID(c))
+	if err != nil {
+		if code, msg := parseErrorCode(err); code != 50001 {
+			BadRequest(c, code, msg)
+			return
+		}
+		InternalError(c, err.Error())
+		return
+	}
+
+	if res.Result.Status == model.BranchSyncUpdated {
+		LogOperation(h.authService, c, "branch_sync", "requirement", requirement.ID, map[string]interface{}{
+			"branch":     res.TargetBranch,
+			"source":     res.SourceBranch,
+			"strategy":   res.Result.Strategy,
+			"source_sha": res.Result.SourceSHA,
+			"head":       res.Head,
+		})
+	}
+	Success(c, gin.H{
+		"source_branch": res.SourceBranch,
+		"target_branch": res.TargetBranch,
+		"strategy":      res.Result.Strategy,
+		"status":        res.Result.Status,
+		"source_sha":    res.Result.SourceSHA,
+		"conflicts":     res.Result.Conflicts,
+		"head":          res.Head,
+	})
+}
+
 // POST /codegen/:id/cancel
 func (h *CodegenHandler) Cancel(c *gin.Context) {
 	taskID := parseID(c.Param("id"))
@@ -447,6 +841,286 @@ func (h *CodegenHandler) Cancel(c *gin.Context) {
 	})
 }
 
+// POST /codegen/:id/input
+func (h *CodegenHandler) SendInput(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+
+	var body struct {
+		Message string `json:"message" binding:"required,max=4000"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		BadRequest(c, 40001, "参数校验失败: "+err.Error())
+		return
+	}
+
+	task, err := h.codegenService.GetTask(taskID)
+	if err != nil {
+		NotFound(c, 40405, "生成任务不存在")
+		return
+	}
+	user := middleware.GetCurrentUser(c)
+	if !middleware.GetCurrentUserIsAdmin(c) && task.UserID != user.ID && (task.Requirement == nil ||
+		(task.Requirement.CreatorID != user.ID && (task.Requirement.AssigneeID == nil || *task.Requirement.AssigneeID != user.ID))) {
+		Forbidden(c, 40303, "仅任务触发者、需求创建人、指派人或管理员可发送指令")
+		return
+	}
+	if task.Status != "running" {
+		BadRequest(c, 40003, "任务未在执行中，无法发送消息")
+		return
+	}
+
+	if err := h.codegenService.SendInput(taskID, user, body.Message); err != nil {
+		code, msg := parseErrorCode(err)
+		if code == 50001 {
+			InternalError(c, msg)
+			return
+		}
+		BadRequest(c, code, msg)
+		return
+	}
+	Success(c, gin.H{"task_id": taskID, "message": body.Message})
+}
+
+// POST /codegen/:id/plan/approve
+func (h *CodegenHandler) ApprovePlan(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+
+	var body struct {
+		Plan    *model.ImplementationPlan `json:"plan"`
+		Comment string                    `json:"comment" binding:"max=2000"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		BadRequest(c, 40001, "参数校验失败: "+err.Error())
+		return
+	}
+	if body.Plan != nil && body.Plan.Summary == "" && len(body.Plan.Steps) == 0 {
+		BadRequest(c, 40001, "参数校验失败: 修改后的方案不能为空")
+		return
+	}
+	if !h.authorizePlanReview(c, taskID) {
+		return
+	}
+
+	task, queuePos, err := h.codegenService.ApprovePlan(taskID, middleware.GetCurrentUserID(c), body.Plan, body.Comment)
+	if err != nil {
+		code, msg := parseErrorCode(err)
+		if code == 50001 {
+			InternalError(c, msg)
+			return
+		}
+		BadRequest(c, code, msg)
+		return
+	}
+	Success(c, gin.H{
+		"task_id":        task.ID,
+		"status":         task.Status,
+		"plan_status":    task.PlanStatus,
+		"queue_position": queuePos,
+	})
+}
+
+// POST /codegen/:id/plan/reject
+func (h *CodegenHandler) RejectPlan(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+
+	var body struct {
+		Comment string `json:"comment" binding:"max=2000"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		BadRequest(c, 40001, "参数校验失败: "+err.Error())
+		return
+	}
+	if !h.authorizePlanReview(c, taskID) {
+		return
+	}
+
+	if err := h.codegenService.RejectPlan(taskID, middleware.GetCurrentUserID(c), body.Comment); err != nil {
+		code, msg := parseErrorCode(err)
+		BadRequest(c, code, msg)
+		return
+	}
+	Success(c, gin.H{
+		"task_id":     taskID,
+		"status":      "cancelled",
+		"plan_status": model.PlanStatusRejected,
+	})
+}
+
+// GET /codegen/:id/approvals
+func (h *CodegenHandler) ListApprovals(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+	task, err := h.codegenService.GetTask(taskID)
+	if err != nil {
+		NotFound(c, 40405, "生成任务不存在")
+		return
+	}
+	if !h.codegenService.CanApproveTool(task, middleware.GetCurrentUser(c)) {
+		Forbidden(c, 40303, "仅需求指派人、项目所有者或管理员可查看工具调用审批")
+		return
+	}
+	Success(c, h.codegenService.ListApprovals(taskID))
+}
+
+// POST /codegen/:id/approvals/:approval_id/approve
+func (h *CodegenHandler) ApproveTool(c *gin.Context) {
+	h.resolveApproval(c, true)
+}
+
+// POST /codegen/:id/approvals/:approval_id/deny
+func (h *CodegenHandler) DenyTool(c *gin.Context) {
+	h.resolveApproval(c, false)
+}
+
+func (h *CodegenHandler) resolveApproval(c *gin.Context, approve bool) {
+	taskID := parseID(c.Param("id"))
+	approvalID := c.Param("approval_id")
+
+	var body struct {
+		Comment string `json:"comment" binding:"max=2000"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			BadRequest(c, 40001, "参数校验失败: "+err.Error())
+			return
+		}
+	}
+
+	task, err := h.codegenService.GetTask(taskID)
+	if err != nil {
+		NotFound(c, 40405, "生成任务不存在")
+		return
+	}
+	user := middleware.GetCurrentUser(c)
+	if !h.codegenService.CanApproveTool(task, user) {
+		Forbidden(c, 40303, "仅需求指派人、项目所有者或管理员可审批工具调用")
+		return
+	}
+
+	if err := h.codegenService.ResolveApproval(taskID, approvalID, user, approve, body.Comment); err != nil {
+		code, msg := parseErrorCode(err)
+		switch {
+		case code == 50001:
+			InternalError(c, msg)
+		case code == 40407:
+			NotFound(c, code, msg)
+		default:
+			BadRequest(c, code, msg)
+		}
+		return
+	}
+	Success(c, gin.H{"task_id": taskID, "approval_id": approvalID, "approved": approve})
+}
+
+// POST /codegen/:id/release
+func (h *CodegenHandler) Release(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+
+	task, err := h.codegenService.GetTask(taskID)
+	if err != nil {
+		NotFound(c, 40405, "生成任务不存在")
+		return
+	}
+	user := middleware.GetCurrentUser(c)
+	if !user.IsAdmin && !h.codegenService.IsProjectOwner(task, user.ID) {
+		Forbidden(c, 40303, "仅项目所有者或管理员可放行被隔离的任务")
+		return
+	}
+
+	released, err := h.codegenService.ReleaseQuarantined(taskID)
+	if err != nil {
+		code, msg := parseErrorCode(err)
+		if code == 50001 {
+			InternalError(c, msg)
+		} else {
+			BadRequest(c, code, msg)
+		}
+		return
+	}
+	Success(c, gin.H{
+		"id":         released.ID,
+		"status":     "completed",
+		"commit_sha": released.CommitSHA,
+	})
+}
+
+// POST /codegen/:id/revert
+func (h *CodegenHandler) Revert(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+
+	var body struct {
+		Mode string `json:"mode"`
+	}
+	c.ShouldBindJSON(&body)
+	if body.Mode == "" {
+		body.Mode = model.RevertModeReset
+	}
+	if body.Mode != model.RevertModeReset && body.Mode != model.RevertModeRevert {
+		BadRequest(c, 40001, "参数校验失败: mode 仅支持 reset / revert")
+		return
+	}
+
+	task, err := h.codegenService.GetTask(taskID)
+	if err != nil {
+		NotFound(c, 40405, "生成任务不存在")
+		return
+	}
+	user := middleware.GetCurrentUser(c)
+	if task.UserID != user.ID && !h.codegenService.CanApproveTool(task, user) {
+		Forbidden(c, 40303, "仅任务触发者、需求指派人、项目所有者或管理员可回滚迭代")
+		return
+	}
+
+	res, err := h.codegenService.RevertIteration(taskID, user.ID, body.Mode)
+	if err != nil {
+		code, msg := parseErrorCode(err)
+		if code == 50001 {
+			InternalError(c, msg)
+		} else {
+			BadRequest(c, code, msg)
+		}
+		return
+	}
+
+	detail := map[string]interface{}{
+		"requirement_id": task.RequirementID,
+		"branch":         task.TargetBranch,
+		"mode":           body.Mode,
+		"from_commit":    res.FromSHA,
+		"to_commit":      res.ToSHA,
+	}
+	data := gin.H{
+		"id":          task.ID,
+		"revert_mode": body.Mode,
+		"from_commit": res.FromSHA,
+		"to_commit":   res.ToSHA,
+	}
+	if res.Previous != nil {
+		detail["previous_task_id"] = res.Previous.ID
+		data["previous_task_id"] = res.Previous.ID
+	}
+	LogOperation(h.authService, c, "codegen_revert", "codegen_task", task.ID, detail)
+	Success(c, data)
+}
+
+// authorizePlanReview allows the requirement assignee and admins to review a
+// plan. It writes the error response and returns false otherwise.
+func (h *CodegenHandler) authorizePlanReview(c *gin.Context, taskID uint) bool {
+	task, err := h.codegenService.GetTask(taskID)
+	if err != nil {
+		NotFound(c, 40405, "生成任务不存在")
+		return false
+	}
+	if middleware.GetCurrentUserIsAdmin(c) {
+		return true
+	}
+	userID := middleware.GetCurrentUserID(c)
+	if task.Requirement != nil && task.Requirement.AssigneeID != nil && *task.Requirement.AssigneeID == userID {
+		return true
+	}
+	Forbidden(c, 40303, "仅需求指派人或管理员可评审实现方案")
+	return false
+}
+
 // GET /requirements/:id/sessions
 func (h *CodegenHandler) ListSessions(c *gin.Context) {
 	reqID := parseID(c.Param("id"))
@@ -476,3 +1150,135 @@ func (h *CodegenHandler) ListSessions(c *gin.Context) {
 
 	Success(c, list)
 }
+
+// GET /codegen/queue
+func (h *CodegenHandler) ListQueue(c *gin.Context) {
+	var projectID *uint
+	if s := c.Query("project_id"); s != "" {
+		v := parseID(s)
+		projectID = &v
+	}
+
+	items, err := h.codegenService.ListQueue(middleware.GetCurrentUserID(c), middleware.GetCurrentUserIsAdmin(c), projectID)
+	if err != nil {
+		InternalError(c, err.Error())
+		return
+	}
+
+	now := time.Now()
+	list := make([]gin.H, 0, len(items))
+	for _, it := range items {
+		item := gin.H{
+			"task_id":          it.TaskID,
+			"position":         it.Position,
+			"project_position": it.ProjectPosition,
+			"status":           it.Status,
+			"enqueued_at":      it.EnqueuedAt,
+			"wait_seconds":     int64(now.Sub(it.EnqueuedAt).Seconds()),
+		}
+		if it.Requirement != nil {
+			item["requirement"] = gin.H{"id": it.Requirement.ID, "title": it.Requirement.Title, "priority": it.Requirement.Priority}
+		}
+		if it.Project != nil {
+			item["project"] = gin.H{"id": it.Project.ID, "name": it.Project.Name}
+		}
+		if it.User != nil {
+			item["owner"] = it.User.Brief()
+		}
+		list = append(list, item)
+	}
+
+	running, workers := h.codegenService.QueueStats()
+	Success(c, gin.H{
+		"list":        list,
+		"total":       len(list),
+		"running":     running,
+		"max_workers": workers,
+	})
+}
+
+// PUT /codegen/queue/:id/position
+func (h *CodegenHandler) MoveQueueItem(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+	if !h.authorizeQueueItem(c, taskID) {
+		return
+	}
+
+	var body struct {
+		Position int `json:"position" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		BadRequest(c, 40001, "参数校验失败: "+err.Error())
+		return
+	}
+
+	// Only admins reorder the whole queue; others reorder their project's tasks
+	withinProject := !middleware.GetCurrentUserIsAdmin(c)
+	if err := h.codegenService.MoveQueueItem(taskID, body.Position, withinProject); err != nil {
+		code, msg := parseErrorCode(err)
+		BadRequest(c, code, msg)
+		return
+	}
+	Success(c, gin.H{"task_id": taskID, "position": body.Position})
+}
+
+// POST /codegen/queue/:id/pause
+func (h *CodegenHandler) PauseQueueItem(c *gin.Context) {
+	h.setQueueItemPaused(c, true)
+}
+
+// POST /codegen/queue/:id/resume
+func (h *CodegenHandler) ResumeQueueItem(c *gin.Context) {
+	h.setQueueItemPaused(c, false)
+}
+
+func (h *CodegenHandler) setQueueItemPaused(c *gin.Context, paused bool) {
+	taskID := parseID(c.Param("id"))
+	if !h.authorizeQueueItem(c, taskID) {
+		return
+	}
+	if err := h.codegenService.PauseQueueItem(taskID, paused); err != nil {
+		code, msg := parseErrorCode(err)
+		BadRequest(c, code, msg)
+		return
+	}
+	status := "queued"
+	if paused {
+		status = "paused"
+	}
+	Success(c, gin.H{"task_id": taskID, "status": status})
+}
+
+// DELETE /codegen/queue/:id
+func (h *CodegenHandler) RemoveQueueItem(c *gin.Context) {
+	taskID := parseID(c.Param("id"))
+	if !h.authorizeQueueItem(c, taskID) {
+		return
+	}
+	if err := h.codegenService.RemoveQueueItem(taskID); err != nil {
+		code, msg := parseErrorCode(err)
+		BadRequest(c, code, msg)
+		return
+	}
+	Success(c, gin.H{"task_id": taskID, "status": "cancelled"})
+}
+
+// authorizeQueueItem allows admins, the user who triggered the task and the project owner
+// to manage a waiting queue entry. It writes the error response and returns false otherwise.
+func (h *CodegenHandler) authorizeQueueItem(c *gin.Context, taskID uint) bool {
+	item, err := h.codegenService.GetQueueItem(taskID)
+	if err != nil {
+		code, msg := parseErrorCode(err)
+		NotFound(c, code, msg)
+		return false
+	}
+	if middleware.GetCurrentUserIsAdmin(c) {
+		return true
+	}
+	userID := middleware.GetCurrentUserID(c)
+	if item.UserID == userID || (item.Project != nil && item.Project.OwnerID == userID) {
+		return true
+	}
+	Forbidden(c, 40303, "仅任务触发者、项目所有者或管理员可管理队列")
+	return false
+}
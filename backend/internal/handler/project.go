@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"github.com/codeMaster/backend/internal/agent"
+	"github.com/codeMaster/backend/internal/codegen"
 	"github.com/codeMaster/backend/internal/middleware"
 	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/internal/sandbox"
 	"github.com/codeMaster/backend/internal/service"
 	"github.com/gin-gonic/gin"
 )
@@ -136,6 +139,16 @@ func (h *ProjectHandler) GetDetail(c *gin.Context) {
 		"members":     members,
 		"stats":       stats,
 		"status":      project.Status,
+		"monthly_budget_usd": project.MonthlyBudgetUSD,
+		"task_budget_usd":    project.TaskBudgetUSD,
+		"month_spent_usd":    h.projectService.GetMonthlySpend(id),
+		"sandbox_policy":     project.SandboxPolicy.Data,
+		"tool_policy":        project.ToolPolicy.Data,
+		"diff_policy":        project.DiffPolicy.Data,
+		"branch_sync":        project.BranchSync.Data,
+		"git_templates":      project.GitTemplates.Data,
+		"commit_policy":      project.CommitPolicy.Data,
+		"commit_identity":    project.CommitIdentity.Data,
 		"created_at":  project.CreatedAt,
 		"updated_at":  project.UpdatedAt,
 	})
@@ -160,11 +173,102 @@ func (h *ProjectHandler) Update(c *gin.Context) {
 		Name        *string         `json:"name"`
 		Description *string         `json:"description"`
 		DocLinks    *model.DocLinks `json:"doc_links"`
+
+		MonthlyBudgetUSD *float64 `json:"monthly_budget_usd" binding:"omitempty,min=0"`
+		TaskBudgetUSD    *float64 `json:"task_budget_usd" binding:"omitempty,min=0"`
+
+		SandboxPolicy *model.SandboxPolicy `json:"sandbox_policy"`
+		ToolPolicy    *model.ToolPolicy    `json:"tool_policy"`
+		DiffPolicy    *model.DiffPolicy    `json:"diff_policy"`
+		BranchSync    *model.BranchSync    `json:"branch_sync"`
+		GitTemplates  *model.GitTemplates  `json:"git_templates"`
+		CommitPolicy  *model.CommitPolicy  `json:"commit_policy"`
+
+		// Only the mode; the signing key has its own endpoints
+		CommitIdentity *model.CommitIdentity `json:"commit_identity"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		BadRequest(c, 40001, "参数校验失败: "+err.Error())
 		return
 	}
+	if (req.MonthlyBudgetUSD != nil || req.TaskBudgetUSD != nil) && !middleware.GetCurrentUserIsAdmin(c) {
+		Forbidden(c, 40301, "权限不足，仅管理员可修改项目预算")
+		return
+	}
+	if req.SandboxPolicy != nil {
+		if !middleware.GetCurrentUserIsAdmin(c) {
+			Forbidden(c, 40301, "权限不足，仅管理员可修改沙箱策略")
+			return
+		}
+		p := req.SandboxPolicy
+		if !sandbox.ValidNetwork(p.Network) {
+			BadRequest(c, 40002, "network 取值必须为 allow / deny / verify")
+			return
+		}
+		if p.CPUs < 0 || p.MemoryMB < 0 || p.PidsMax < 0 || p.TimeoutMinutes < 0 {
+			BadRequest(c, 40001, "参数校验失败: 资源限制不能为负数")
+			return
+		}
+	}
+	if req.ToolPolicy != nil {
+		if !middleware.GetCurrentUserIsAdmin(c) {
+			Forbidden(c, 40301, "权限不足，仅管理员可修改工具策略")
+			return
+		}
+	}
+	// Approvals can't outlast the task, so a shorter task timeout is checked too
+	if req.ToolPolicy != nil || req.SandboxPolicy != nil {
+		toolPolicy, sandboxPolicy := project.ToolPolicy.Data, project.SandboxPolicy.Data
+		if req.ToolPolicy != nil {
+			toolPolicy = req.ToolPolicy
+		}
+		if req.SandboxPolicy != nil {
+			sandboxPolicy = req.SandboxPolicy
+		}
+		if toolPolicy != nil {
+			if err := agent.ValidateToolPolicy(toolPolicy, h.projectService.TaskTimeoutMinutes(sandboxPolicy)); err != nil {
+				BadRequest(c, 40002, err.Error())
+				return
+			}
+		}
+	}
+	if req.DiffPolicy != nil {
+		if !middleware.GetCurrentUserIsAdmin(c) {
+			Forbidden(c, 40301, "权限不足，仅管理员可修改变更策略")
+			return
+		}
+		if err := codegen.ValidateDiffPolicy(req.DiffPolicy); err != nil {
+			BadRequest(c, 40002, err.Error())
+			return
+		}
+	}
+
+	if req.BranchSync != nil {
+		if err := codegen.ValidateBranchSync(req.BranchSync); err != nil {
+			BadRequest(c, 40002, err.Error())
+			return
+		}
+	}
+	if req.GitTemplates != nil {
+		if err := codegen.ValidateGitTemplates(req.GitTemplates); err != nil {
+			BadRequest(c, 40002, err.Error())
+			return
+		}
+	}
+	if req.CommitPolicy != nil {
+		if err := codegen.ValidateCommitPolicy(req.CommitPolicy); err != nil {
+			BadRequest(c, 40002, err.Error())
+			return
+		}
+	}
+	if req.CommitIdentity != nil {
+		switch req.CommitIdentity.Mode {
+		case "", model.CommitAsBot, model.CommitAsAuthor, model.CommitAsCoAuthor:
+		default:
+			BadRequest(c, 40002, "mode 取值必须为 bot / author / co_author")
+			return
+		}
+	}
 
 	updates := make(map[string]interface{})
 	if req.Name != nil {
@@ -176,6 +280,38 @@ func (h *ProjectHandler) Update(c *gin.Context) {
 	if req.DocLinks != nil {
 		updates["doc_links"] = *req.DocLinks
 	}
+	if req.MonthlyBudgetUSD != nil {
+		updates["monthly_budget_usd"] = *req.MonthlyBudgetUSD
+	}
+	if req.TaskBudgetUSD != nil {
+		updates["task_budget_usd"] = *req.TaskBudgetUSD
+	}
+	if req.SandboxPolicy != nil {
+		updates["sandbox_policy"] = model.JSONSandboxPolicy{Data: req.SandboxPolicy}
+	}
+	if req.ToolPolicy != nil {
+		updates["tool_policy"] = model.JSONToolPolicy{Data: req.ToolPolicy}
+	}
+	if req.DiffPolicy != nil {
+		updates["diff_policy"] = model.JSONDiffPolicy{Data: req.DiffPolicy}
+	}
+	if req.BranchSync != nil {
+		updates["branch_sync"] = model.JSONBranchSync{Data: req.BranchSync}
+	}
+	if req.GitTemplates != nil {
+		updates["git_templates"] = model.JSONGitTemplates{Data: req.GitTemplates}
+	}
+	if req.CommitPolicy != nil {
+		updates["commit_policy"] = model.JSONCommitPolicy{Data: req.CommitPolicy}
+	}
+	if req.CommitIdentity != nil {
+		identity := model.CommitIdentity{}
+		if project.CommitIdentity.Data != nil {
+			identity = *project.CommitIdentity.Data
+		}
+		identity.Mode = req.CommitIdentity.Mode
+		updates["commit_identity"] = model.JSONCommitIdentity{Data: &identity}
+	}
 
 	updated, err := h.projectService.Update(id, updates)
 	if err != nil {
@@ -189,10 +325,78 @@ func (h *ProjectHandler) Update(c *gin.Context) {
 		"name":        updated.Name,
 		"description": updated.Description,
 		"doc_links":   updated.DocLinks,
+		"monthly_budget_usd": updated.MonthlyBudgetUSD,
+		"task_budget_usd":    updated.TaskBudgetUSD,
+		"sandbox_policy":     updated.SandboxPolicy.Data,
+		"tool_policy":        updated.ToolPolicy.Data,
+		"diff_policy":        updated.DiffPolicy.Data,
+		"branch_sync":        updated.BranchSync.Data,
+		"git_templates":      updated.GitTemplates.Data,
+		"commit_policy":      updated.CommitPolicy.Data,
+		"commit_identity":    updated.CommitIdentity.Data,
 		"updated_at":  updated.UpdatedAt,
 	})
 }
 
+// PUT /projects/:id/signing-key
+func (h *ProjectHandler) SetSigningKey(c *gin.Context) {
+	id := parseID(c.Param("id"))
+	userID := middleware.GetCurrentUserID(c)
+
+	project, err := h.projectService.GetByID(id)
+	if err != nil {
+		NotFound(c, 40402, "项目不存在")
+		return
+	}
+	if !middleware.GetCurrentUserIsAdmin(c) && project.OwnerID != userID {
+		Forbidden(c, 40303, "非项目所有者，无权设置签名密钥")
+		return
+	}
+
+	var req struct {
+		Format     string `json:"format" binding:"required,oneof=ssh openpgp"`
+		PrivateKey string `json:"private_key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		BadRequest(c, 40001, "参数校验失败: "+err.Error())
+		return
+	}
+
+	updated, err := h.projectService.SetSigningKey(id, req.Format, req.PrivateKey)
+	if err != nil {
+		code, msg := parseErrorCode(err)
+		BadRequest(c, code, msg)
+		return
+	}
+
+	Success(c, gin.H{"id": updated.ID, "commit_identity": updated.CommitIdentity.Data})
+}
+
+// DELETE /projects/:id/signing-key
+func (h *ProjectHandler) ClearSigningKey(c *gin.Context) {
+	id := parseID(c.Param("id"))
+	userID := middleware.GetCurrentUserID(c)
+
+	project, err := h.projectService.GetByID(id)
+	if err != nil {
+		NotFound(c, 40402, "项目不存在")
+		return
+	}
+	if !middleware.GetCurrentUserIsAdmin(c) && project.OwnerID != userID {
+		Forbidden(c, 40303, "非项目所有者，无权移除签名密钥")
+		return
+	}
+
+	updated, err := h.projectService.ClearSigningKey(id)
+	if err != nil {
+		code, msg := parseErrorCode(err)
+		BadRequest(c, code, msg)
+		return
+	}
+
+	Success(c, gin.H{"id": updated.ID, "commit_identity": updated.CommitIdentity.Data})
+}
+
 // PUT /projects/:id/archive
 func (h *ProjectHandler) Archive(c *gin.Context) {
 	id := parseID(c.Param("id"))
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"strings"
+
 	"github.com/codeMaster/backend/internal/middleware"
 	"github.com/codeMaster/backend/internal/model"
 	"github.com/codeMaster/backend/internal/service"
@@ -120,6 +122,7 @@ func (h *RepositoryHandler) GetDetail(c *gin.Context) {
 		"analysis_status":     repo.AnalysisStatus,
 		"analysis_result":     repo.AnalysisResult.Data,
 		"analyzed_at":         repo.AnalyzedAt,
+		"verify_config":       repo.VerifyConfig.Data,
 		"created_at":          repo.CreatedAt,
 	}
 	if repo.Project != nil {
@@ -133,13 +136,41 @@ func (h *RepositoryHandler) Update(c *gin.Context) {
 	id := parseID(c.Param("id"))
 
 	var req struct {
-		Name          *string `json:"name"`
-		DefaultBranch *string `json:"default_branch"`
+		Name          *string             `json:"name"`
+		DefaultBranch *string             `json:"default_branch"`
+		VerifyConfig  *model.VerifyConfig `json:"verify_config"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		BadRequest(c, 40001, "参数校验失败: "+err.Error())
 		return
 	}
+	if req.VerifyConfig != nil {
+		// Verify commands run as shell commands on the server: owner/admin only
+		repo, err := h.repoService.GetByID(id)
+		if err != nil {
+			NotFound(c, 40403, "仓库不存在")
+			return
+		}
+		project, err := h.projectService.GetByID(repo.ProjectID)
+		if err != nil {
+			NotFound(c, 40402, "项目不存在")
+			return
+		}
+		if !middleware.GetCurrentUserIsAdmin(c) && project.OwnerID != middleware.GetCurrentUserID(c) {
+			Forbidden(c, 40303, "非项目所有者，无权修改验证命令")
+			return
+		}
+		for _, cmd := range req.VerifyConfig.Commands {
+			if strings.TrimSpace(cmd.Command) == "" || cmd.TimeoutSec < 0 {
+				BadRequest(c, 40001, "参数校验失败: 验证命令不能为空，超时不能为负数")
+				return
+			}
+		}
+		if n := req.VerifyConfig.MaxFixAttempts; n != nil && (*n < 0 || *n > 10) {
+			BadRequest(c, 40001, "参数校验失败: max_fix_attempts 取值 0-10")
+			return
+		}
+	}
 
 	updates := make(map[string]interface{})
 	if req.Name != nil {
@@ -148,6 +179,9 @@ func (h *RepositoryHandler) Update(c *gin.Context) {
 	if req.DefaultBranch != nil {
 		updates["default_branch"] = *req.DefaultBranch
 	}
+	if req.VerifyConfig != nil {
+		updates["verify_config"] = model.JSONVerifyConfig{Data: req.VerifyConfig}
+	}
 
 	repo, err := h.repoService.Update(id, updates)
 	if err != nil {
@@ -160,6 +194,7 @@ func (h *RepositoryHandler) Update(c *gin.Context) {
 		"id":             repo.ID,
 		"name":           repo.Name,
 		"default_branch": repo.DefaultBranch,
+		"verify_config":  repo.VerifyConfig.Data,
 		"updated_at":     repo.UpdatedAt,
 	})
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/codeMaster/backend/internal/codegen"
 	"github.com/codeMaster/backend/internal/middleware"
 	"github.com/codeMaster/backend/internal/model"
 	"github.com/codeMaster/backend/internal/notify"
@@ -36,6 +37,7 @@ func (h *RequirementHandler) Create(c *gin.Context) {
 		Description  string         `json:"description" binding:"required"`
 		DocLinks     model.DocLinks `json:"doc_links"`
 		Priority     string         `json:"priority"`
+		Type         string         `json:"type" binding:"omitempty,oneof=feat fix refactor perf docs test chore"`
 		Deadline     *time.Time     `json:"deadline"`
 		AssigneeID   *uint          `json:"assignee_id"`
 		RepositoryID *uint          `json:"repository_id"`
@@ -49,6 +51,10 @@ func (h *RequirementHandler) Create(c *gin.Context) {
 	if priority == "" {
 		priority = "p1"
 	}
+	reqType := req.Type
+	if reqType == "" {
+		reqType = "feat"
+	}
 
 	if req.AssigneeID != nil {
 		if err := h.reqService.ValidateAssignee(projectID, *req.AssigneeID); err != nil {
@@ -71,6 +77,7 @@ func (h *RequirementHandler) Create(c *gin.Context) {
 		Description:  req.Description,
 		DocLinks:     req.DocLinks,
 		Priority:     priority,
+		Type:         reqType,
 		Deadline:     req.Deadline,
 		Status:       "draft",
 		CreatorID:    userID,
@@ -112,6 +119,7 @@ func (h *RequirementHandler) Create(c *gin.Context) {
 		"description": requirement.Description,
 		"doc_links":   requirement.DocLinks,
 		"priority":    requirement.Priority,
+		"type":        requirement.Type,
 		"deadline":    requirement.Deadline,
 		"status":      requirement.Status,
 		"created_at":  requirement.CreatedAt,
@@ -258,6 +266,7 @@ func (h *RequirementHandler) GetDetail(c *gin.Context) {
 		"description": req.Description,
 		"doc_links":   req.DocLinks,
 		"priority":    req.Priority,
+		"type":        req.Type,
 		"deadline":    req.Deadline,
 		"status":      req.Status,
 		"created_at":  req.CreatedAt,
@@ -308,6 +317,19 @@ func (h *RequirementHandler) GetDetail(c *gin.Context) {
 	}
 	data["codegen_tasks"] = taskList
 
+	// Branch the code goes to: fixed by the first task, else from the project template
+	if len(tasks) > 0 && tasks[0].TargetBranch != "" {
+		data["branch"] = tasks[0].TargetBranch
+	} else {
+		var templates *model.GitTemplates
+		if req.Project != nil {
+			templates = req.Project.GitTemplates.Data
+		}
+		if branch, err := codegen.RenderBranch(templates, codegen.NewNamingVars(req, 0)); err == nil {
+			data["branch"] = branch
+		}
+	}
+
 	latestReview := h.reqService.GetLatestReview(id)
 	if latestReview != nil {
 		data["latest_review"] = gin.H{
@@ -347,6 +369,7 @@ func (h *RequirementHandler) Update(c *gin.Context) {
 		Description  *string         `json:"description"`
 		DocLinks     *model.DocLinks `json:"doc_links"`
 		Priority     *string         `json:"priority"`
+		Type         *string         `json:"type" binding:"omitempty,oneof=feat fix refactor perf docs test chore"`
 		Deadline     *time.Time      `json:"deadline"`
 		AssigneeID   *uint           `json:"assignee_id"`
 		RepositoryID *uint           `json:"repository_id"`
@@ -369,6 +392,9 @@ func (h *RequirementHandler) Update(c *gin.Context) {
 	if body.Priority != nil {
 		updates["priority"] = *body.Priority
 	}
+	if body.Type != nil {
+		updates["type"] = *body.Type
+	}
 	if body.Deadline != nil {
 		updates["deadline"] = *body.Deadline
 	}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/codeMaster/backend/internal/service"
 	"github.com/gin-gonic/gin"
 )
 
@@ -79,12 +80,5 @@ func parsePage(c *gin.Context) (int, int) {
 }
 
 func parseErrorCode(err error) (int, string) {
-	msg := err.Error()
-	if len(msg) > 5 && msg[5] == ':' {
-		code, e := strconv.Atoi(msg[:5])
-		if e == nil {
-			return code, msg[6:]
-		}
-	}
-	return 50001, msg
+	return service.ErrorCode(err)
 }
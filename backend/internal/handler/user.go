@@ -141,6 +141,30 @@ func (h *UserHandler) UpdateUserStatus(c *gin.Context) {
 	})
 }
 
+// PUT /admin/users/:id/budget
+func (h *UserHandler) UpdateUserBudget(c *gin.Context) {
+	id := parseID(c.Param("id"))
+	var req struct {
+		MonthlyBudgetUSD float64 `json:"monthly_budget_usd" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		BadRequest(c, 40001, "参数校验失败: "+err.Error())
+		return
+	}
+
+	user, err := h.authService.UpdateMonthlyBudget(id, req.MonthlyBudgetUSD)
+	if err != nil {
+		NotFound(c, 40401, "用户不存在")
+		return
+	}
+	Success(c, gin.H{
+		"id":                 user.ID,
+		"name":               user.Name,
+		"monthly_budget_usd": user.MonthlyBudgetUSD,
+		"updated_at":         user.UpdatedAt,
+	})
+}
+
 // GET /admin/operation-logs
 func (h *UserHandler) GetOperationLogs(c *gin.Context) {
 	page, pageSize := parsePage(c)
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// CodegenQueueItem is a persisted entry of the codegen work queue.
+// A row is inserted when a task is triggered, claimed by a pool worker when
+// execution starts, and deleted once the executor returns. Workers take
+// "queued" items in (Priority, SortKey) order and skip "paused" ones.
+type CodegenQueueItem struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	TaskID        uint       `gorm:"not null;uniqueIndex:uk_task_id" json:"task_id"`
+	RequirementID uint       `gorm:"not null;index:idx_requirement_id" json:"requirement_id"`
+	ProjectID     uint       `gorm:"not null;index:idx_project_id" json:"project_id"`
+	UserID        uint       `gorm:"index:idx_user_id" json:"user_id"`
+	Status        string     `gorm:"type:varchar(20);default:queued;index:idx_status" json:"status"` // queued / paused / claimed
+	Priority      int        `gorm:"not null;index:idx_priority_sort,priority:1" json:"priority"`    // rank of Requirement.Priority, 0 = p0
+	SortKey       int64      `gorm:"not null;default:0;index:idx_priority_sort,priority:2" json:"sort_key"`
+	Attempts      int        `gorm:"default:0" json:"attempts"`
+	EnqueuedAt    time.Time  `json:"enqueued_at"`
+	ClaimedAt     *time.Time `json:"claimed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	Task        *CodegenTask `gorm:"foreignKey:TaskID" json:"task,omitempty"`
+	Requirement *Requirement `gorm:"foreignKey:RequirementID" json:"requirement,omitempty"`
+	Project     *Project     `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
+	User        *User        `gorm:"-" json:"user,omitempty"`
+
+	Position        int `gorm:"-" json:"position,omitempty"`         // 1-based place among all waiting items
+	ProjectPosition int `gorm:"-" json:"project_position,omitempty"` // 1-based place among the project's waiting items
+}
+
+func (CodegenQueueItem) TableName() string { return "codegen_queue" }
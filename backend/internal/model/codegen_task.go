@@ -3,6 +3,8 @@ package model
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -13,12 +15,29 @@ type DiffStat struct {
 	Files        []DiffFile `json:"files,omitempty"`
 }
 
+// Diff file statuses
+const (
+	DiffAdded       = "added"
+	DiffModified    = "modified"
+	DiffDeleted     = "deleted"
+	DiffRenamed     = "renamed"
+	DiffCopied      = "copied"
+	DiffTypeChanged = "type_changed" // e.g. a file replaced by a symlink
+)
+
 type DiffFile struct {
-	Path      string `json:"path"`
-	Status    string `json:"status"`
-	Additions int    `json:"additions"`
-	Deletions int    `json:"deletions"`
-	Diff      string `json:"diff,omitempty"`
+	Path       string `json:"path"` // new path; the removed path for deleted files
+	Status     string `json:"status"`
+	OldPath    string `json:"old_path,omitempty"`   // renamed / copied from
+	Similarity int    `json:"similarity,omitempty"` // percent, renamed / copied only
+	Binary     bool   `json:"binary,omitempty"`     // binary files have no line counts
+	OldMode    string `json:"old_mode,omitempty"`   // set when the file mode changed, e.g. 100644 → 100755
+	NewMode    string `json:"new_mode,omitempty"`
+	Additions  int    `json:"additions"`
+	Deletions  int    `json:"deletions"`
+	Diff       string `json:"diff,omitempty"`      // inline patch of tasks stored before diff blobs
+	BlobHash   string `json:"blob_hash,omitempty"` // patch stored as a DiffBlob
+	Hunks      int    `json:"hunks,omitempty"`
 }
 
 type JSONDiffStat struct {
@@ -53,26 +72,287 @@ func (j *JSONDiffStat) Scan(value interface{}) error {
 	return nil
 }
 
+// VerifyResult records the verify phase of a codegen task.
+type VerifyResult struct {
+	Passed      bool        `json:"passed"`
+	FixAttempts int         `json:"fix_attempts"`
+	Runs        []VerifyRun `json:"runs"`
+}
+
+// VerifyRun is one execution of a verify command.
+type VerifyRun struct {
+	Attempt    int    `json:"attempt"` // 0 = after generation, n = after the n-th fix iteration
+	Name       string `json:"name"`
+	Command    string `json:"command"`
+	Passed     bool   `json:"passed"`
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output,omitempty"` // tail of combined output, failures only
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// FailedSummary names the commands that failed in the last attempt.
+func (r *VerifyResult) FailedSummary() string {
+	var names []string
+	for _, run := range r.Runs {
+		if run.Attempt == r.FixAttempts && !run.Passed {
+			names = append(names, fmt.Sprintf("%s (exit %d)", run.Name, run.ExitCode))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+type JSONVerifyResult struct {
+	Data *VerifyResult
+}
+
+func (j JSONVerifyResult) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONVerifyResult) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result VerifyResult
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
+// Task modes
+const (
+	TaskModeDirect = ""     // generate code straight from the requirement
+	TaskModePlan   = "plan" // produce a plan first and code after it is approved
+)
+
+// Plan statuses of a plan-first task
+const (
+	PlanStatusPending  = "pending_approval"
+	PlanStatusApproved = "approved"
+	PlanStatusRejected = "rejected"
+)
+
+// ImplementationPlan is the plan produced by the read-only phase of a
+// plan-first task. Reviewers may edit it before approving.
+type ImplementationPlan struct {
+	Summary string     `json:"summary"`
+	Steps   []PlanStep `json:"steps"`
+	Risks   []string   `json:"risks,omitempty"`
+	Tests   []string   `json:"tests,omitempty"`
+}
+
+type PlanStep struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Files       []string `json:"files,omitempty"`
+}
+
+type JSONImplementationPlan struct {
+	Data *ImplementationPlan
+}
+
+func (j JSONImplementationPlan) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONImplementationPlan) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var plan ImplementationPlan
+	if err := json.Unmarshal(bytes, &plan); err != nil {
+		return err
+	}
+	j.Data = &plan
+	return nil
+}
+
+// DiffViolation is a diff policy rule broken by a task's changes.
+type DiffViolation struct {
+	Rule    string   `json:"rule"` // forbidden_path / max_files / max_lines / binary
+	Message string   `json:"message"`
+	Files   []string `json:"files,omitempty"`
+}
+
+type DiffViolations []DiffViolation
+
+func (d DiffViolations) Value() (driver.Value, error) {
+	if d == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(d)
+	return string(b), err
+}
+
+func (d *DiffViolations) Scan(value interface{}) error {
+	if value == nil {
+		*d = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	return json.Unmarshal(bytes, d)
+}
+
+// TaskCommit is one of the commits a task pushed, oldest first.
+type TaskCommit struct {
+	SHA     string   `json:"sha"`
+	Message string   `json:"message"`
+	Files   []string `json:"files,omitempty"`
+}
+
+type TaskCommits []TaskCommit
+
+func (c TaskCommits) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+func (c *TaskCommits) Scan(value interface{}) error {
+	if value == nil {
+		*c = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	return json.Unmarshal(bytes, c)
+}
+
+// BranchSyncResult records how the requirement branch was brought up to date
+// with the source branch before a task ran.
+type BranchSyncResult struct {
+	Strategy  string   `json:"strategy"`
+	Status    string   `json:"status"`               // up_to_date / updated / conflict / resolved
+	SourceSHA string   `json:"source_sha,omitempty"` // source branch head synced to
+	BranchSHA string   `json:"branch_sha,omitempty"` // requirement branch head before the sync
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// Branch sync outcomes
+const (
+	BranchSyncUpToDate = "up_to_date"
+	BranchSyncUpdated  = "updated"
+	BranchSyncConflict = "conflict" // not synced; conflicts reported
+	BranchSyncResolved = "resolved" // conflicts resolved by the agent
+)
+
+type JSONBranchSyncResult struct {
+	Data *BranchSyncResult
+}
+
+func (j JSONBranchSyncResult) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONBranchSyncResult) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result BranchSyncResult
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
+// Ways to roll back an iteration: reset the requirement branch to the
+// previous iteration, or push a commit reverting the iteration's changes.
+const (
+	RevertModeReset  = "reset"
+	RevertModeRevert = "revert"
+)
+
 type CodegenTask struct {
-	ID            uint         `gorm:"primaryKey" json:"id"`
-	RequirementID uint         `gorm:"not null;index:idx_requirement_id" json:"requirement_id"`
-	RepositoryID  uint         `gorm:"not null" json:"repository_id"`
-	SourceBranch  string       `gorm:"type:varchar(64);not null" json:"source_branch"`
-	TargetBranch  string       `gorm:"type:varchar(128);not null" json:"target_branch"`
-	Status        string       `gorm:"type:varchar(20);default:pending;index:idx_status" json:"status"`
-	ExtraContext  string       `gorm:"type:text" json:"extra_context,omitempty"`
-	Prompt        string       `gorm:"type:text" json:"prompt,omitempty"`
-	OutputLog     string       `gorm:"type:longtext" json:"-"`
-	DiffStat      JSONDiffStat `gorm:"type:json" json:"diff_stat,omitempty"`
-	CommitSHA     string       `gorm:"type:varchar(64)" json:"commit_sha,omitempty"`
-	ErrorMessage  string       `gorm:"type:text" json:"error_message,omitempty"`
-	SessionID     string       `gorm:"type:varchar(128)" json:"session_id,omitempty"`
-	ResumeTaskID  *uint        `gorm:"index" json:"resume_task_id,omitempty"`
-	ClaudeCostUSD float64      `gorm:"type:decimal(10,4)" json:"claude_cost_usd,omitempty"`
-	PID           int          `gorm:"-" json:"-"`
-	StartedAt     *time.Time   `json:"started_at"`
-	CompletedAt   *time.Time   `json:"completed_at"`
-	CreatedAt     time.Time    `json:"created_at"`
+	ID               uint                   `gorm:"primaryKey" json:"id"`
+	RequirementID    uint                   `gorm:"not null;index:idx_requirement_id" json:"requirement_id"`
+	RepositoryID     uint                   `gorm:"not null" json:"repository_id"`
+	UserID           uint                   `gorm:"index:idx_user_id" json:"user_id"` // user who triggered the task
+	SourceBranch     string                 `gorm:"type:varchar(64);not null" json:"source_branch"`
+	TargetBranch     string                 `gorm:"type:varchar(128);not null" json:"target_branch"`
+	Status           string                 `gorm:"type:varchar(20);default:pending;index:idx_status" json:"status"`
+	ExtraContext     string                 `gorm:"type:text" json:"extra_context,omitempty"`
+	Prompt           string                 `gorm:"type:text" json:"prompt,omitempty"`
+	OutputLog        string                 `gorm:"type:longtext" json:"-"`
+	DiffStat         JSONDiffStat           `gorm:"type:json" json:"diff_stat,omitempty"`
+	CommitSHA        string                 `gorm:"type:varchar(64)" json:"commit_sha,omitempty"`
+	BaseSHA          string                 `gorm:"type:varchar(64)" json:"base_sha,omitempty"` // branch head the task's commits are on top of
+	Commits          TaskCommits            `gorm:"type:json" json:"commits,omitempty"`         // every commit between BaseSHA and CommitSHA
+	ErrorMessage     string                 `gorm:"type:text" json:"error_message,omitempty"`
+	SessionID        string                 `gorm:"type:varchar(128)" json:"session_id,omitempty"`
+	WorkDir          string                 `gorm:"type:varchar(512)" json:"-"` // workspace the session was started in, for --resume
+	ResumeTaskID     *uint                  `gorm:"index" json:"resume_task_id,omitempty"`
+	ClaudeCostUSD    float64                `gorm:"type:decimal(10,4)" json:"claude_cost_usd,omitempty"`
+	BudgetUSD        float64                `gorm:"type:decimal(10,4)" json:"budget_usd,omitempty"`  // effective spend limit when the task started
+	VerifyStatus     string                 `gorm:"type:varchar(20)" json:"verify_status,omitempty"` // "" (not configured) / passed / failed
+	VerifyResult     JSONVerifyResult       `gorm:"type:json" json:"verify_result,omitempty"`
+	Mode             string                 `gorm:"type:varchar(20)" json:"mode,omitempty"` // "" (direct) / plan
+	Plan             JSONImplementationPlan `gorm:"type:json" json:"plan,omitempty"`
+	PlanStatus       string                 `gorm:"type:varchar(20)" json:"plan_status,omitempty"` // pending_approval / approved / rejected
+	PlanReviewedBy   *uint                  `json:"plan_reviewed_by,omitempty"`
+	PlanReviewedAt   *time.Time             `json:"plan_reviewed_at,omitempty"`
+	PlanComment      string                 `gorm:"type:text" json:"plan_comment,omitempty"`
+	PolicyViolations DiffViolations         `gorm:"type:json" json:"policy_violations,omitempty"`
+	BranchSync       JSONBranchSyncResult   `gorm:"type:json" json:"branch_sync,omitempty"`
+	RevertMode       string                 `gorm:"type:varchar(10)" json:"revert_mode,omitempty"` // reset / revert, set once the iteration is rolled back
+	RevertSHA        string                 `gorm:"type:varchar(64)" json:"revert_sha,omitempty"`  // branch head after the rollback
+	RevertedBy       *uint                  `json:"reverted_by,omitempty"`
+	RevertedAt       *time.Time             `json:"reverted_at,omitempty"`
+	PID              int                    `gorm:"-" json:"-"`
+	StartedAt        *time.Time             `json:"started_at"`
+	CompletedAt      *time.Time             `json:"completed_at"`
+	CreatedAt        time.Time              `json:"created_at"`
 
 	Requirement *Requirement `gorm:"foreignKey:RequirementID" json:"requirement,omitempty"`
 	Repository  *Repository  `gorm:"foreignKey:RepositoryID" json:"repository,omitempty"`
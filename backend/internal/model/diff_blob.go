@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// DiffBlob holds one file patch of a codegen diff, keyed by the SHA-256 of
+// its content. Task diffs only keep the hash, so identical patches (e.g.
+// across iterations of a requirement) are stored once.
+type DiffBlob struct {
+	Hash      string    `gorm:"type:char(64);primaryKey" json:"hash"`
+	Content   string    `gorm:"type:longtext" json:"-"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (DiffBlob) TableName() string { return "diff_blobs" }
@@ -39,6 +39,348 @@ func (d *DocLinks) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, d)
 }
 
+// SandboxPolicy overrides the global sandbox config for one project.
+// Unset (zero) fields inherit the global value.
+type SandboxPolicy struct {
+	Enabled        *bool    `json:"enabled,omitempty"`
+	Network        string   `json:"network,omitempty"` // allow / deny / verify
+	CPUs           float64  `json:"cpus,omitempty"`
+	MemoryMB       int      `json:"memory_mb,omitempty"`
+	PidsMax        int      `json:"pids_max,omitempty"`
+	TimeoutMinutes int      `json:"timeout_minutes,omitempty"` // overrides codegen.timeout_minutes
+	EnvPassthrough []string `json:"env_passthrough,omitempty"` // added to the global list
+}
+
+type JSONSandboxPolicy struct {
+	Data *SandboxPolicy
+}
+
+func (j JSONSandboxPolicy) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONSandboxPolicy) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result SandboxPolicy
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
+// ToolPolicy restricts the tools available to every agent invocation of one
+// project (code generation, planning, analysis and review).
+type ToolPolicy struct {
+	AllowedTools    []string             `json:"allowed_tools,omitempty"`    // replaces the default coding tools
+	DisallowedTools []string             `json:"disallowed_tools,omitempty"` // e.g. "WebFetch", "Bash(rm:*)"
+	BashCommands    []string             `json:"bash_commands,omitempty"`    // command prefixes Bash is limited to, e.g. "go test"
+	MCPServers      map[string]MCPServer `json:"mcp_servers,omitempty"`
+	Approval        *ApprovalPolicy      `json:"approval,omitempty"`
+}
+
+// ApprovalPolicy pauses the agent on risky tool calls of code generation
+// until an authorized user approves or denies them.
+type ApprovalPolicy struct {
+	Enabled        bool     `json:"enabled"`
+	Commands       []string `json:"commands,omitempty"`        // Bash command prefixes, e.g. "rm", "git push"; empty = defaults
+	Paths          []string `json:"paths,omitempty"`           // globs of files whose edits need approval, e.g. "deploy/**"; empty = defaults
+	TimeoutMinutes int      `json:"timeout_minutes,omitempty"` // unanswered requests are denied after this; 0 = 30
+}
+
+// MCPServer is an extra MCP server made available to the agent, in the
+// format of Claude Code's --mcp-config.
+type MCPServer struct {
+	Type    string            `json:"type,omitempty"` // stdio (default) / sse / http
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// ReadOnly marks servers whose tools have no side effects; only those
+	// are offered to planning, analysis and review runs.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+type JSONToolPolicy struct {
+	Data *ToolPolicy
+}
+
+func (j JSONToolPolicy) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONToolPolicy) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result ToolPolicy
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
+// DiffPolicy bounds the changes a codegen task may push. It is evaluated
+// after the commit and before the push.
+type DiffPolicy struct {
+	ForbiddenPaths []string `json:"forbidden_paths,omitempty"` // globs, e.g. "deploy/**", "*.pem"
+	MaxFiles       int      `json:"max_files,omitempty"`       // 0 = unlimited
+	MaxLines       int      `json:"max_lines,omitempty"`       // additions + deletions; 0 = unlimited
+	BlockBinary    bool     `json:"block_binary,omitempty"`
+	Action         string   `json:"action,omitempty"` // fail (default) / quarantine
+}
+
+// Diff policy actions
+const (
+	DiffPolicyFail       = "fail"       // the task fails and the commit is dropped
+	DiffPolicyQuarantine = "quarantine" // the commit is kept unpushed until an admin releases it
+)
+
+type JSONDiffPolicy struct {
+	Data *DiffPolicy
+}
+
+func (j JSONDiffPolicy) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONDiffPolicy) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result DiffPolicy
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
+// BranchSync keeps requirement branches up to date with their source branch:
+// before each iteration, and on demand.
+type BranchSync struct {
+	Strategy   string `json:"strategy,omitempty"`    // "" (off) / merge / rebase
+	OnConflict string `json:"on_conflict,omitempty"` // report (default) / agent
+}
+
+// Branch sync strategies and conflict handling
+const (
+	BranchSyncMerge  = "merge"  // merge the source branch into the requirement branch
+	BranchSyncRebase = "rebase" // rebase the requirement branch, force-pushed with lease
+
+	SyncConflictReport = "report" // leave the branch as is and record the conflicting files
+	SyncConflictAgent  = "agent"  // let the agent resolve the conflicts in a dedicated session
+)
+
+type JSONBranchSync struct {
+	Data *BranchSync
+}
+
+func (j JSONBranchSync) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONBranchSync) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result BranchSync
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
+// GitTemplates name what code generation pushes for a project. Variables
+// such as {req_id} and {slug} are filled in per requirement and task; empty
+// templates keep the built-in defaults.
+type GitTemplates struct {
+	Branch  string `json:"branch,omitempty"`   // e.g. feature/{req_id}-{slug}
+	Commit  string `json:"commit,omitempty"`   // commit message, may span lines
+	MRTitle string `json:"mr_title,omitempty"` // merge request title
+}
+
+type JSONGitTemplates struct {
+	Data *GitTemplates
+}
+
+func (j JSONGitTemplates) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONGitTemplates) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result GitTemplates
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
+// CommitPolicy decides how the changes of a codegen run are committed.
+type CommitPolicy struct {
+	Split            string `json:"split,omitempty"`             // "" = one commit / module / agent
+	GenerateMessages bool   `json:"generate_messages,omitempty"` // write messages from the diff instead of the commit template
+}
+
+// Commit split modes
+const (
+	CommitSplitModule = "module" // one commit per module of the repository analysis
+	CommitSplitAgent  = "agent"  // the agent groups the changes into logical commits
+)
+
+type JSONCommitPolicy struct {
+	Data *CommitPolicy
+}
+
+func (j JSONCommitPolicy) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONCommitPolicy) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result CommitPolicy
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
+// CommitIdentity decides whose name is on the commits CodeMaster pushes for
+// a project, and whether they are signed. The signing key itself is stored
+// encrypted in Project.SigningKey.
+type CommitIdentity struct {
+	Mode             string `json:"mode,omitempty"`               // bot (default) / author / co_author
+	SigningFormat    string `json:"signing_format,omitempty"`     // ssh / openpgp; "" = unsigned
+	SigningKeyID     string `json:"signing_key_id,omitempty"`     // SSH fingerprint or GPG key ID
+	SigningPublicKey string `json:"signing_public_key,omitempty"` // to register with the git platform
+}
+
+// Commit identity modes and signing formats
+const (
+	CommitAsBot      = "bot"       // the bot authors and commits
+	CommitAsAuthor   = "author"    // the triggering user authors, the bot commits
+	CommitAsCoAuthor = "co_author" // the bot commits, crediting the user with a Co-authored-by trailer
+
+	SigningSSH     = "ssh"
+	SigningOpenPGP = "openpgp"
+)
+
+type JSONCommitIdentity struct {
+	Data *CommitIdentity
+}
+
+func (j JSONCommitIdentity) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONCommitIdentity) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result CommitIdentity
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
 type Project struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
 	Name        string         `gorm:"type:varchar(128);not null" json:"name"`
@@ -46,6 +388,16 @@ type Project struct {
 	OwnerID     uint           `gorm:"not null;index:idx_owner_id" json:"owner_id"`
 	DocLinks    DocLinks       `gorm:"type:json" json:"doc_links"`
 	Status      string         `gorm:"type:varchar(10);default:active;index:idx_status" json:"status"`
+	MonthlyBudgetUSD float64   `gorm:"type:decimal(10,2)" json:"monthly_budget_usd"` // 0 = unlimited
+	TaskBudgetUSD    float64   `gorm:"type:decimal(10,2)" json:"task_budget_usd"`    // 0 = use global default
+	SandboxPolicy    JSONSandboxPolicy `gorm:"type:json" json:"sandbox_policy,omitempty"`
+	ToolPolicy       JSONToolPolicy    `gorm:"type:json" json:"tool_policy,omitempty"`
+	DiffPolicy       JSONDiffPolicy    `gorm:"type:json" json:"diff_policy,omitempty"`
+	BranchSync       JSONBranchSync    `gorm:"type:json" json:"branch_sync,omitempty"`
+	GitTemplates     JSONGitTemplates  `gorm:"type:json" json:"git_templates,omitempty"`
+	CommitPolicy     JSONCommitPolicy  `gorm:"type:json" json:"commit_policy,omitempty"`
+	CommitIdentity   JSONCommitIdentity `gorm:"type:json" json:"commit_identity,omitempty"`
+	SigningKey       string            `gorm:"type:text" json:"-"` // AES encrypted private key for commit signing
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
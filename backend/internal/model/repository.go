@@ -60,6 +60,52 @@ func (j *JSONAnalysisResult) Scan(value interface{}) error {
 	return nil
 }
 
+// VerifyConfig lists the commands run in the workspace after generation to
+// check the result (build, lint, test, ...). Commands run in order via sh -c.
+type VerifyConfig struct {
+	Commands           []VerifyCommand `json:"commands"`
+	MaxFixAttempts     *int            `json:"max_fix_attempts,omitempty"` // nil = codegen.verify.max_fix_attempts
+	AllowPushOnFailure bool            `json:"allow_push_on_failure"`      // push even when verification still fails
+}
+
+type VerifyCommand struct {
+	Name       string `json:"name"`
+	Command    string `json:"command"`
+	TimeoutSec int    `json:"timeout_sec,omitempty"`
+}
+
+type JSONVerifyConfig struct {
+	Data *VerifyConfig
+}
+
+func (j JSONVerifyConfig) Value() (driver.Value, error) {
+	if j.Data == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(j.Data)
+	return string(b), err
+}
+
+func (j *JSONVerifyConfig) Scan(value interface{}) error {
+	if value == nil {
+		j.Data = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	var result VerifyConfig
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	j.Data = &result
+	return nil
+}
+
 type Repository struct {
 	ID                uint               `gorm:"primaryKey" json:"id"`
 	ProjectID         uint               `gorm:"not null;index:idx_project_id" json:"project_id"`
@@ -73,6 +119,7 @@ type Repository struct {
 	AnalysisStatus    string             `gorm:"type:varchar(20);default:pending" json:"analysis_status"`
 	AnalysisError     string             `gorm:"type:text" json:"analysis_error,omitempty"`
 	AnalyzedAt        *time.Time         `json:"analyzed_at"`
+	VerifyConfig      JSONVerifyConfig   `gorm:"type:json" json:"verify_config,omitempty"`
 	CreatedAt         time.Time          `json:"created_at"`
 	UpdatedAt         time.Time          `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt     `gorm:"index" json:"-"`
@@ -14,6 +14,7 @@ type Requirement struct {
 	DocLinks     DocLinks       `gorm:"type:json" json:"doc_links"`
 	DocContent   string         `gorm:"type:longtext" json:"-"`
 	Priority     string         `gorm:"type:varchar(5);default:p1" json:"priority"`
+	Type         string         `gorm:"type:varchar(10);default:feat" json:"type"` // conventional commit type: feat / fix / refactor / perf / docs / test / chore
 	Status       string         `gorm:"type:varchar(20);default:draft;index:idx_status" json:"status"`
 	Deadline     *time.Time     `json:"deadline"`
 	CreatorID    uint           `gorm:"not null;index:idx_creator_id" json:"creator_id"`
@@ -16,6 +16,7 @@ type User struct {
 	Role           string         `gorm:"type:varchar(10);not null;default:rd;index:idx_role" json:"role"`
 	IsAdmin        bool           `gorm:"default:false" json:"is_admin"`
 	Status         int            `gorm:"default:1" json:"status"`
+	MonthlyBudgetUSD float64      `gorm:"type:decimal(10,2)" json:"monthly_budget_usd"` // 0 = use global default
 	LastLoginAt    *time.Time     `json:"last_login_at"`
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
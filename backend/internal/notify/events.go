@@ -1,5 +1,7 @@
 package notify
 
+import "time"
+
 // RequirementCreatedEvent is sent when a new requirement is created with an assignee.
 type RequirementCreatedEvent struct {
 	RequirementID   uint
@@ -68,3 +70,47 @@ type HumanReviewSubmittedEvent struct {
 	Status         string
 	Comment        string
 }
+
+// BudgetWarningEvent is sent when a codegen task approaches or exceeds its budget.
+type BudgetWarningEvent struct {
+	RequirementID  uint
+	Title          string
+	ProjectName    string
+	TaskID         uint
+	Scope          string // 单任务 / 项目月度 / 用户月度
+	SpentUSD       float64
+	LimitUSD       float64
+	Exceeded       bool
+	CreatorOpenID  string
+	AssigneeOpenID string
+	OwnerOpenID    string // project owner
+}
+
+// PlanReadyEvent is sent when a plan-first task has produced its plan and
+// waits for approval.
+type PlanReadyEvent struct {
+	RequirementID  uint
+	Title          string
+	ProjectName    string
+	TaskID         uint
+	CreatorOpenID  string
+	AssigneeOpenID string
+	Summary        string
+	Steps          int
+}
+
+// ApprovalRequiredEvent is sent when a codegen task pauses on a risky tool
+// call until someone approves or denies it.
+type ApprovalRequiredEvent struct {
+	RequirementID  uint
+	Title          string
+	ProjectName    string
+	TaskID         uint
+	ApprovalID     string
+	Tool           string
+	Detail         string // command or file path
+	Reason         string
+	ExpiresAt      time.Time
+	AssigneeOpenID string
+	OwnerOpenID    string // project owner
+}
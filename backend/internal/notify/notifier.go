@@ -17,6 +17,9 @@ type Notifier interface {
 	NotifyCodegenFailed(ctx context.Context, e CodegenFailedEvent) error
 	NotifyAIReviewCompleted(ctx context.Context, e AIReviewCompletedEvent) error
 	NotifyHumanReviewSubmitted(ctx context.Context, e HumanReviewSubmittedEvent) error
+	NotifyBudgetWarning(ctx context.Context, e BudgetWarningEvent) error
+	NotifyPlanReady(ctx context.Context, e PlanReadyEvent) error
+	NotifyApprovalRequired(ctx context.Context, e ApprovalRequiredEvent) error
 }
 
 // NoopNotifier is a no-op implementation used when bot is disabled.
@@ -28,6 +31,9 @@ func (NoopNotifier) NotifyCodegenCompleted(context.Context, CodegenCompletedEven
 func (NoopNotifier) NotifyCodegenFailed(context.Context, CodegenFailedEvent) error             { return nil }
 func (NoopNotifier) NotifyAIReviewCompleted(context.Context, AIReviewCompletedEvent) error     { return nil }
 func (NoopNotifier) NotifyHumanReviewSubmitted(context.Context, HumanReviewSubmittedEvent) error { return nil }
+func (NoopNotifier) NotifyBudgetWarning(context.Context, BudgetWarningEvent) error             { return nil }
+func (NoopNotifier) NotifyPlanReady(context.Context, PlanReadyEvent) error                     { return nil }
+func (NoopNotifier) NotifyApprovalRequired(context.Context, ApprovalRequiredEvent) error       { return nil }
 
 // FeishuNotifier sends interactive card notifications via Feishu bot.
 type FeishuNotifier struct {
@@ -158,6 +164,89 @@ func (n *FeishuNotifier) NotifyHumanReviewSubmitted(_ context.Context, e HumanRe
 	return firstErr
 }
 
+func (n *FeishuNotifier) NotifyBudgetWarning(_ context.Context, e BudgetWarningEvent) error {
+	color := "orange"
+	title := "⚠️ 代码生成花费预警"
+	if e.Exceeded {
+		color = "red"
+		title = "🛑 代码生成超出预算"
+	}
+
+	fields := []cardField{
+		{Key: "项目", Value: e.ProjectName},
+		{Key: "需求", Value: e.Title},
+		{Key: "任务ID", Value: fmt.Sprintf("%d", e.TaskID)},
+		{Key: "预算", Value: fmt.Sprintf("%s $%.2f", e.Scope, e.LimitUSD)},
+		{Key: "已花费", Value: fmt.Sprintf("$%.4f", e.SpentUSD)},
+	}
+	card := buildCard(color, title, fields, nil)
+
+	var firstErr error
+	for _, openID := range uniqueNonEmpty(e.CreatorOpenID, e.AssigneeOpenID, e.OwnerOpenID) {
+		if err := n.send(openID, card); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (n *FeishuNotifier) NotifyPlanReady(_ context.Context, e PlanReadyEvent) error {
+	fields := []cardField{
+		{Key: "项目", Value: e.ProjectName},
+		{Key: "需求", Value: e.Title},
+		{Key: "任务ID", Value: fmt.Sprintf("%d", e.TaskID)},
+		{Key: "方案", Value: fmt.Sprintf("%s (%d 个步骤)", truncate(e.Summary, 200), e.Steps)},
+	}
+	card := buildCard("blue", "📝 实现方案待评审", fields, nil)
+
+	var firstErr error
+	for _, openID := range uniqueNonEmpty(e.AssigneeOpenID, e.CreatorOpenID) {
+		if err := n.send(openID, card); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// NotifyApprovalRequired sends a card with approve / deny buttons; the bot
+// handles the button callbacks (action "tool_approval").
+func (n *FeishuNotifier) NotifyApprovalRequired(_ context.Context, e ApprovalRequiredEvent) error {
+	fields := []cardField{
+		{Key: "项目", Value: e.ProjectName},
+		{Key: "需求", Value: e.Title},
+		{Key: "任务ID", Value: fmt.Sprintf("%d", e.TaskID)},
+		{Key: "工具", Value: e.Tool},
+		{Key: "内容", Value: truncate(e.Detail, 300)},
+		{Key: "原因", Value: e.Reason},
+		{Key: "超时", Value: e.ExpiresAt.Format("2006-01-02 15:04") + " 前未处理将自动拒绝"},
+	}
+	button := func(text, kind, decision string) map[string]interface{} {
+		return map[string]interface{}{
+			"tag":  "button",
+			"text": map[string]interface{}{"tag": "plain_text", "content": text},
+			"type": kind,
+			"value": map[string]interface{}{
+				"action":      "tool_approval",
+				"task_id":     fmt.Sprintf("%d", e.TaskID),
+				"approval_id": e.ApprovalID,
+				"decision":    decision,
+			},
+		}
+	}
+	card := buildCard("orange", "🔐 工具调用待审批", fields, []map[string]interface{}{
+		button("批准", "primary", "approve"),
+		button("拒绝", "danger", "deny"),
+	})
+
+	var firstErr error
+	for _, openID := range uniqueNonEmpty(e.AssigneeOpenID, e.OwnerOpenID) {
+		if err := n.send(openID, card); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func (n *FeishuNotifier) send(openID string, card map[string]interface{}) error {
 	if err := n.botClient.SendInteractiveMessage(openID, card); err != nil {
 		log.Printf("[notify] send feishu message to %s failed: %v", openID, err)
@@ -4,24 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
 	"time"
 
+	"github.com/codeMaster/backend/internal/agent"
 	"github.com/codeMaster/backend/internal/model"
 	"github.com/codeMaster/backend/pkg/claude"
 	"gorm.io/gorm"
 )
 
 type AIReviewer struct {
-	db *gorm.DB
+	db      *gorm.DB
+	backend agent.Backend
 }
 
-func NewAIReviewer(db *gorm.DB) *AIReviewer {
-	return &AIReviewer{db: db}
+func NewAIReviewer(db *gorm.DB, backend agent.Backend) *AIReviewer {
+	return &AIReviewer{db: db, backend: backend}
 }
 
-func (r *AIReviewer) RunReview(ctx context.Context, review *model.CodeReview, workDir, diffContent, apiKey, baseURL, modelName string) error {
+func (r *AIReviewer) RunReview(ctx context.Context, review *model.CodeReview, workDir, diffContent, apiKey, baseURL, modelName string, tools *model.ToolPolicy) error {
 	r.db.Model(review).Update("ai_status", "running")
 
 	prompt := BuildReviewPrompt(diffContent)
@@ -29,28 +29,21 @@ func (r *AIReviewer) RunReview(ctx context.Context, review *model.CodeReview, wo
 	reviewCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	args := []string{
-		"-p", prompt,
-		"--output-format", "json",
-		"--allowedTools", "Read,Glob,Grep",
-	}
-	if modelName != "" {
-		args = append(args, "--model", modelName)
+	req := agent.Request{
+		Prompt:  prompt,
+		WorkDir: workDir,
+		Model:   modelName,
+		APIKey:  apiKey,
+		BaseURL: baseURL,
 	}
+	agent.ResolveTools(tools, true).Apply(&req)
 
-	cmd := exec.CommandContext(reviewCtx, "claude", args...)
-	cmd.Dir = workDir
-	cmd.Env = os.Environ()
-	if apiKey != "" {
-		cmd.Env = append(cmd.Env, "ANTHROPIC_API_KEY="+apiKey)
-	}
-	if baseURL != "" {
-		cmd.Env = append(cmd.Env, "ANTHROPIC_BASE_URL="+baseURL)
-	}
-
-	output, err := cmd.CombinedOutput()
+	res, err := agent.Run(reviewCtx, r.backend, "", req, nil)
 	if err != nil {
-		errDetail := string(output)
+		errDetail := ""
+		if res != nil {
+			errDetail = res.Stderr
+		}
 		if len(errDetail) > 500 {
 			errDetail = errDetail[:500]
 		}
@@ -58,8 +51,8 @@ func (r *AIReviewer) RunReview(ctx context.Context, review *model.CodeReview, wo
 		return fmt.Errorf("claude review: %s: %w", errDetail, err)
 	}
 
-	// Extract JSON from Claude CLI output (handles envelope + markdown fences)
-	rawJSON := claude.ExtractJSON(output)
+	// Extract JSON from the final result text (handles markdown fences)
+	rawJSON := claude.ExtractJSON([]byte(res.Text))
 
 	var result model.AIReviewResult
 	if err := json.Unmarshal(rawJSON, &result); err != nil {
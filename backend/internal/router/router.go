@@ -61,6 +61,7 @@ func Setup(r *gin.Engine, deps Deps) {
 			admin.PUT("/users/:id/role", deps.UserHandler.UpdateUserRole)
 			admin.PUT("/users/:id/admin", deps.UserHandler.ToggleUserAdmin)
 			admin.PUT("/users/:id/status", deps.UserHandler.UpdateUserStatus)
+			admin.PUT("/users/:id/budget", deps.UserHandler.UpdateUserBudget)
 			admin.GET("/operation-logs", deps.UserHandler.GetOperationLogs)
 		}
 
@@ -72,6 +73,8 @@ func Setup(r *gin.Engine, deps Deps) {
 			projects.GET("/:id", deps.ProjectHandler.GetDetail)
 			projects.PUT("/:id", deps.ProjectHandler.Update)
 			projects.PUT("/:id/archive", deps.ProjectHandler.Archive)
+			projects.PUT("/:id/signing-key", deps.ProjectHandler.SetSigningKey)
+			projects.DELETE("/:id/signing-key", deps.ProjectHandler.ClearSigningKey)
 			projects.POST("/:id/members", deps.ProjectHandler.AddMembers)
 			projects.DELETE("/:id/members/:user_id", deps.ProjectHandler.RemoveMember)
 
@@ -110,6 +113,7 @@ func Setup(r *gin.Engine, deps Deps) {
 			// Code generation
 			requirements.POST("/:id/generate", deps.CodegenHandler.Generate)
 			requirements.POST("/:id/manual-submit", deps.CodegenHandler.ManualSubmit)
+			requirements.POST("/:id/sync-branch", deps.CodegenHandler.SyncBranch)
 			requirements.GET("/:id/codegen-tasks", deps.CodegenHandler.ListTasks)
 			requirements.GET("/:id/sessions", deps.CodegenHandler.ListSessions)
 		}
@@ -117,11 +121,31 @@ func Setup(r *gin.Engine, deps Deps) {
 		// CodeGen tasks
 		codegen := authed.Group("/codegen")
 		{
+			// Queue management
+			codegen.GET("/queue", deps.CodegenHandler.ListQueue)
+			codegen.PUT("/queue/:id/position", deps.CodegenHandler.MoveQueueItem)
+			codegen.POST("/queue/:id/pause", deps.CodegenHandler.PauseQueueItem)
+			codegen.POST("/queue/:id/resume", deps.CodegenHandler.ResumeQueueItem)
+			codegen.DELETE("/queue/:id", deps.CodegenHandler.RemoveQueueItem)
+
 			codegen.GET("/:id", deps.CodegenHandler.GetTask)
 			codegen.GET("/:id/stream", deps.CodegenHandler.Stream)
 			codegen.GET("/:id/diff", deps.CodegenHandler.GetDiff)
+			codegen.GET("/:id/diff/file", deps.CodegenHandler.GetDiffFile)
+			codegen.GET("/:id/compare", deps.CodegenHandler.Compare)
+			codegen.GET("/:id/compare/file", deps.CodegenHandler.CompareFile)
+			codegen.GET("/:id/patch", deps.CodegenHandler.Patch)
+			codegen.GET("/:id/bundle", deps.CodegenHandler.Bundle)
 			codegen.GET("/:id/log", deps.CodegenHandler.GetLog)
 			codegen.POST("/:id/cancel", deps.CodegenHandler.Cancel)
+			codegen.POST("/:id/input", deps.CodegenHandler.SendInput)
+			codegen.POST("/:id/plan/approve", deps.CodegenHandler.ApprovePlan)
+			codegen.POST("/:id/plan/reject", deps.CodegenHandler.RejectPlan)
+			codegen.GET("/:id/approvals", deps.CodegenHandler.ListApprovals)
+			codegen.POST("/:id/approvals/:approval_id/approve", deps.CodegenHandler.ApproveTool)
+			codegen.POST("/:id/approvals/:approval_id/deny", deps.CodegenHandler.DenyTool)
+			codegen.POST("/:id/release", deps.CodegenHandler.Release)
+			codegen.POST("/:id/revert", deps.CodegenHandler.Revert)
 
 			// Review under codegen
 			codegen.POST("/:id/review", deps.ReviewHandler.TriggerAIReview)
@@ -0,0 +1,92 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// cgroup is a per-process cgroup v2 leaf under Policy.CgroupRoot. The process
+// is started directly inside it via clone3(CLONE_INTO_CGROUP), so no child can
+// escape the limits before being moved.
+type cgroup struct {
+	dir string
+	f   *os.File
+}
+
+func newCgroup(p *Policy, name string) (*cgroup, error) {
+	// Enable the controllers for children of the root; fails harmlessly if already on
+	os.WriteFile(filepath.Join(p.CgroupRoot, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0)
+
+	dir := filepath.Join(p.CgroupRoot, name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cg := &cgroup{dir: dir}
+
+	limits := map[string]string{}
+	if p.CPUs > 0 {
+		limits["cpu.max"] = fmt.Sprintf("%d 100000", int(p.CPUs*100000))
+	}
+	if p.MemoryMB > 0 {
+		limits["memory.max"] = strconv.FormatInt(int64(p.MemoryMB)*1024*1024, 10)
+		limits["memory.swap.max"] = "0"
+	}
+	if p.PidsMax > 0 {
+		limits["pids.max"] = strconv.Itoa(p.PidsMax)
+	}
+	for file, value := range limits {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0); err != nil && file != "memory.swap.max" {
+			cg.remove()
+			return nil, fmt.Errorf("write %s: %w", file, err)
+		}
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		cg.remove()
+		return nil, err
+	}
+	cg.f = f
+	return cg, nil
+}
+
+func (c *cgroup) apply(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		UseCgroupFD: true,
+		CgroupFD:    int(c.f.Fd()),
+	}
+}
+
+// cgroupRemoveTimeout bounds how long remove waits for killed processes to
+// leave the cgroup.
+const cgroupRemoveTimeout = 5 * time.Second
+
+// remove kills anything left in the cgroup and deletes it. cgroup.kill is
+// asynchronous and a populated cgroup can't be removed (EBUSY), so the rmdir
+// is retried with backoff until the processes are gone.
+func (c *cgroup) remove() {
+	if c.f != nil {
+		c.f.Close()
+	}
+	os.WriteFile(filepath.Join(c.dir, "cgroup.kill"), []byte("1"), 0)
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for delay := 5 * time.Millisecond; ; delay *= 2 {
+		err := os.Remove(c.dir)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("[sandbox] remove cgroup %s: %v", c.dir, err)
+			return
+		}
+		time.Sleep(min(delay, 200*time.Millisecond))
+	}
+}
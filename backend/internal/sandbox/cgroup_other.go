@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type cgroup struct{}
+
+func newCgroup(*Policy, string) (*cgroup, error) {
+	return nil, errors.New("cgroup limits require Linux")
+}
+
+func (c *cgroup) apply(*exec.Cmd) {}
+
+func (c *cgroup) remove() {}
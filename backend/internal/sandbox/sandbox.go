@@ -0,0 +1,262 @@
+// Package sandbox confines agent and verify processes on Linux: bubblewrap
+// namespaces restrict the filesystem view to the workspace (plus read-only
+// system paths) and optionally cut network access, cgroup v2 limits cap CPU,
+// memory and process count, and the environment is reduced to an allowlist so
+// server secrets never reach the child.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/codeMaster/backend/internal/config"
+	"github.com/codeMaster/backend/internal/model"
+)
+
+// Process kinds, used to apply the network policy.
+const (
+	KindAgent  = "agent"
+	KindVerify = "verify"
+)
+
+// Network policies.
+const (
+	NetworkAllow  = "allow"  // no restriction
+	NetworkDeny   = "deny"   // no network for any sandboxed process
+	NetworkVerify = "verify" // verify commands run offline; the agent keeps access to the model API
+)
+
+// defaultEnv is passed through to sandboxed processes; everything else in the
+// server environment is dropped unless listed in Policy.EnvPassthrough.
+var defaultEnv = []string{"PATH", "LANG", "LC_ALL", "LC_CTYPE", "TERM", "TZ", "USER", "LOGNAME", "SHELL", "HOME"}
+
+// DefaultReadOnlyPaths are bound read-only into the sandbox when the policy lists none.
+var DefaultReadOnlyPaths = []string{"/usr", "/bin", "/sbin", "/lib", "/lib64", "/etc", "/opt"}
+
+// Policy is the effective sandbox configuration of one task.
+type Policy struct {
+	Enabled        bool
+	Bwrap          string // bubblewrap binary
+	Network        string // allow / deny / verify
+	CPUs           float64
+	MemoryMB       int
+	PidsMax        int
+	CgroupRoot     string // delegated cgroup v2 directory; empty disables resource limits
+	ReadOnlyPaths  []string
+	SharedPaths    []string // read-only in addition to ReadOnlyPaths, e.g. the git mirror cache
+	WritablePaths  []string // shared read-write paths, e.g. build caches
+	EnvPassthrough []string // extra environment variable names to keep
+}
+
+// Spec describes a process to run inside the sandbox.
+type Spec struct {
+	Kind     string
+	Name     string
+	Args     []string
+	Dir      string   // working directory, bound read-write
+	Env      []string // already filtered through Env
+	Writable []string // additional read-write paths (e.g. the agent's HOME)
+}
+
+func (p *Policy) active() bool {
+	return p != nil && p.Enabled
+}
+
+func (p *Policy) denyNetwork(kind string) bool {
+	return p.Network == NetworkDeny || (p.Network == NetworkVerify && kind == KindVerify)
+}
+
+// Summary describes the policy for startup logs.
+func (p *Policy) Summary() map[string]interface{} {
+	if !p.active() {
+		return map[string]interface{}{"enabled": false}
+	}
+	return map[string]interface{}{
+		"enabled":   true,
+		"network":   p.Network,
+		"cpus":      p.CPUs,
+		"memory_mb": p.MemoryMB,
+		"pids_max":  p.PidsMax,
+	}
+}
+
+// Env reduces environ to the allowlisted variables when the policy is active.
+func Env(p *Policy, environ []string) []string {
+	if !p.active() {
+		return environ
+	}
+	keep := make(map[string]bool)
+	for _, k := range defaultEnv {
+		keep[k] = true
+	}
+	for _, k := range p.EnvPassthrough {
+		keep[k] = true
+	}
+	env := make([]string, 0, len(keep))
+	for _, kv := range environ {
+		if k, _, ok := strings.Cut(kv, "="); ok && keep[k] {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+var seq atomic.Int64
+
+// Command builds the exec.Cmd for spec. With a nil or disabled policy the
+// process runs unconfined. cleanup must be called once the process has exited.
+func Command(ctx context.Context, p *Policy, spec Spec) (cmd *exec.Cmd, cleanup func(), err error) {
+	if !p.active() {
+		cmd = exec.CommandContext(ctx, spec.Name, spec.Args...)
+		cmd.Dir = spec.Dir
+		cmd.Env = spec.Env
+		return cmd, func() {}, nil
+	}
+
+	bwrap := p.Bwrap
+	if bwrap == "" {
+		bwrap = "bwrap"
+	}
+	args := []string{
+		"--die-with-parent",
+		"--new-session",
+		"--unshare-pid",
+		"--unshare-ipc",
+		"--unshare-uts",
+		"--unshare-cgroup-try",
+	}
+	if p.denyNetwork(spec.Kind) {
+		args = append(args, "--unshare-net")
+	}
+
+	roPaths := append([]string{}, p.ReadOnlyPaths...)
+	if len(roPaths) == 0 {
+		roPaths = append(roPaths, DefaultReadOnlyPaths...)
+	}
+	roPaths = append(roPaths, p.SharedPaths...)
+	// Make sure the binary itself is visible, wherever it is installed
+	if bin, err := exec.LookPath(spec.Name); err == nil {
+		if resolved, err := filepath.EvalSymlinks(bin); err == nil {
+			for _, dir := range []string{filepath.Dir(bin), filepath.Dir(resolved)} {
+				if !under(dir, roPaths) {
+					roPaths = append(roPaths, dir)
+				}
+			}
+		}
+	}
+	for _, ro := range roPaths {
+		args = append(args, "--ro-bind-try", ro, ro)
+	}
+	args = append(args, "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp")
+
+	writable := append([]string{spec.Dir}, spec.Writable...)
+	writable = append(writable, p.WritablePaths...)
+	for _, w := range writable {
+		if w == "" {
+			continue
+		}
+		if err := os.MkdirAll(w, 0o755); err != nil {
+			return nil, nil, fmt.Errorf("sandbox: prepare %s: %w", w, err)
+		}
+		args = append(args, "--bind", w, w)
+	}
+	// A HOME outside the bound paths gets a throwaway tmpfs so tools can still write config
+	if home := lookupEnv(spec.Env, "HOME"); home != "" && !under(home, writable) {
+		args = append(args, "--tmpfs", home)
+	}
+
+	args = append(args, "--chdir", spec.Dir, "--", spec.Name)
+	args = append(args, spec.Args...)
+
+	cmd = exec.CommandContext(ctx, bwrap, args...)
+	cmd.Dir = spec.Dir
+	cmd.Env = spec.Env
+	// bwrap's children may keep pipes open briefly after it is killed
+	cmd.WaitDelay = 5 * time.Second
+
+	cleanup = func() {}
+	if p.CgroupRoot != "" && (p.CPUs > 0 || p.MemoryMB > 0 || p.PidsMax > 0) {
+		name := fmt.Sprintf("%s-%d-%d", spec.Kind, os.Getpid(), seq.Add(1))
+		cg, err := newCgroup(p, name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sandbox: cgroup: %w", err)
+		}
+		cg.apply(cmd)
+		cleanup = cg.remove
+	}
+	return cmd, cleanup, nil
+}
+
+func lookupEnv(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(env[i], "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+func under(path string, roots []string) bool {
+	for _, r := range roots {
+		if r == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(r, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve merges a project's override into the global sandbox config.
+func Resolve(cfg config.SandboxConfig, override *model.SandboxPolicy) *Policy {
+	p := &Policy{
+		Enabled:        cfg.Enabled,
+		Bwrap:          cfg.Bwrap,
+		Network:        cfg.Network,
+		CPUs:           cfg.CPUs,
+		MemoryMB:       cfg.MemoryMB,
+		PidsMax:        cfg.PidsMax,
+		CgroupRoot:     cfg.CgroupRoot,
+		ReadOnlyPaths:  cfg.ReadOnlyPaths,
+		WritablePaths:  cfg.WritablePaths,
+		EnvPassthrough: cfg.EnvPassthrough,
+	}
+	if o := override; o != nil {
+		if o.Enabled != nil {
+			p.Enabled = *o.Enabled
+		}
+		if o.Network != "" {
+			p.Network = o.Network
+		}
+		if o.CPUs > 0 {
+			p.CPUs = o.CPUs
+		}
+		if o.MemoryMB > 0 {
+			p.MemoryMB = o.MemoryMB
+		}
+		if o.PidsMax > 0 {
+			p.PidsMax = o.PidsMax
+		}
+		p.EnvPassthrough = append(append([]string{}, p.EnvPassthrough...), o.EnvPassthrough...)
+	}
+	if p.Network == "" {
+		p.Network = NetworkAllow
+	}
+	return p
+}
+
+// ValidNetwork reports whether s is a known network policy ("" inherits).
+func ValidNetwork(s string) bool {
+	switch s {
+	case "", NetworkAllow, NetworkDeny, NetworkVerify:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+// Package secrets detects credentials in text: well-known token formats,
+// private keys, cloud credentials, URLs with embedded passwords and
+// high-entropy values assigned to secret-looking names. It is used to redact
+// agent output before it is stored or streamed and to keep generated commits
+// that add secrets from being pushed.
+package secrets
+
+import (
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mask replaces every detected secret.
+const Mask = "[REDACTED]"
+
+// minValueLen keeps very short injected values from masking ordinary text.
+const minValueLen = 8
+
+type rule struct {
+	name    string
+	re      *regexp.Regexp
+	group   int  // first submatch that may hold the secret; 0 = whole match
+	entropy bool // the secret must look random
+}
+
+// A private key is only reported with key material after its header, so code
+// that merely mentions the header, e.g. a PEM parser, is left alone. The
+// match ends with the base64 body or the END line; line breaks may be written
+// as "\n" escapes, as in a string constant.
+const (
+	privateKeyBegin = `-----BEGIN[A-Z ]*PRIVATE KEY( BLOCK)?-----`
+	privateKeyEnd   = `-----END[A-Z ]*PRIVATE KEY( BLOCK)?-----`
+	pemBreak        = `[ \t]*(?:\r?\n|\\n)[ \t]*`
+)
+
+var (
+	privateKeyHeader = regexp.MustCompile(privateKeyBegin)
+	// pemBodyLine is a line of key material, or a header of an encrypted key
+	pemBodyLine = regexp.MustCompile(`^\s*(?:[A-Za-z0-9+/=]{40,}|Proc-Type: .*|DEK-Info: .*)\s*$`)
+)
+
+var rules = []rule{
+	{name: "private_key", re: regexp.MustCompile(privateKeyBegin +
+		`(?:` + pemBreak + `[A-Za-z-]+: [^\n\\]*)*` +
+		pemBreak + `[A-Za-z0-9+/=]{40,}` +
+		`(?:` + pemBreak + `[A-Za-z0-9+/=]{16,})*` +
+		`(?:` + pemBreak + `(?:[A-Za-z0-9+/=]{1,15}` + pemBreak + `)?` + privateKeyEnd + `)?`)},
+	{name: "aws_access_key", re: regexp.MustCompile(`\b(AKIA|ASIA|AGPA|AIDA|AROA|ANPA)[0-9A-Z]{16}\b`)},
+	{name: "aws_secret_key", re: regexp.MustCompile(`(?i)aws.{0,20}?(secret|private).{0,20}?['"]?\s*[:=]\s*['"]?([A-Za-z0-9/+=]{40})\b`), group: 2},
+	{name: "gcp_service_account", re: regexp.MustCompile(`"private_key_id"\s*:\s*"([a-f0-9]{40})"`), group: 1},
+	{name: "google_api_key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{name: "github_token", re: regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{name: "gitlab_token", re: regexp.MustCompile(`\bgl(pat|dt|rt|ptt|cbt)-[A-Za-z0-9_-]{20,}\b`)},
+	{name: "slack_token", re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{name: "llm_api_key", re: regexp.MustCompile(`\bsk-(ant-|proj-)?[A-Za-z0-9_-]{20,}\b`)},
+	{name: "stripe_key", re: regexp.MustCompile(`\b(sk|rk)_live_[A-Za-z0-9]{20,}\b`)},
+	{name: "jwt", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{name: "url_credentials", re: regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@'"]+:([^/\s@'"]{4,})@`), group: 1},
+	{
+		name:    "generic_secret",
+		re:      regexp.MustCompile(`(?i)(secret|token|passw(or)?d|pwd|api[_-]?key|access[_-]?key|auth[_-]?key|private[_-]?key|client[_-]?secret|credential)[A-Za-z0-9_-]*['"]?\s*[:=]\s*(?:"([^"\s]{8,})"|'([^'\s]{8,})'|([A-Za-z0-9/+=_.~-]{16,}))`),
+		group:   3,
+		entropy: true,
+	},
+}
+
+// highEntropy finds long quoted strings that look random. It is only used on
+// diffs: agent output is full of hashes and IDs.
+var highEntropy = regexp.MustCompile(`['"]([A-Za-z0-9/+=_-]{32,})['"]`)
+
+// Generated files full of checksums are exempt from the high-entropy check.
+var entropyExempt = []string{"go.sum", "*.lock", "package-lock.json", "pnpm-lock.yaml", "yarn.lock", "*.min.js", "*.svg", "*.map"}
+
+// Finding is a secret found in an added line of a diff. The secret itself is
+// not kept.
+type Finding struct {
+	Rule string `json:"rule"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+func (f Finding) String() string {
+	return f.File + ":" + strconv.Itoa(f.Line) + " (" + f.Rule + ")"
+}
+
+// Redactor masks detected secrets and a fixed set of known values, e.g. the
+// tokens injected into a task.
+type Redactor struct {
+	values []string
+}
+
+// NewRedactor returns a Redactor that also masks values. Empty and very short
+// values are ignored.
+func NewRedactor(values ...string) *Redactor {
+	r := &Redactor{}
+	for _, v := range values {
+		if len(v) >= minValueLen {
+			r.values = append(r.values, v)
+		}
+	}
+	// longest first, so a value containing another is masked whole
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+	return r
+}
+
+// String masks the secrets in s.
+func (r *Redactor) String(s string) string {
+	if r != nil {
+		for _, v := range r.values {
+			s = strings.ReplaceAll(s, v, Mask)
+		}
+	}
+	for _, rl := range rules {
+		s = replaceRule(rl, s)
+	}
+	return s
+}
+
+// Value masks the secrets in every string of a decoded JSON value
+// (map[string]interface{}, []interface{}, string). It reports whether
+// anything was masked.
+func (r *Redactor) Value(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case string:
+		masked := r.String(t)
+		return masked, masked != t
+	case map[string]interface{}:
+		changed := false
+		for k, item := range t {
+			if masked, ok := r.Value(item); ok {
+				t[k] = masked
+				changed = true
+			}
+		}
+		return t, changed
+	case []interface{}:
+		changed := false
+		for i, item := range t {
+			if masked, ok := r.Value(item); ok {
+				t[i] = masked
+				changed = true
+			}
+		}
+		return t, changed
+	}
+	return v, false
+}
+
+func replaceRule(rl rule, s string) string {
+	matches := rl.re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := span(m, rl.group)
+		if start < 0 || start < last {
+			continue
+		}
+		if rl.entropy && !looksRandom(s[start:end]) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(Mask)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// span returns the first matched submatch from group on.
+func span(m []int, group int) (int, int) {
+	for g := group; 2*g+1 < len(m); g++ {
+		if m[2*g] >= 0 {
+			return m[2*g], m[2*g+1]
+		}
+	}
+	return -1, -1
+}
+
+// ScanDiff reports the secrets in the added lines of a unified diff. A
+// private key header spanning lines is reported once key material follows.
+func ScanDiff(diff string) []Finding {
+	var findings []Finding
+	file, line, header := "", 0, false
+	keyLine := 0 // line of a private key header awaiting its body
+	for _, l := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(l, "diff --git "):
+			header = true
+			keyLine = 0
+		case header && strings.HasPrefix(l, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(l, "+++ "), "b/")
+		case strings.HasPrefix(l, "@@"):
+			header = false
+			line = hunkStart(l)
+			keyLine = 0
+		case header:
+		case strings.HasPrefix(l, "+"):
+			text := l[1:]
+			if rl := scanLine(file, text); rl != "" {
+				findings = append(findings, Finding{Rule: rl, File: file, Line: line})
+				keyLine = 0
+			} else if keyLine > 0 && pemBodyLine.MatchString(text) {
+				if !strings.Contains(text, ":") {
+					findings = append(findings, Finding{Rule: "private_key", File: file, Line: keyLine})
+					keyLine = 0
+				}
+			} else if privateKeyHeader.MatchString(text) {
+				keyLine = line
+			} else {
+				keyLine = 0
+			}
+			line++
+		case strings.HasPrefix(l, " "):
+			line++
+			keyLine = 0
+		}
+	}
+	return findings
+}
+
+// scanLine returns the first rule matching an added line.
+func scanLine(file, text string) string {
+	for _, rl := range rules {
+		for _, m := range rl.re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := span(m, rl.group)
+			if start >= 0 && (!rl.entropy || looksRandom(text[start:end])) {
+				return rl.name
+			}
+		}
+	}
+	for _, g := range entropyExempt {
+		if ok, _ := path.Match(g, path.Base(file)); ok {
+			return ""
+		}
+	}
+	for _, m := range highEntropy.FindAllStringSubmatch(text, -1) {
+		if looksRandom(m[1]) && entropy(m[1]) >= 4.5 {
+			return "high_entropy"
+		}
+	}
+	return ""
+}
+
+// hunkStart parses the new-file start line of "@@ -a,b +c,d @@".
+func hunkStart(header string) int {
+	i := strings.Index(header, " +")
+	if i < 0 {
+		return 0
+	}
+	rest := header[i+2:]
+	if j := strings.IndexAny(rest, ", "); j >= 0 {
+		rest = rest[:j]
+	}
+	n, _ := strconv.Atoi(rest)
+	return n
+}
+
+// looksRandom filters out placeholders and identifiers: the value must mix
+// letters and digits and have enough entropy.
+func looksRandom(s string) bool {
+	var lower, upper, digit bool
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+	return digit && (lower || upper) && entropy(s) >= 3.5
+}
+
+// entropy is the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	n := float64(len(s))
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -161,6 +161,18 @@ func (s *AuthService) UpdateUserStatus(userID uint, status int) (*model.User, er
 	return &user, nil
 }
 
+func (s *AuthService) UpdateMonthlyBudget(userID uint, budgetUSD float64) (*model.User, error) {
+	var user model.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	user.MonthlyBudgetUSD = budgetUSD
+	if err := s.db.Save(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (s *AuthService) SearchUsers(keyword, role string, excludeProjectID *uint, limit int) ([]model.User, error) {
 	query := s.db.Model(&model.User{}).Where("status = 1")
 	if keyword != "" {
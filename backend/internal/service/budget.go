@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/codeMaster/backend/internal/codegen"
+	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/internal/notify"
+	"gorm.io/gorm"
+)
+
+// monthStart returns the beginning of the current calendar month (UTC, matching the DB session).
+func monthStart() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// projectMonthlySpend sums the Claude cost of this month's codegen tasks in a project,
+// including the live cost of running tasks.
+func projectMonthlySpend(db *gorm.DB, projectID, excludeTaskID uint) float64 {
+	var total float64
+	db.Model(&model.CodegenTask{}).
+		Joins("JOIN requirements ON requirements.id = codegen_tasks.requirement_id").
+		Where("requirements.project_id = ? AND codegen_tasks.created_at >= ? AND codegen_tasks.id <> ?", projectID, monthStart(), excludeTaskID).
+		Select("COALESCE(SUM(codegen_tasks.claude_cost_usd), 0)").
+		Scan(&total)
+	return total
+}
+
+// userMonthlySpend sums the Claude cost of this month's codegen tasks triggered by a user.
+func userMonthlySpend(db *gorm.DB, userID, excludeTaskID uint) float64 {
+	var total float64
+	db.Model(&model.CodegenTask{}).
+		Where("user_id = ? AND created_at >= ? AND id <> ?", userID, monthStart(), excludeTaskID).
+		Select("COALESCE(SUM(claude_cost_usd), 0)").
+		Scan(&total)
+	return total
+}
+
+func (s *CodegenService) userMonthlyBudget(userID uint) float64 {
+	var user model.User
+	if userID > 0 && s.db.Select("id", "monthly_budget_usd").First(&user, userID).Error == nil && user.MonthlyBudgetUSD > 0 {
+		return user.MonthlyBudgetUSD
+	}
+	return s.budget.UserMonthlyUSD
+}
+
+// CheckBudget rejects new generations once the project's or the user's monthly budget is used up.
+func (s *CodegenService) CheckBudget(projectID, userID uint) error {
+	var project model.Project
+	if err := s.db.First(&project, projectID).Error; err != nil {
+		return err
+	}
+	if project.MonthlyBudgetUSD > 0 {
+		if spent := projectMonthlySpend(s.db, projectID, 0); spent >= project.MonthlyBudgetUSD {
+			return fmt.Errorf("40004:项目本月预算已用完 ($%.2f / $%.2f)，请联系项目负责人调整预算", spent, project.MonthlyBudgetUSD)
+		}
+	}
+	if limit := s.userMonthlyBudget(userID); limit > 0 && userID > 0 {
+		if spent := userMonthlySpend(s.db, userID, 0); spent >= limit {
+			return fmt.Errorf("40004:个人本月预算已用完 ($%.2f / $%.2f)，请联系管理员调整预算", spent, limit)
+		}
+	}
+	return nil
+}
+
+// taskBudget resolves the effective spend limit for a task: the smallest of the
+// per-task limit and what is left of the project and user monthly budgets.
+// It returns an error when a monthly budget is already exhausted.
+func (s *CodegenService) taskBudget(task *model.CodegenTask, requirement *model.Requirement) (codegen.Budget, error) {
+	budget := codegen.Budget{
+		LimitUSD:  s.budget.TaskUSD,
+		Scope:     "task",
+		WarnRatio: s.budget.WarnRatio,
+	}
+
+	var project model.Project
+	if err := s.db.First(&project, requirement.ProjectID).Error; err != nil {
+		return budget, err
+	}
+	if project.TaskBudgetUSD > 0 {
+		budget.LimitUSD = project.TaskBudgetUSD
+	}
+
+	tighten := func(remaining float64, scope string) {
+		if budget.LimitUSD <= 0 || remaining < budget.LimitUSD {
+			budget.LimitUSD = remaining
+			budget.Scope = scope
+		}
+	}
+	if project.MonthlyBudgetUSD > 0 {
+		remaining := project.MonthlyBudgetUSD - projectMonthlySpend(s.db, project.ID, task.ID)
+		if remaining <= 0 {
+			return budget, fmt.Errorf("项目本月预算已用完 (上限 $%.2f)", project.MonthlyBudgetUSD)
+		}
+		tighten(remaining, "project")
+	}
+	if limit := s.userMonthlyBudget(task.UserID); limit > 0 && task.UserID > 0 {
+		remaining := limit - userMonthlySpend(s.db, task.UserID, task.ID)
+		if remaining <= 0 {
+			return budget, fmt.Errorf("个人本月预算已用完 (上限 $%.2f)", limit)
+		}
+		tighten(remaining, "user")
+	}
+
+	taskID, requirementID := task.ID, requirement.ID
+	budget.OnWarn = func(spent, limit float64, exceeded bool) {
+		s.notifyBudgetWarning(taskID, requirementID, budget.ScopeLabel(), spent, limit, exceeded)
+	}
+	return budget, nil
+}
+
+// notifyBudgetWarning tells the requirement's creator, assignee and project owner
+// that a task is close to or over its budget.
+func (s *CodegenService) notifyBudgetWarning(taskID, requirementID uint, scope string, spent, limit float64, exceeded bool) {
+	if s.notifier == nil {
+		return
+	}
+	var req model.Requirement
+	if err := s.db.Preload("Creator").Preload("Assignee").Preload("Project.Owner").First(&req, requirementID).Error; err != nil {
+		return
+	}
+	e := notify.BudgetWarningEvent{
+		RequirementID: req.ID,
+		Title:         req.Title,
+		TaskID:        taskID,
+		Scope:         scope,
+		SpentUSD:      spent,
+		LimitUSD:      limit,
+		Exceeded:      exceeded,
+	}
+	if req.Creator != nil {
+		e.CreatorOpenID = req.Creator.FeishuUID
+	}
+	if req.Assignee != nil {
+		e.AssigneeOpenID = req.Assignee.FeishuUID
+	}
+	if req.Project != nil {
+		e.ProjectName = req.Project.Name
+		if req.Project.Owner != nil {
+			e.OwnerOpenID = req.Project.Owner.FeishuUID
+		}
+	}
+	s.notifier.NotifyBudgetWarning(context.Background(), e)
+}
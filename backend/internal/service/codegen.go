@@ -1,25 +1,37 @@
 package service
 
 import (
+	"container/list"
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/codeMaster/backend/internal/agent"
 	"github.com/codeMaster/backend/internal/codegen"
+	"github.com/codeMaster/backend/internal/config"
+	"github.com/codeMaster/backend/internal/diffstore"
 	"github.com/codeMaster/backend/internal/gitops"
 	"github.com/codeMaster/backend/internal/model"
 	"github.com/codeMaster/backend/internal/notify"
+	"github.com/codeMaster/backend/internal/sandbox"
+	"github.com/codeMaster/backend/internal/secrets"
 	"github.com/codeMaster/backend/internal/sse"
 	"github.com/codeMaster/backend/pkg/encrypt"
 	"github.com/codeMaster/backend/pkg/feishu"
 	"gorm.io/gorm"
 )
 
+// maxQueueAttempts bounds how often a task interrupted before running is requeued on restart.
+const maxQueueAttempts = 3
+
 type CodegenService struct {
 	db     *gorm.DB
 	pool   *codegen.Pool
@@ -30,15 +42,22 @@ type CodegenService struct {
 	workDir     string
 	useLocalGit bool
 	sessionDir  string
+	backend     agent.Backend
+	budget      config.BudgetConfig
+	verify      config.VerifyConfig
+	sandbox     config.SandboxConfig
 
 	notifier  notify.Notifier
 	docClient     *feishu.DocClient
 
 	mu        sync.Mutex
 	executors map[uint]*codegen.Executor
+	branches  *codegen.BranchLocks
+
+	compares *compareCache
 }
 
-func NewCodegenService(db *gorm.DB, pool *codegen.Pool, hub *sse.Hub, aesKey string, maxTurns, timeoutMin int, workDir string, useLocalGit bool, sessionDir string) *CodegenService {
+func NewCodegenService(db *gorm.DB, pool *codegen.Pool, hub *sse.Hub, aesKey string, maxTurns, timeoutMin int, workDir string, useLocalGit bool, sessionDir string, backend agent.Backend) *CodegenService {
 	return &CodegenService{
 		db:          db,
 		pool:        pool,
@@ -49,7 +68,10 @@ func NewCodegenService(db *gorm.DB, pool *codegen.Pool, hub *sse.Hub, aesKey str
 		workDir:     workDir,
 		useLocalGit: useLocalGit,
 		sessionDir:  sessionDir,
+		backend:     backend,
 		executors:   make(map[uint]*codegen.Executor),
+		branches:    codegen.NewBranchLocks(),
+		compares:    newCompareCache(maxCachedCompares),
 	}
 }
 
@@ -58,36 +80,71 @@ func (s *CodegenService) SetNotifier(n notify.Notifier) {
 	s.notifier = n
 }
 
+// SetBudget sets the default spend limits applied to codegen tasks.
+func (s *CodegenService) SetBudget(b config.BudgetConfig) {
+	s.budget = b
+}
+
+// SetVerify sets the defaults for the post-generation verify phase.
+func (s *CodegenService) SetVerify(v config.VerifyConfig) {
+	s.verify = v
+}
+
+// SetSandbox sets the default sandbox policy; projects may override it.
+func (s *CodegenService) SetSandbox(sb config.SandboxConfig) {
+	s.sandbox = sb
+	if sb.Enabled {
+		bwrap := sb.Bwrap
+		if bwrap == "" {
+			bwrap = "bwrap"
+		}
+		if _, err := exec.LookPath(bwrap); err != nil {
+			log.Printf("Warning: sandbox enabled but %s not found: %v", bwrap, err)
+		}
+	}
+}
+
 // SetDocClient sets the Feishu doc client for fetching document content during codegen.
 func (s *CodegenService) SetDocClient(dc *feishu.DocClient) {
 	s.docClient = dc
 }
 
-func (s *CodegenService) TriggerGeneration(requirement *model.Requirement, repo *model.Repository, extraContext, sourceBranch string, userID uint, resumeTaskID *uint) (*model.CodegenTask, int, error) {
+// TriggerGeneration creates a codegen task and queues it. mode is
+// model.TaskModeDirect or model.TaskModePlan.
+func (s *CodegenService) TriggerGeneration(requirement *model.Requirement, repo *model.Repository, extraContext, sourceBranch string, userID uint, resumeTaskID *uint, mode string) (*model.CodegenTask, int, error) {
 	if sourceBranch == "" {
 		sourceBranch = repo.DefaultBranch
 	}
-	targetBranch := fmt.Sprintf("code-master/req-%d", requirement.ID)
+	targetBranch, err := s.requirementBranch(requirement, repo.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Fail now rather than after the agent ran
+	templates, vars := s.namingVars(requirement, 0)
+	if _, err := codegen.RenderCommitMessage(templates, vars); err != nil {
+		return nil, 0, fmt.Errorf("40002:%s", err.Error())
+	}
 
-	// Look up previous session for resume
-	var resumeSessionID string
+	if err := s.CheckBudget(requirement.ProjectID, userID); err != nil {
+		return nil, 0, err
+	}
 	if resumeTaskID != nil && *resumeTaskID > 0 {
-		var prevTask model.CodegenTask
-		if s.db.First(&prevTask, *resumeTaskID).Error == nil {
-			if prevTask.SessionID != "" && prevTask.RequirementID == requirement.ID {
-				resumeSessionID = prevTask.SessionID
-			}
+		var prev model.CodegenTask
+		if s.db.Select("id", "reverted_at").First(&prev, *resumeTaskID).Error == nil && prev.RevertedAt != nil {
+			return nil, 0, fmt.Errorf("40003:该迭代已回滚，无法从其会话继续")
 		}
 	}
 
 	task := &model.CodegenTask{
 		RequirementID: requirement.ID,
 		RepositoryID:  repo.ID,
+		UserID:        userID,
 		SourceBranch:  sourceBranch,
 		TargetBranch:  targetBranch,
 		ExtraContext:  extraContext,
 		Status:        "pending",
 		ResumeTaskID:  resumeTaskID,
+		Mode:          mode,
 	}
 	if err := s.db.Create(task).Error; err != nil {
 		return nil, 0, err
@@ -95,11 +152,328 @@ func (s *CodegenService) TriggerGeneration(requirement *model.Requirement, repo
 
 	s.db.Model(requirement).Update("status", "generating")
 
+	queuePos, err := s.submit(task, requirement)
+	if err != nil {
+		s.db.Model(task).Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": "任务入队失败: " + err.Error(),
+		})
+		s.db.Model(requirement).Update("status", "draft")
+		return nil, 0, err
+	}
+
+	return task, queuePos, nil
+}
+
+// requirementBranch names the branch a requirement's code goes to: the one
+// its earlier tasks used, so renaming the requirement or changing the
+// template never moves it, else the project's branch template rendered.
+func (s *CodegenService) requirementBranch(requirement *model.Requirement, repoID uint) (string, error) {
+	var last model.CodegenTask
+	if s.db.Select("id", "target_branch").Where("requirement_id = ? AND target_branch <> ''", requirement.ID).
+		Order("id desc").First(&last).Error == nil {
+		return last.TargetBranch, nil
+	}
+	templates, vars := s.namingVars(requirement, 0)
+	branch, err := codegen.RenderBranch(templates, vars)
+	if err != nil {
+		return "", fmt.Errorf("40002:%s", err.Error())
+	}
+	// Templates without {req_id} can name two requirements alike
+	var other model.CodegenTask
+	if s.db.Select("id", "requirement_id").Where("repository_id = ? AND target_branch = ? AND requirement_id <> ?", repoID, branch, requirement.ID).
+		First(&other).Error == nil {
+		return "", fmt.Errorf("40002:分支 %s 已被需求 #%d 使用，请调整分支名模板或需求标题", branch, other.RequirementID)
+	}
+	return branch, nil
+}
+
+// namingVars returns the project's git templates and the values to render
+// them with for requirement.
+func (s *CodegenService) namingVars(requirement *model.Requirement, taskID uint) (*model.GitTemplates, codegen.NamingVars) {
+	var project model.Project
+	s.db.Select("id", "git_templates").First(&project, requirement.ProjectID)
+	return project.GitTemplates.Data, codegen.NewNamingVars(s.loadAssignee(requirement), taskID)
+}
+
+// loadAssignee loads the assignee of requirement unless it already is.
+func (s *CodegenService) loadAssignee(requirement *model.Requirement) *model.Requirement {
+	if requirement.Assignee == nil && requirement.AssigneeID != nil {
+		var assignee model.User
+		if s.db.First(&assignee, *requirement.AssigneeID).Error == nil {
+			requirement.Assignee = &assignee
+		}
+	}
+	return requirement
+}
+
+func (s *CodegenService) submit(task *model.CodegenTask, requirement *model.Requirement) (int, error) {
+	return s.pool.Submit(&model.CodegenQueueItem{
+		TaskID:        task.ID,
+		RequirementID: requirement.ID,
+		ProjectID:     requirement.ProjectID,
+		UserID:        task.UserID,
+		Priority:      codegen.PriorityRank(requirement.Priority),
+	})
+}
+
+// ApprovePlan approves the plan of a plan-first task, optionally replacing it
+// with an edited version, and queues the coding phase. The coding phase
+// resumes the planning session in the same workspace.
+func (s *CodegenService) ApprovePlan(taskID, reviewerID uint, plan *model.ImplementationPlan, comment string) (*model.CodegenTask, int, error) {
+	task, err := s.GetTask(taskID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("40405:生成任务不存在")
+	}
+	if task.Status != "awaiting_approval" || task.PlanStatus != model.PlanStatusPending {
+		return nil, 0, fmt.Errorf("40003:任务不在待评审状态")
+	}
+	if err := s.CheckBudget(task.Requirement.ProjectID, task.UserID); err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":           "pending",
+		"plan_status":      model.PlanStatusApproved,
+		"plan_reviewed_by": reviewerID,
+		"plan_reviewed_at": &now,
+		"plan_comment":     comment,
+	}
+	if plan != nil {
+		updates["plan"] = model.JSONImplementationPlan{Data: plan}
+	}
+	// Guard against concurrent approve/reject
+	res := s.db.Model(&model.CodegenTask{}).
+		Where("id = ? AND status = ? AND plan_status = ?", taskID, "awaiting_approval", model.PlanStatusPending).
+		Updates(updates)
+	if res.Error != nil {
+		return nil, 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, 0, fmt.Errorf("40003:任务不在待评审状态")
+	}
+
+	s.hub.Broadcast(int64(task.ID), sse.Event{Type: "log", Data: map[string]interface{}{
+		"level":   "info",
+		"phase":   "plan",
+		"message": "实现方案已通过评审，进入编码阶段",
+		"detail":  map[string]interface{}{"edited": plan != nil, "reviewer_id": reviewerID},
+	}})
+
+	queuePos, err := s.submit(task, task.Requirement)
+	if err != nil {
+		s.db.Model(task).Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": "任务入队失败: " + err.Error(),
+		})
+		s.db.Model(task.Requirement).Update("status", "draft")
+		return nil, 0, err
+	}
+	task, _ = s.GetTask(taskID)
+	return task, queuePos, nil
+}
+
+// RejectPlan rejects the plan of a plan-first task and closes the task.
+func (s *CodegenService) RejectPlan(taskID, reviewerID uint, comment string) error {
+	now := time.Now()
+	res := s.db.Model(&model.CodegenTask{}).
+		Where("id = ? AND status = ? AND plan_status = ?", taskID, "awaiting_approval", model.PlanStatusPending).
+		Updates(map[string]interface{}{
+			"status":           "cancelled",
+			"plan_status":      model.PlanStatusRejected,
+			"plan_reviewed_by": reviewerID,
+			"plan_reviewed_at": &now,
+			"plan_comment":     comment,
+			"completed_at":     &now,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("40003:任务不在待评审状态")
+	}
+
+	var task model.CodegenTask
+	s.db.First(&task, taskID)
+	s.db.Model(&model.Requirement{}).Where("id = ? AND status = ?", task.RequirementID, "generating").Update("status", "draft")
+	s.hub.Broadcast(int64(task.ID), sse.Event{Type: "log", Data: map[string]interface{}{
+		"level":   "warn",
+		"phase":   "plan",
+		"message": "实现方案未通过评审，任务已关闭",
+		"detail":  map[string]interface{}{"comment": comment, "reviewer_id": reviewerID},
+	}})
+	s.hub.Broadcast(int64(task.ID), sse.Event{Type: "done", Data: map[string]interface{}{
+		"task_id": task.ID,
+		"status":  "cancelled",
+	}})
+	return nil
+}
+
+// Start reconciles tasks interrupted by the previous shutdown and then starts
+// the pool workers. It must be called once before serving requests.
+func (s *CodegenService) Start() {
+	s.recoverTasks()
+	s.pool.Start(s.runQueued)
+}
+
+// recoverTasks brings the persisted queue back in line with task state after a restart.
+// Claimed items whose task never reached "running" are requeued; tasks that were
+// running are failed, since the Claude process and its workspace state are gone.
+// Active tasks that have no queue entry at all are failed as well.
+func (s *CodegenService) recoverTasks() {
+	var claimed []model.CodegenQueueItem
+	s.db.Where("status = ?", "claimed").Find(&claimed)
+	for i := range claimed {
+		item := &claimed[i]
+		var task model.CodegenTask
+		if err := s.db.First(&task, item.TaskID).Error; err != nil {
+			s.db.Delete(item)
+			continue
+		}
+		switch task.Status {
+		case "pending", "cloning":
+			if item.Attempts >= maxQueueAttempts {
+				s.db.Delete(item)
+				s.failInterruptedTask(&task, fmt.Sprintf("服务重启导致任务中断，已重试 %d 次仍未完成", item.Attempts))
+				continue
+			}
+			s.db.Model(&task).Updates(map[string]interface{}{"status": "pending", "started_at": nil})
+			s.db.Model(item).Updates(map[string]interface{}{"status": "queued", "claimed_at": nil})
+			s.hub.Broadcast(int64(task.ID), sse.Event{Type: "log", Data: map[string]interface{}{
+				"level":   "warn",
+				"phase":   "queue",
+				"message": "服务重启，任务已重新排队",
+			}})
+			log.Printf("[codegen] requeued task %d interrupted in %s", task.ID, task.Status)
+		case "running":
+			s.db.Delete(item)
+			s.failInterruptedTask(&task, "服务重启导致 Claude Code 执行中断，请重新生成或基于该会话继续")
+		default:
+			s.db.Delete(item)
+		}
+	}
+
+	var orphans []model.CodegenTask
+	s.db.Where("status IN ? AND id NOT IN (?)", []string{"pending", "cloning", "running"},
+		s.db.Model(&model.CodegenQueueItem{}).Select("task_id")).Find(&orphans)
+	for i := range orphans {
+		s.failInterruptedTask(&orphans[i], "服务重启，任务不在执行队列中，已终止")
+	}
+
+	if n := len(claimed) + len(orphans); n > 0 {
+		log.Printf("[codegen] reconciled %d interrupted tasks, %d tasks queued", n, s.pool.QueueSize())
+	}
+}
+
+func (s *CodegenService) failInterruptedTask(task *model.CodegenTask, reason string) {
+	now := time.Now()
+	s.db.Model(task).Updates(map[string]interface{}{
+		"status":        "failed",
+		"error_message": reason,
+		"completed_at":  &now,
+	})
+	s.db.Model(&model.Requirement{}).Where("id = ? AND status = ?", task.RequirementID, "generating").Update("status", "draft")
+	s.hub.Broadcast(int64(task.ID), sse.Event{Type: "task_error", Data: map[string]interface{}{"message": reason}})
+	s.hub.Broadcast(int64(task.ID), sse.Event{Type: "done", Data: map[string]interface{}{"task_id": task.ID, "status": "failed"}})
+	log.Printf("[codegen] task %d failed on recovery: %s", task.ID, reason)
+}
+
+// runQueued is the pool handler: it rebuilds the executor for a claimed queue item and runs it.
+func (s *CodegenService) runQueued(item *model.CodegenQueueItem) {
+	var task model.CodegenTask
+	if err := s.db.First(&task, item.TaskID).Error; err != nil {
+		log.Printf("[codegen] queued task %d not found: %v", item.TaskID, err)
+		return
+	}
+	if task.Status != "pending" {
+		// Cancelled (or otherwise finalized) while waiting in the queue
+		return
+	}
+
+	var requirement model.Requirement
+	if err := s.db.First(&requirement, task.RequirementID).Error; err != nil {
+		s.failInterruptedTask(&task, "需求不存在: "+err.Error())
+		return
+	}
+
+	unlock, err := s.branches.TryLock(task.RequirementID, task.TargetBranch, task.ID)
+	if err != nil {
+		s.failInterruptedTask(&task, "同一需求分支已有任务在执行: "+err.Error())
+		return
+	}
+	defer unlock()
+	var repo model.Repository
+	if err := s.db.First(&repo, task.RepositoryID).Error; err != nil {
+		s.failInterruptedTask(&task, "仓库不存在: "+err.Error())
+		return
+	}
+
+	// Budgets may have been used up by other tasks while this one was queued
+	budget, err := s.taskBudget(&task, &requirement)
+	if err != nil {
+		s.failInterruptedTask(&task, err.Error())
+		s.notifyTaskResult(task.ID, requirement.ID, err)
+		return
+	}
+	if budget.LimitUSD > 0 {
+		s.db.Model(&task).Update("budget_usd", budget.LimitUSD)
+	}
+
+	// Sandbox policy and time limit: project override on top of the global config
+	var project model.Project
+	s.db.First(&project, requirement.ProjectID)
+	timeoutMin := s.timeoutMin
+	if o := project.SandboxPolicy.Data; o != nil && o.TimeoutMinutes > 0 {
+		timeoutMin = o.TimeoutMinutes
+	}
+
+	// The project templates may have changed since the task was queued
+	commitMsg, err := codegen.RenderCommitMessage(project.GitTemplates.Data, codegen.NewNamingVars(s.loadAssignee(&requirement), task.ID))
+	if err != nil {
+		s.failInterruptedTask(&task, err.Error())
+		s.notifyTaskResult(task.ID, requirement.ID, err)
+		return
+	}
+
+	identity, err := s.commitIdentity(project.ID, task.UserID)
+	if err != nil {
+		s.failInterruptedTask(&task, err.Error())
+		s.notifyTaskResult(task.ID, requirement.ID, err)
+		return
+	}
+
+	// Look up previous session for resume
+	homeDir := codegen.TaskHomeDir(s.sessionDir, task.ID)
+	var resumeSessionID, resumeWorkDir, resumeHomeDir string
+	if task.PlanStatus == model.PlanStatusApproved && task.SessionID != "" {
+		// Coding phase of a plan-first task: continue the planning session
+		resumeSessionID = task.SessionID
+		resumeWorkDir = task.WorkDir
+		resumeHomeDir = homeDir
+	} else if task.ResumeTaskID != nil && *task.ResumeTaskID > 0 {
+		var prevTask model.CodegenTask
+		if s.db.First(&prevTask, *task.ResumeTaskID).Error == nil {
+			if prevTask.SessionID != "" && prevTask.RequirementID == requirement.ID && prevTask.RevertedAt == nil {
+				resumeSessionID = prevTask.SessionID
+				resumeWorkDir = prevTask.WorkDir
+				if resumeWorkDir == "" {
+					resumeWorkDir = codegen.LegacyWorkDir(s.workDir, requirement.ID)
+				}
+				resumeHomeDir = codegen.TaskHomeDir(s.sessionDir, prevTask.ID)
+			}
+		}
+	}
+	if _, err := os.Stat(resumeHomeDir); resumeHomeDir != "" && err != nil {
+		// Recorded before tasks got their own HOME
+		resumeHomeDir = s.sessionDir
+	}
+
 	// Query user's LLM settings and git token
 	var apiKey, baseURL, modelName, gitToken string
-	if userID > 0 {
+	if task.UserID > 0 {
 		var setting model.UserSetting
-		if err := s.db.Where("user_id = ?", userID).First(&setting).Error; err == nil {
+		if err := s.db.Where("user_id = ?", task.UserID).First(&setting).Error; err == nil {
 			apiKey = setting.APIKey
 			baseURL = setting.BaseURL
 			modelName = setting.Model
@@ -107,101 +481,139 @@ func (s *CodegenService) TriggerGeneration(requirement *model.Requirement, repo
 		}
 	}
 
+	sb := sandbox.Resolve(s.sandbox, project.SandboxPolicy.Data)
+	if dir := gitops.MirrorRepoDir(repo.GitURL); dir != "" {
+		// the workspace borrows objects from this repository's mirror
+		sb.SharedPaths = append(sb.SharedPaths, dir)
+	}
+
 	executor := codegen.NewExecutor(codegen.ExecutorConfig{
 		DB:              s.db,
 		Hub:             s.hub,
 		AESKey:          s.aesKey,
 		MaxTurns:        s.maxTurns,
-		TimeoutMin:      s.timeoutMin,
+		TimeoutMin:      timeoutMin,
 		WorkDir:         s.workDir,
 		UseLocalGit:     s.useLocalGit,
-		SessionDir:      s.sessionDir,
+		SessionDir:      homeDir,
 		ResumeSessionID: resumeSessionID,
-		Task:            task,
-		Requirement:     requirement,
-		Repo:            repo,
-		ExtraContext:     extraContext,
+		ResumeWorkDir:   resumeWorkDir,
+		ResumeHomeDir:   resumeHomeDir,
+		Task:            &task,
+		Requirement:     &requirement,
+		Repo:            &repo,
+		ExtraContext:    task.ExtraContext,
 		DocClient:       s.docClient,
 		APIKey:          apiKey,
 		BaseURL:         baseURL,
 		ModelName:       modelName,
 		GitToken:        gitToken,
+		Backend:         s.backend,
+		Budget:          budget,
+
+		VerifyMaxFixAttempts: s.verify.MaxFixAttempts,
+		VerifyTimeout:        time.Duration(s.verify.CommandTimeoutSeconds) * time.Second,
+		Sandbox:              sb,
+		ToolPolicy:           project.ToolPolicy.Data,
+		DiffPolicy:           project.DiffPolicy.Data,
+		BranchSync:           project.BranchSync.Data,
+		CommitMessage:        commitMsg,
+		Identity:             identity,
+		CommitPolicy:         project.CommitPolicy.Data,
+		OnApprovalRequired: func(a codegen.ToolApproval) {
+			s.notifyApprovalRequired(task.ID, requirement.ID, a)
+		},
 	})
 
 	s.mu.Lock()
 	s.executors[task.ID] = executor
 	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.executors, task.ID)
+		s.mu.Unlock()
+	}()
 
-	queuePos := s.pool.Submit(func() {
-		defer func() {
-			s.mu.Lock()
-			delete(s.executors, task.ID)
-			s.mu.Unlock()
-		}()
-		err := executor.Run(context.Background())
-
-		// Send notifications
-		if s.notifier != nil {
-			var req model.Requirement
-			if dbErr := s.db.Preload("Creator").Preload("Assignee").Preload("Project").First(&req, requirement.ID).Error; dbErr == nil {
-				creatorOpenID := ""
-				if req.Creator != nil {
-					creatorOpenID = req.Creator.FeishuUID
-				}
-				assigneeOpenID := ""
-				if req.Assignee != nil {
-					assigneeOpenID = req.Assignee.FeishuUID
-				}
-				projectName := ""
-				if req.Project != nil {
-					projectName = req.Project.Name
-				}
-
-				var latestTask model.CodegenTask
-				if s.db.First(&latestTask, task.ID).Error == nil {
-					if err == nil && latestTask.Status == "completed" {
-						filesChanged, additions, deletions := 0, 0, 0
-						if latestTask.DiffStat.Data != nil {
-							filesChanged = latestTask.DiffStat.Data.FilesChanged
-							additions = latestTask.DiffStat.Data.Additions
-							deletions = latestTask.DiffStat.Data.Deletions
-						}
-						go s.notifier.NotifyCodegenCompleted(context.Background(), notify.CodegenCompletedEvent{
-							RequirementID: req.ID,
-							Title:         req.Title,
-							ProjectName:   projectName,
-							TaskID:        task.ID,
-							CreatorOpenID: creatorOpenID,
-							AssigneeOpenID: assigneeOpenID,
-							FilesChanged:  filesChanged,
-							Additions:     additions,
-							Deletions:     deletions,
-						})
-					} else if latestTask.Status == "failed" {
-						go s.notifier.NotifyCodegenFailed(context.Background(), notify.CodegenFailedEvent{
-							RequirementID:  req.ID,
-							Title:          req.Title,
-							ProjectName:    projectName,
-							TaskID:         task.ID,
-							CreatorOpenID:  creatorOpenID,
-							AssigneeOpenID: assigneeOpenID,
-							ErrorMessage:   latestTask.ErrorMessage,
-						})
-					}
-				}
-			}
-		}
+	err = executor.Run(context.Background())
+	s.notifyTaskResult(task.ID, requirement.ID, err)
+}
 
-	})
+// notifyTaskResult sends completion / failure notifications for a finished task.
+func (s *CodegenService) notifyTaskResult(taskID, requirementID uint, err error) {
+	if s.notifier == nil {
+		return
+	}
+	var req model.Requirement
+	if dbErr := s.db.Preload("Creator").Preload("Assignee").Preload("Project").First(&req, requirementID).Error; dbErr != nil {
+		return
+	}
+	creatorOpenID := ""
+	if req.Creator != nil {
+		creatorOpenID = req.Creator.FeishuUID
+	}
+	assigneeOpenID := ""
+	if req.Assignee != nil {
+		assigneeOpenID = req.Assignee.FeishuUID
+	}
+	projectName := ""
+	if req.Project != nil {
+		projectName = req.Project.Name
+	}
 
-	return task, queuePos, nil
+	var latestTask model.CodegenTask
+	if s.db.First(&latestTask, taskID).Error != nil {
+		return
+	}
+	if err == nil && latestTask.Status == "completed" {
+		filesChanged, additions, deletions := 0, 0, 0
+		if latestTask.DiffStat.Data != nil {
+			filesChanged = latestTask.DiffStat.Data.FilesChanged
+			additions = latestTask.DiffStat.Data.Additions
+			deletions = latestTask.DiffStat.Data.Deletions
+		}
+		go s.notifier.NotifyCodegenCompleted(context.Background(), notify.CodegenCompletedEvent{
+			RequirementID:  req.ID,
+			Title:          req.Title,
+			ProjectName:    projectName,
+			TaskID:         taskID,
+			CreatorOpenID:  creatorOpenID,
+			AssigneeOpenID: assigneeOpenID,
+			FilesChanged:   filesChanged,
+			Additions:      additions,
+			Deletions:      deletions,
+		})
+	} else if latestTask.Status == "awaiting_approval" && latestTask.Plan.Data != nil {
+		go s.notifier.NotifyPlanReady(context.Background(), notify.PlanReadyEvent{
+			RequirementID:  req.ID,
+			Title:          req.Title,
+			ProjectName:    projectName,
+			TaskID:         taskID,
+			CreatorOpenID:  creatorOpenID,
+			AssigneeOpenID: assigneeOpenID,
+			Summary:        latestTask.Plan.Data.Summary,
+			Steps:          len(latestTask.Plan.Data.Steps),
+		})
+	} else if latestTask.Status == "failed" || latestTask.Status == "quarantined" {
+		go s.notifier.NotifyCodegenFailed(context.Background(), notify.CodegenFailedEvent{
+			RequirementID:  req.ID,
+			Title:          req.Title,
+			ProjectName:    projectName,
+			TaskID:         taskID,
+			CreatorOpenID:  creatorOpenID,
+			AssigneeOpenID: assigneeOpenID,
+			ErrorMessage:   latestTask.ErrorMessage,
+		})
+	}
 }
 
 func (s *CodegenService) ManualSubmit(requirement *model.Requirement, repo *model.Repository, sourceBranch, commitMessage, commitURL string, userID uint) (*model.CodegenTask, error) {
 	if sourceBranch == "" {
 		sourceBranch = repo.DefaultBranch
 	}
-	targetBranch := fmt.Sprintf("code-master/req-%d", requirement.ID)
+	targetBranch, err := s.requirementBranch(requirement, repo.ID)
+	if err != nil {
+		return nil, err
+	}
 
 	// Extract commit SHA from commit URL
 	commitSHA := extractCommitSHA(commitURL)
@@ -239,6 +651,181 @@ func (s *CodegenService) ManualSubmit(requirement *model.Requirement, repo *mode
 	return task, nil
 }
 
+// ApplyPatch submits code by hand as an uploaded patch: a `git format-patch`
+// series is committed with `git am`, a plain unified diff with `git apply` and
+// commitMessage. Either is applied onto the requirement branch (created from
+// sourceBranch if missing), pushed, and recorded as a completed task, so it
+// can be reviewed and merged like a generated one. Patches adding secrets are
+// refused.
+func (s *CodegenService) ApplyPatch(requirement *model.Requirement, repo *model.Repository, sourceBranch, commitMessage string, patch []byte, userID uint) (*model.CodegenTask, error) {
+	mbox := gitops.IsMailbox(patch)
+	if !mbox && !gitops.IsUnifiedDiff(patch) {
+		return nil, fmt.Errorf("40001:参数校验失败: 补丁须为 unified diff 或 git format-patch 格式")
+	}
+	if sourceBranch == "" {
+		sourceBranch = repo.DefaultBranch
+	}
+	targetBranch, err := s.requirementBranch(requirement, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	task := &model.CodegenTask{
+		RequirementID: requirement.ID,
+		RepositoryID:  repo.ID,
+		UserID:        userID,
+		SourceBranch:  sourceBranch,
+		TargetBranch:  targetBranch,
+		Status:        "running",
+		Prompt:        "手动提交 (补丁)",
+		ExtraContext:  commitMessage,
+		StartedAt:     &now,
+	}
+	if err := s.db.Create(task).Error; err != nil {
+		return nil, err
+	}
+	if commitMessage == "" {
+		templates, vars := s.namingVars(requirement, task.ID)
+		if templates != nil && templates.Commit != "" {
+			commitMessage, _ = codegen.RenderCommitMessage(templates, vars)
+		}
+		if commitMessage == "" {
+			commitMessage = fmt.Sprintf("%s: %s\n\nManual patch submitted via CodeMaster", vars.Type, requirement.Title)
+		}
+		task.ExtraContext = commitMessage
+		s.db.Model(task).Update("extra_context", commitMessage)
+	}
+	commitSHA, baseSHA, diffStat, err := s.applyPatch(task, repo, patch, mbox)
+	if err != nil {
+		_, msg := ErrorCode(err)
+		completedAt := time.Now()
+		s.db.Model(task).Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": msg,
+			"completed_at":  &completedAt,
+		})
+		return nil, err
+	}
+
+	completedAt := time.Now()
+	updates := map[string]interface{}{
+		"status":       "completed",
+		"commit_sha":   commitSHA,
+		"base_sha":     baseSHA,
+		"commits":      task.Commits,
+		"completed_at": &completedAt,
+	}
+	if diffStat != nil {
+		updates["diff_stat"] = model.JSONDiffStat{Data: diffStat}
+	}
+	s.db.Model(task).Updates(updates)
+	s.db.Model(requirement).Update("status", "generated")
+	task.Status, task.CommitSHA, task.BaseSHA = "completed", commitSHA, baseSHA
+	return task, nil
+}
+
+// applyPatch applies patch on the requirement branch and pushes it, recording
+// the commits it created on task.
+func (s *CodegenService) applyPatch(task *model.CodegenTask, repo *model.Repository, patch []byte, mbox bool) (string, string, *model.DiffStat, error) {
+	unlock, err := s.branches.TryLock(task.RequirementID, task.TargetBranch, task.ID)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("40003:同一需求分支已有任务在执行")
+	}
+	defer unlock()
+
+	token, err := s.gitToken(task.UserID, repo)
+	if err != nil {
+		return "", "", nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	workDir := filepath.Join(s.workDir, "manual", strconv.FormatUint(uint64(task.ID), 10))
+	defer os.RemoveAll(workDir)
+	if err := gitops.Clone(ctx, repo.GitURL, token, task.SourceBranch, workDir); err != nil {
+		return "", "", nil, fmt.Errorf("40004:克隆仓库失败: %s", err.Error())
+	}
+	identity, err := s.commitIdentity(repo.ProjectID, task.UserID)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if err := identity.Configure(ctx, workDir); err != nil {
+		return "", "", nil, err
+	}
+	unsign, err := identity.Sign(ctx, workDir, workDir+".keys")
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer unsign()
+	if err := gitops.FetchAndCheckout(ctx, workDir, repo.GitURL, token, task.TargetBranch); err != nil {
+		if err := gitops.CreateBranch(ctx, workDir, task.TargetBranch); err != nil {
+			return "", "", nil, err
+		}
+	}
+	baseSHA, err := gitops.RevParse(ctx, workDir, "HEAD")
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	// Keep the patch outside the worktree so it is not committed with it
+	patchFile := workDir + ".patch"
+	if err := os.WriteFile(patchFile, patch, 0o600); err != nil {
+		return "", "", nil, err
+	}
+	defer os.Remove(patchFile)
+
+	var commitSHA string
+	if mbox {
+		if err := gitops.AmPatches(ctx, workDir, patchFile); err != nil {
+			return "", "", nil, fmt.Errorf("40004:补丁无法应用: %s", err.Error())
+		}
+		if commitSHA, err = gitops.RevParse(ctx, workDir, "HEAD"); err != nil {
+			return "", "", nil, err
+		}
+	} else {
+		if err := gitops.ApplyDiff(ctx, workDir, patchFile); err != nil {
+			return "", "", nil, fmt.Errorf("40004:补丁无法应用: %s", err.Error())
+		}
+		if commitSHA, _, err = gitops.AddAndCommit(ctx, workDir, identity.Message(task.ExtraContext)); err != nil {
+			return "", "", nil, err
+		}
+	}
+	if commitSHA == "" || commitSHA == baseSHA {
+		return "", "", nil, fmt.Errorf("40004:补丁没有产生任何变更")
+	}
+	if task.Commits, err = gitops.CommitsBetween(ctx, workDir, baseSHA, commitSHA); err != nil {
+		return "", "", nil, err
+	}
+
+	diff, err := gitops.GetDiffContent(ctx, workDir, baseSHA, commitSHA, "")
+	if err != nil {
+		return "", "", nil, err
+	}
+	if findings := secrets.ScanDiff(diff); len(findings) > 0 {
+		locations := make([]string, len(findings))
+		for i, f := range findings {
+			locations[i] = f.String()
+		}
+		return "", "", nil, fmt.Errorf("40004:补丁包含疑似密钥或凭据，未推送: %s", strings.Join(locations, ", "))
+	}
+
+	diffStat, _ := gitops.GetDiffStat(ctx, workDir, task.SourceBranch, task.TargetBranch)
+	if diffStat != nil {
+		for i := range diffStat.Files {
+			if content, err := gitops.GetFileDiff(ctx, workDir, task.SourceBranch, task.TargetBranch, diffStat.Files[i]); err == nil {
+				diffStat.Files[i].Diff = content
+			}
+		}
+		diffstore.Offload(s.db, diffStat.Files)
+	}
+
+	if err := gitops.Push(ctx, workDir, task.TargetBranch, repo.GitURL, token, s.useLocalGit); err != nil {
+		return "", "", nil, fmt.Errorf("40004:推送失败: %s", err.Error())
+	}
+	return commitSHA, baseSHA, diffStat, nil
+}
+
 // extractCommitSHA extracts the commit SHA from a commit URL.
 // Supports GitHub (…/commit/{sha}) and GitLab (…/-/commit/{sha}).
 func extractCommitSHA(commitURL string) string {
@@ -286,43 +873,372 @@ func (s *CodegenService) computeManualDiff(task *model.CodegenTask, repo *model.
 		return
 	}
 
-	diffFiles, err := gitops.GetDiffFiles(ctx, workDir, sourceBranch, targetBranch)
-	if err == nil {
-		for i := range diffFiles {
-			if content, err := gitops.GetDiffContent(ctx, workDir, sourceBranch, targetBranch, diffFiles[i].Path); err == nil {
-				diffFiles[i].Diff = content
-			}
+	for i := range diffStat.Files {
+		if content, err := gitops.GetFileDiff(ctx, workDir, sourceBranch, targetBranch, diffStat.Files[i]); err == nil {
+			diffStat.Files[i].Diff = content
 		}
-		diffStat.Files = diffFiles
 	}
+	diffstore.Offload(s.db, diffStat.Files)
 
 	s.db.Model(task).Update("diff_stat", model.JSONDiffStat{Data: diffStat})
 }
 
-func (s *CodegenService) GetTask(id uint) (*model.CodegenTask, error) {
-	var task model.CodegenTask
-	if err := s.db.Preload("Requirement").Preload("Repository").First(&task, id).Error; err != nil {
-		return nil, err
-	}
-	return &task, nil
+// DiffPatch returns the patch of one file of a task's diff.
+func (s *CodegenService) DiffPatch(f model.DiffFile) (string, error) {
+	return diffstore.Patch(s.db, f)
 }
 
-func (s *CodegenService) ListTasksByRequirement(requirementID uint, page, pageSize int) ([]model.CodegenTask, int64, error) {
-	query := s.db.Model(&model.CodegenTask{}).Where("requirement_id = ?", requirementID)
-
-	var total int64
-	query.Count(&total)
-
-	var tasks []model.CodegenTask
-	if err := query.Order("created_at desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&tasks).Error; err != nil {
-		return nil, 0, err
+// gitToken resolves the token for git operations on behalf of a user: their
+// personal token, else the repository's.
+func (s *CodegenService) gitToken(userID uint, repo *model.Repository) (string, error) {
+	if userID > 0 {
+		var setting model.UserSetting
+		if err := s.db.Where("user_id = ?", userID).First(&setting).Error; err == nil && setting.GitlabToken != "" {
+			return setting.GitlabToken, nil
+		}
 	}
-	return tasks, total, nil
+	token, err := encrypt.AESDecrypt(s.aesKey, repo.AccessToken)
+	if err != nil {
+		return "", fmt.Errorf("解密 access token 失败: %w", err)
+	}
+	return token, nil
 }
 
-func (s *CodegenService) CancelTask(taskID uint) error {
-	s.mu.Lock()
-	executor, ok := s.executors[taskID]
+// commitIdentity is who the commits of a project made for userID are
+// attributed to, per the project's commit identity setting.
+func (s *CodegenService) commitIdentity(projectID, userID uint) (*gitops.Identity, error) {
+	var project model.Project
+	if err := s.db.Select("id", "commit_identity", "signing_key").First(&project, projectID).Error; err != nil {
+		return nil, err
+	}
+	identity := &gitops.Identity{}
+	if ci := project.CommitIdentity.Data; ci != nil && ci.Mode != "" && ci.Mode != model.CommitAsBot && userID > 0 {
+		var user model.User
+		// Without a Feishu email the commit cannot be linked to anyone
+		if s.db.Select("id", "name", "email").First(&user, userID).Error == nil && user.Email != "" {
+			person := &gitops.Person{Name: user.Name, Email: user.Email}
+			if ci.Mode == model.CommitAsAuthor {
+				identity.Author = person
+			} else {
+				identity.CoAuthor = person
+			}
+		}
+	}
+	if ci := project.CommitIdentity.Data; ci != nil && ci.SigningFormat != "" && project.SigningKey != "" {
+		key, err := encrypt.AESDecrypt(s.aesKey, project.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("解密签名私钥失败: %w", err)
+		}
+		identity.Signing = &gitops.SigningKey{Format: ci.SigningFormat, PrivateKey: key}
+	}
+	return identity, nil
+}
+
+// BranchSyncOutcome is the result of syncing a requirement branch on demand.
+type BranchSyncOutcome struct {
+	SourceBranch string
+	TargetBranch string
+	Result       *model.BranchSyncResult
+	Head         string // requirement branch head afterwards
+}
+
+// SyncRequirementBranch brings the requirement branch up to date with its
+// source branch: the one of the latest task unless sourceBranch is given.
+// strategy defaults to the project's, else merge. Conflicts are reported and
+// leave the branch as it is.
+func (s *CodegenService) SyncRequirementBranch(requirement *model.Requirement, repo *model.Repository, sourceBranch, strategy string, userID uint) (*BranchSyncOutcome, error) {
+	if strategy == "" {
+		var project model.Project
+		if s.db.Select("id", "branch_sync").First(&project, requirement.ProjectID).Error == nil && project.BranchSync.Data != nil {
+			strategy = project.BranchSync.Data.Strategy
+		}
+		if strategy == "" {
+			strategy = model.BranchSyncMerge
+		}
+	}
+	if err := codegen.ValidateBranchSync(&model.BranchSync{Strategy: strategy}); err != nil {
+		return nil, fmt.Errorf("40002:%s", err.Error())
+	}
+	if sourceBranch == "" {
+		var last model.CodegenTask
+		if s.db.Select("id", "source_branch").Where("requirement_id = ?", requirement.ID).Order("id desc").First(&last).Error == nil {
+			sourceBranch = last.SourceBranch
+		}
+		if sourceBranch == "" {
+			sourceBranch = repo.DefaultBranch
+		}
+	}
+	targetBranch, err := s.requirementBranch(requirement, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := &BranchSyncOutcome{SourceBranch: sourceBranch, TargetBranch: targetBranch}
+
+	// No task backs the sync; concurrent syncs are stopped by the push instead
+	unlock, err := s.branches.TryLock(requirement.ID, out.TargetBranch, 0)
+	if err != nil {
+		return nil, fmt.Errorf("40003:同一需求分支已有任务在执行")
+	}
+	defer unlock()
+
+	token, err := s.gitToken(userID, repo)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	root := filepath.Join(s.workDir, "scratch")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	workDir, err := os.MkdirTemp(root, fmt.Sprintf("sync-%d-", requirement.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	if err := gitops.Clone(ctx, repo.GitURL, token, sourceBranch, workDir); err != nil {
+		return nil, fmt.Errorf("40004:克隆仓库失败: %s", err.Error())
+	}
+	identity, err := s.commitIdentity(requirement.ProjectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := identity.Configure(ctx, workDir); err != nil {
+		return nil, err
+	}
+	unsign, err := identity.Sign(ctx, workDir, workDir+".keys")
+	if err != nil {
+		return nil, err
+	}
+	defer unsign()
+	if err := gitops.FetchAndCheckout(ctx, workDir, repo.GitURL, token, out.TargetBranch); err != nil {
+		return nil, fmt.Errorf("40004:需求分支尚未创建，无需同步")
+	}
+	if err := gitops.Unshallow(ctx, workDir, repo.GitURL, token); err != nil {
+		return nil, err
+	}
+	branchSHA, err := gitops.RevParse(ctx, workDir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	sourceSHA, err := gitops.RevParse(ctx, workDir, sourceBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := gitops.SyncBranch(ctx, workDir, sourceBranch, strategy, false)
+	if err != nil {
+		return nil, fmt.Errorf("40004:同步源分支失败: %s", err.Error())
+	}
+	out.Result = &model.BranchSyncResult{
+		Strategy:  res.Strategy,
+		SourceSHA: sourceSHA,
+		BranchSHA: branchSHA,
+		Conflicts: res.Conflicts,
+	}
+	out.Head = branchSHA
+	switch {
+	case len(res.Conflicts) > 0:
+		out.Result.Status = model.BranchSyncConflict
+		return out, nil
+	case !res.Updated:
+		out.Result.Status = model.BranchSyncUpToDate
+		return out, nil
+	}
+
+	if strategy == model.BranchSyncRebase {
+		err = gitops.PushWithLease(ctx, workDir, out.TargetBranch, branchSHA, repo.GitURL, token, s.useLocalGit)
+	} else {
+		err = gitops.Push(ctx, workDir, out.TargetBranch, repo.GitURL, token, s.useLocalGit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("40004:推送失败: %s", err.Error())
+	}
+	if out.Head, err = gitops.RevParse(ctx, workDir, "HEAD"); err != nil {
+		return nil, err
+	}
+	out.Result.Status = model.BranchSyncUpdated
+	return out, nil
+}
+
+// IterationCompare is the diff between the commits of two codegen tasks of
+// the same requirement.
+type IterationCompare struct {
+	Base     *model.CodegenTask
+	Head     *model.CodegenTask
+	BaseFrom string // specified / review / previous
+	Stat     *model.DiffStat
+}
+
+// CompareIterations diffs the commit of task headID against that of
+// baseTaskID. Without a base it compares against the last iteration that went
+// through human review, else the previous iteration.
+func (s *CodegenService) CompareIterations(headID uint, baseTaskID *uint) (*IterationCompare, error) {
+	var head model.CodegenTask
+	if err := s.db.Preload("Repository").First(&head, headID).Error; err != nil {
+		return nil, fmt.Errorf("40405:生成任务不存在")
+	}
+	if head.Status != "completed" || head.CommitSHA == "" {
+		return nil, fmt.Errorf("40003:任务尚未完成或没有提交，无法对比")
+	}
+	if head.Repository == nil {
+		return nil, fmt.Errorf("40004:仓库不存在")
+	}
+
+	cmp := &IterationCompare{Head: &head}
+	if baseTaskID != nil {
+		var base model.CodegenTask
+		if err := s.db.First(&base, *baseTaskID).Error; err != nil {
+			return nil, fmt.Errorf("40405:对比的生成任务不存在")
+		}
+		if base.ID == head.ID {
+			return nil, fmt.Errorf("40001:参数校验失败: 不能与自身对比")
+		}
+		if base.RequirementID != head.RequirementID {
+			return nil, fmt.Errorf("40003:只能对比同一需求的迭代")
+		}
+		if base.Status != "completed" || base.CommitSHA == "" {
+			return nil, fmt.Errorf("40003:对比的任务尚未完成或没有提交")
+		}
+		cmp.Base, cmp.BaseFrom = &base, "specified"
+	} else {
+		earlier := func() *gorm.DB {
+			return s.db.Model(&model.CodegenTask{}).
+				Where("codegen_tasks.requirement_id = ? AND codegen_tasks.id < ?", head.RequirementID, head.ID).
+				Where("codegen_tasks.status = ? AND codegen_tasks.commit_sha <> ''", "completed").
+				Order("codegen_tasks.id desc")
+		}
+		var base model.CodegenTask
+		err := earlier().
+			Joins("JOIN code_reviews ON code_reviews.codegen_task_id = codegen_tasks.id").
+			Where("code_reviews.human_status <> ?", "pending").
+			First(&base).Error
+		if err == nil {
+			cmp.Base, cmp.BaseFrom = &base, "review"
+		} else if err := earlier().First(&base).Error; err == nil {
+			cmp.Base, cmp.BaseFrom = &base, "previous"
+		} else {
+			return nil, fmt.Errorf("40003:没有可对比的上一次迭代")
+		}
+	}
+
+	stat, err := s.diffCommits(&head, cmp.Base.CommitSHA, head.CommitSHA)
+	if err != nil {
+		return nil, err
+	}
+	cmp.Stat = stat
+	return cmp, nil
+}
+
+// maxCachedCompares bounds the commit diffs kept in memory. Compare patches
+// are held inline rather than in diff blobs, which no task row would own.
+const maxCachedCompares = 32
+
+// compareCache keeps the most recently used commit diffs.
+type compareCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+type compareEntry struct {
+	key  string
+	stat *model.DiffStat
+}
+
+func newCompareCache(max int) *compareCache {
+	return &compareCache{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *compareCache) get(key string) (*model.DiffStat, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*compareEntry).stat, true
+}
+
+func (c *compareCache) put(key string, stat *model.DiffStat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*compareEntry).stat = stat
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&compareEntry{key: key, stat: stat})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*compareEntry).key)
+	}
+}
+
+// diffCommits computes the per-file diff between two commits of task's
+// repository, with secrets and the git token masked as in a task's own diff.
+// Commits never change, so results are cached.
+func (s *CodegenService) diffCommits(task *model.CodegenTask, from, to string) (*model.DiffStat, error) {
+	key := from + ".." + to
+	if stat, ok := s.compares.get(key); ok {
+		return stat, nil
+	}
+
+	token, err := s.gitToken(task.UserID, task.Repository)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	workDir, cleanup, err := s.commitRepo(ctx, task, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	stat, err := gitops.GetDiffStat(ctx, workDir, from, to)
+	if err != nil {
+		return nil, err
+	}
+	redactor := secrets.NewRedactor(token)
+	for i := range stat.Files {
+		if content, err := gitops.GetFileDiff(ctx, workDir, from, to, stat.Files[i]); err == nil {
+			stat.Files[i].Diff = redactor.String(content)
+		}
+	}
+
+	s.compares.put(key, stat)
+	return stat, nil
+}
+
+func (s *CodegenService) GetTask(id uint) (*model.CodegenTask, error) {
+	var task model.CodegenTask
+	if err := s.db.Preload("Requirement").Preload("Repository").First(&task, id).Error; err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (s *CodegenService) ListTasksByRequirement(requirementID uint, page, pageSize int) ([]model.CodegenTask, int64, error) {
+	query := s.db.Model(&model.CodegenTask{}).Where("requirement_id = ?", requirementID)
+
+	var total int64
+	query.Count(&total)
+
+	var tasks []model.CodegenTask
+	if err := query.Order("created_at desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&tasks).Error; err != nil {
+		return nil, 0, err
+	}
+	return tasks, total, nil
+}
+
+func (s *CodegenService) CancelTask(taskID uint) error {
+	s.mu.Lock()
+	executor, ok := s.executors[taskID]
 	s.mu.Unlock()
 
 	if !ok {
@@ -331,16 +1247,10 @@ func (s *CodegenService) CancelTask(taskID uint) error {
 			return err
 		}
 		switch task.Status {
-		case "pending", "cloning", "running":
-			// Executor not in memory (e.g. server restarted) — force cancel in DB
-			now := time.Now()
-			s.db.Model(&task).Updates(map[string]interface{}{
-				"status":       "cancelled",
-				"completed_at": &now,
-			})
-			if task.RequirementID > 0 {
-				s.db.Model(&model.Requirement{}).Where("id = ?", task.RequirementID).Update("status", "draft")
-			}
+		case "pending", "cloning", "running", "awaiting_approval":
+			// Still waiting in the queue, or executor not in memory — cancel in DB
+			s.pool.Remove(taskID)
+			s.markCancelled(&task)
 			return nil
 		default:
 			return fmt.Errorf("40003:任务已完成，无法取消")
@@ -349,6 +1259,565 @@ func (s *CodegenService) CancelTask(taskID uint) error {
 	return executor.Cancel()
 }
 
+// SendInput forwards a user's follow-up instruction to a running codegen session.
+func (s *CodegenService) SendInput(taskID uint, user *model.User, message string) error {
+	s.mu.Lock()
+	executor, ok := s.executors[taskID]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("40003:任务未在执行中，无法发送消息")
+	}
+	err := executor.SendInput(message, user.ID, user.Name)
+	switch {
+	case errors.Is(err, agent.ErrInputUnsupported):
+		return fmt.Errorf("40003:当前 Agent 后端不支持运行中追加指令")
+	case errors.Is(err, agent.ErrSessionFinished):
+		return fmt.Errorf("40003:Agent 本轮执行已结束，无法再追加指令")
+	case err != nil:
+		return fmt.Errorf("发送消息失败: %w", err)
+	}
+	return nil
+}
+
+// CanApproveTool reports whether user may decide the tool approvals of task:
+// the requirement's assignee, the project owner or an admin.
+func (s *CodegenService) CanApproveTool(task *model.CodegenTask, user *model.User) bool {
+	if user.IsAdmin {
+		return true
+	}
+	if task.Requirement == nil {
+		return false
+	}
+	if task.Requirement.AssigneeID != nil && *task.Requirement.AssigneeID == user.ID {
+		return true
+	}
+	return s.IsProjectOwner(task, user.ID)
+}
+
+// IsProjectOwner reports whether userID owns the project of task.
+func (s *CodegenService) IsProjectOwner(task *model.CodegenTask, userID uint) bool {
+	if task.Requirement == nil {
+		return false
+	}
+	var project model.Project
+	return s.db.Select("id", "owner_id").First(&project, task.Requirement.ProjectID).Error == nil && project.OwnerID == userID
+}
+
+// ListApprovals returns the tool calls of a running task waiting for a decision.
+func (s *CodegenService) ListApprovals(taskID uint) []codegen.ToolApproval {
+	s.mu.Lock()
+	executor, ok := s.executors[taskID]
+	s.mu.Unlock()
+	if !ok {
+		return []codegen.ToolApproval{}
+	}
+	return executor.PendingApprovals()
+}
+
+// ResolveApproval approves or denies a pending tool call of a running task.
+// Callers check CanApproveTool first.
+func (s *CodegenService) ResolveApproval(taskID uint, approvalID string, user *model.User, approve bool, comment string) error {
+	s.mu.Lock()
+	executor, ok := s.executors[taskID]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("40003:任务未在执行中")
+	}
+	err := executor.ResolveApproval(approvalID, approve, user.ID, user.Name, comment)
+	switch {
+	case errors.Is(err, codegen.ErrApprovalNotFound):
+		return fmt.Errorf("40407:审批请求不存在或已处理")
+	case errors.Is(err, agent.ErrSessionFinished):
+		return fmt.Errorf("40003:Agent 会话已结束")
+	case err != nil:
+		return fmt.Errorf("处理审批失败: %w", err)
+	}
+	return nil
+}
+
+// ReleaseQuarantined pushes the commit of a task quarantined by the project
+// diff policy and completes the task. The commit must still be in the task's
+// workspace.
+func (s *CodegenService) ReleaseQuarantined(taskID uint) (*model.CodegenTask, error) {
+	var task model.CodegenTask
+	if err := s.db.Preload("Repository").First(&task, taskID).Error; err != nil {
+		return nil, fmt.Errorf("40405:生成任务不存在")
+	}
+	if task.Status != "quarantined" {
+		return nil, fmt.Errorf("40003:任务未被隔离，无需放行")
+	}
+	if task.Repository == nil {
+		return nil, fmt.Errorf("40004:仓库不存在")
+	}
+	if task.WorkDir == "" {
+		return nil, fmt.Errorf("40004:任务工作区已被清理，请重新生成")
+	}
+	if _, err := os.Stat(task.WorkDir); err != nil {
+		return nil, fmt.Errorf("40004:任务工作区已被清理，请重新生成")
+	}
+
+	unlock, err := s.branches.TryLock(task.RequirementID, task.TargetBranch, task.ID)
+	if err != nil {
+		return nil, fmt.Errorf("40003:同一需求分支已有任务在执行")
+	}
+	defer unlock()
+
+	ctx := context.Background()
+	if head, err := gitops.RevParse(ctx, task.WorkDir, "HEAD"); err != nil || head != task.CommitSHA {
+		return nil, fmt.Errorf("40004:工作区中的提交已变化，无法放行")
+	}
+
+	// Push with the token the task ran with: the triggering user's, else the repo's
+	token, err := s.gitToken(task.UserID, task.Repository)
+	if err != nil {
+		return nil, err
+	}
+	// A branch rebased onto the source branch replaces the remote one
+	if bs := task.BranchSync.Data; bs != nil && bs.Strategy == model.BranchSyncRebase && bs.Status == model.BranchSyncUpdated {
+		err = gitops.PushWithLease(ctx, task.WorkDir, task.TargetBranch, bs.BranchSHA, task.Repository.GitURL, token, s.useLocalGit)
+	} else {
+		err = gitops.Push(ctx, task.WorkDir, task.TargetBranch, task.Repository.GitURL, token, s.useLocalGit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("40004:推送失败: %s", err.Error())
+	}
+
+	updates := map[string]interface{}{
+		"status":        "completed",
+		"error_message": "",
+	}
+	if stat := task.DiffStat.Data; stat != nil {
+		diffstore.Offload(s.db, stat.Files)
+		updates["diff_stat"] = model.JSONDiffStat{Data: stat}
+	}
+	s.db.Model(&task).Updates(updates)
+	s.db.Model(&model.Requirement{}).Where("id = ?", task.RequirementID).Update("status", "generated")
+	s.notifyTaskResult(task.ID, task.RequirementID, nil)
+	return &task, nil
+}
+
+// commitRepo returns a repository of task's repository holding the given
+// commits and their history: the mirror cache when enabled, else a throwaway
+// clone. The mirror is shared, so callers must only read from it and call
+// cleanup when done.
+func (s *CodegenService) commitRepo(ctx context.Context, task *model.CodegenTask, shas ...string) (string, func(), error) {
+	token, err := s.gitToken(task.UserID, task.Repository)
+	if err != nil {
+		return "", nil, err
+	}
+	gitURL := task.Repository.GitURL
+	if gitops.MirrorCacheDir() != "" {
+		dir, err := gitops.MirrorWithCommits(ctx, gitURL, token, shas...)
+		if err == nil {
+			return dir, func() {}, nil
+		}
+		log.Printf("[codegen] task %d: mirror cache unavailable, falling back to clone: %v", task.ID, err)
+	}
+
+	root := filepath.Join(s.workDir, "scratch")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", nil, err
+	}
+	workDir, err := os.MkdirTemp(root, fmt.Sprintf("task-%d-", task.ID))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(workDir) }
+
+	// The requirement branch may be gone after merging; the source branch is
+	// only needed as a repository to fetch the commits into.
+	if err := gitops.Clone(ctx, gitURL, token, task.SourceBranch, workDir); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("40004:克隆仓库失败: %s", err.Error())
+	}
+	if err := gitops.Unshallow(ctx, workDir, gitURL, token); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("40004:%s", err.Error())
+	}
+	for _, sha := range shas {
+		if err := gitops.FetchCommit(ctx, workDir, gitURL, token, sha); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("40004:提交 %s 在远端不存在: %s", sha, err.Error())
+		}
+	}
+	return workDir, cleanup, nil
+}
+
+// exportTask checks that task's commits can be exported and returns the
+// repository holding them and the commit they are on top of. Tasks from
+// before base_sha was recorded export the whole branch since it left the
+// source branch.
+func (s *CodegenService) exportTask(ctx context.Context, taskID uint) (*model.CodegenTask, string, string, func(), error) {
+	var task model.CodegenTask
+	if err := s.db.Preload("Repository").First(&task, taskID).Error; err != nil {
+		return nil, "", "", nil, fmt.Errorf("40405:生成任务不存在")
+	}
+	if task.Status != "completed" || task.CommitSHA == "" {
+		return nil, "", "", nil, fmt.Errorf("40003:任务尚未完成或没有提交，无法导出")
+	}
+	if task.Repository == nil {
+		return nil, "", "", nil, fmt.Errorf("40004:仓库不存在")
+	}
+	shas := []string{task.CommitSHA}
+	if task.BaseSHA != "" {
+		shas = append(shas, task.BaseSHA)
+	}
+	dir, cleanup, err := s.commitRepo(ctx, &task, shas...)
+	if err != nil {
+		return nil, "", "", nil, err
+	}
+	base := task.BaseSHA
+	if base == "" {
+		if base, err = gitops.MergeBase(ctx, dir, "refs/heads/"+task.SourceBranch, task.CommitSHA); err != nil {
+			cleanup()
+			return nil, "", "", nil, fmt.Errorf("40004:无法确定任务提交的起点: %s", err.Error())
+		}
+	}
+	return &task, dir, base, cleanup, nil
+}
+
+// ExportPatch returns the commits of a task as `git format-patch` output.
+func (s *CodegenService) ExportPatch(taskID uint) (*model.CodegenTask, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	task, dir, base, cleanup, err := s.exportTask(ctx, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cleanup()
+	patch, err := gitops.FormatPatch(ctx, dir, base, task.CommitSHA)
+	if err != nil {
+		return nil, nil, err
+	}
+	return task, patch, nil
+}
+
+// ExportBundle writes a git bundle of the commits of a task, with the task's
+// commit as its target branch, and returns its path. The bundle needs the
+// commit they are on top of. Call cleanup once the file has been sent.
+func (s *CodegenService) ExportBundle(taskID uint) (*model.CodegenTask, string, func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	task, dir, base, cleanupRepo, err := s.exportTask(ctx, taskID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer cleanupRepo()
+
+	root := filepath.Join(s.workDir, "scratch")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, "", nil, err
+	}
+	out, err := os.MkdirTemp(root, fmt.Sprintf("bundle-%d-", task.ID))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(out) }
+	path := filepath.Join(out, fmt.Sprintf("task-%d.bundle", task.ID))
+	if err := gitops.CreateBundle(ctx, dir, task.TargetBranch, base, task.CommitSHA, path); err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	return task, path, cleanup, nil
+}
+
+// RevertResult describes a rolled back iteration.
+type RevertResult struct {
+	Task     *model.CodegenTask
+	Previous *model.CodegenTask // iteration the branch is back at; nil for the first one
+	FromSHA  string
+	ToSHA    string
+}
+
+// RevertIteration rolls back the latest iteration of a requirement: mode
+// reset moves the requirement branch back to the previous iteration's commit,
+// mode revert pushes a commit undoing the iteration. Either way the push only
+// succeeds if the branch still points at the task's commit. The reverted
+// task's session is no longer offered for resuming.
+func (s *CodegenService) RevertIteration(taskID, userID uint, mode string) (*RevertResult, error) {
+	var task model.CodegenTask
+	if err := s.db.Preload("Repository").First(&task, taskID).Error; err != nil {
+		return nil, fmt.Errorf("40405:生成任务不存在")
+	}
+	if task.Status != "completed" || task.CommitSHA == "" {
+		return nil, fmt.Errorf("40003:任务尚未完成或没有提交，无法回滚")
+	}
+	if task.RevertedAt != nil {
+		return nil, fmt.Errorf("40003:该迭代已回滚")
+	}
+	if task.Repository == nil {
+		return nil, fmt.Errorf("40004:仓库不存在")
+	}
+
+	var prev *model.CodegenTask
+	var p model.CodegenTask
+	if err := s.db.Where("requirement_id = ? AND id < ? AND status = ? AND commit_sha <> '' AND reverted_at IS NULL",
+		task.RequirementID, task.ID, "completed").Order("id desc").First(&p).Error; err == nil {
+		prev = &p
+	}
+	if mode == model.RevertModeReset && prev == nil {
+		return nil, fmt.Errorf("40003:没有可回退到的上一次迭代，请使用 revert 方式")
+	}
+
+	unlock, err := s.branches.TryLock(task.RequirementID, task.TargetBranch, task.ID)
+	if err != nil {
+		return nil, fmt.Errorf("40003:同一需求分支已有任务在执行")
+	}
+	defer unlock()
+
+	token, err := s.gitToken(userID, task.Repository)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	root := filepath.Join(s.workDir, "revert")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	workDir, err := os.MkdirTemp(root, fmt.Sprintf("task-%d-", task.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	gitURL := task.Repository.GitURL
+	if err := gitops.Clone(ctx, gitURL, token, task.SourceBranch, workDir); err != nil {
+		return nil, fmt.Errorf("40004:克隆仓库失败: %s", err.Error())
+	}
+	if err := gitops.FetchAndCheckout(ctx, workDir, gitURL, token, task.TargetBranch); err != nil {
+		return nil, fmt.Errorf("40004:需求分支不存在: %s", err.Error())
+	}
+	if head, err := gitops.RevParse(ctx, workDir, "HEAD"); err != nil || head != task.CommitSHA {
+		return nil, fmt.Errorf("40003:需求分支已有新的提交，只能回滚最新一次迭代")
+	}
+	if err := gitops.Unshallow(ctx, workDir, gitURL, token); err != nil {
+		return nil, fmt.Errorf("40004:%s", err.Error())
+	}
+
+	var newHead string
+	switch mode {
+	case model.RevertModeReset:
+		if err := gitops.FetchCommit(ctx, workDir, gitURL, token, prev.CommitSHA); err != nil {
+			return nil, fmt.Errorf("40004:上一次迭代的提交在远端不存在: %s", err.Error())
+		}
+		if err := gitops.ResetHard(ctx, workDir, prev.CommitSHA); err != nil {
+			return nil, err
+		}
+		newHead = prev.CommitSHA
+	default:
+		// Undo the iteration's own commits. BaseSHA is taken after the branch
+		// was synced with the source branch, so the sync (possibly a merge
+		// commit) is kept. Tasks without one undo everything since the
+		// previous iteration, or since the branch left the source branch.
+		base := task.BaseSHA
+		if base == "" && prev != nil {
+			base = prev.CommitSHA
+		} else if base == "" {
+			if base, err = gitops.MergeBase(ctx, workDir, task.SourceBranch, "HEAD"); err != nil {
+				return nil, fmt.Errorf("40004:%s", err.Error())
+			}
+		}
+		identity, err := s.commitIdentity(task.Repository.ProjectID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := identity.Configure(ctx, workDir); err != nil {
+			return nil, err
+		}
+		unsign, err := identity.Sign(ctx, workDir, workDir+".keys")
+		if err != nil {
+			return nil, err
+		}
+		defer unsign()
+		msg := identity.Message(fmt.Sprintf("Revert codegen iteration #%d\n\nThis reverts the changes of task #%d (%s).", task.ID, task.ID, task.CommitSHA))
+		if newHead, err = gitops.RevertRange(ctx, workDir, base, task.CommitSHA, msg); err != nil {
+			return nil, fmt.Errorf("40004:生成 revert 提交失败: %s", err.Error())
+		}
+	}
+
+	if err := gitops.PushWithLease(ctx, workDir, task.TargetBranch, task.CommitSHA, gitURL, token, s.useLocalGit); err != nil {
+		return nil, fmt.Errorf("40004:推送失败，分支可能已被更新: %s", err.Error())
+	}
+
+	now := time.Now()
+	s.db.Model(&task).Updates(map[string]interface{}{
+		"revert_mode": mode,
+		"revert_sha":  newHead,
+		"reverted_by": userID,
+		"reverted_at": &now,
+	})
+	// With nothing generated left on the branch the requirement is back to draft
+	reqStatus := "generated"
+	if prev == nil {
+		reqStatus = "draft"
+	}
+	s.db.Model(&model.Requirement{}).Where("id = ?", task.RequirementID).Update("status", reqStatus)
+
+	return &RevertResult{Task: &task, Previous: prev, FromSHA: task.CommitSHA, ToSHA: newHead}, nil
+}
+
+// notifyApprovalRequired asks the requirement's assignee and the project owner
+// to decide a paused tool call.
+func (s *CodegenService) notifyApprovalRequired(taskID, requirementID uint, a codegen.ToolApproval) {
+	if s.notifier == nil {
+		return
+	}
+	var req model.Requirement
+	if err := s.db.Preload("Assignee").Preload("Project.Owner").First(&req, requirementID).Error; err != nil {
+		return
+	}
+	e := notify.ApprovalRequiredEvent{
+		RequirementID: req.ID,
+		Title:         req.Title,
+		TaskID:        taskID,
+		ApprovalID:    a.ID,
+		Tool:          a.Tool,
+		Detail:        a.Detail(),
+		Reason:        a.Reason,
+		ExpiresAt:     a.ExpiresAt,
+	}
+	if req.Assignee != nil {
+		e.AssigneeOpenID = req.Assignee.FeishuUID
+	}
+	if req.Project != nil {
+		e.ProjectName = req.Project.Name
+		if req.Project.Owner != nil {
+			e.OwnerOpenID = req.Project.Owner.FeishuUID
+		}
+	}
+	s.notifier.NotifyApprovalRequired(context.Background(), e)
+}
+
+// ListQueue returns the tasks waiting in the codegen queue, in execution order.
+// Non-admin users only see tasks of projects they are a member of.
+func (s *CodegenService) ListQueue(userID uint, isAdmin bool, projectID *uint) ([]model.CodegenQueueItem, error) {
+	var projectIDs []uint
+	if !isAdmin {
+		projectIDs = []uint{}
+		s.db.Model(&model.ProjectMember{}).Where("user_id = ?", userID).Pluck("project_id", &projectIDs)
+	}
+	if projectID != nil {
+		if projectIDs != nil && !containsUint(projectIDs, *projectID) {
+			return []model.CodegenQueueItem{}, nil
+		}
+		projectIDs = []uint{*projectID}
+	}
+
+	items, err := s.pool.Waiting(projectIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fill owners from UserID
+	var ownerIDs []uint
+	for _, it := range items {
+		if it.UserID > 0 {
+			ownerIDs = append(ownerIDs, it.UserID)
+		}
+	}
+	if len(ownerIDs) > 0 {
+		var users []*model.User
+		s.db.Where("id IN ?", ownerIDs).Find(&users)
+		byID := make(map[uint]*model.User, len(users))
+		for _, u := range users {
+			byID[u.ID] = u
+		}
+		for i := range items {
+			items[i].User = byID[items[i].UserID]
+		}
+	}
+	return items, nil
+}
+
+// QueueStats returns the number of items currently executing and the worker count.
+func (s *CodegenService) QueueStats() (running, workers int) {
+	return s.pool.Running(), s.pool.MaxWorkers()
+}
+
+// GetQueueItem returns the queue entry of a task that has not been picked up by a worker yet.
+func (s *CodegenService) GetQueueItem(taskID uint) (*model.CodegenQueueItem, error) {
+	item, err := s.pool.Get(taskID)
+	if err != nil {
+		if errors.Is(err, codegen.ErrNotQueued) {
+			return nil, fmt.Errorf("40405:任务不在等待队列中")
+		}
+		return nil, err
+	}
+	return item, nil
+}
+
+// MoveQueueItem moves a waiting task to the given 1-based position in the
+// queue, or, with withinProject, among the waiting tasks of its project.
+func (s *CodegenService) MoveQueueItem(taskID uint, position int, withinProject bool) error {
+	if err := s.pool.Move(taskID, position, withinProject); err != nil {
+		if errors.Is(err, codegen.ErrNotQueued) {
+			return fmt.Errorf("40003:任务已被执行器领取，无法调整顺序")
+		}
+		return err
+	}
+	return nil
+}
+
+// PauseQueueItem pauses or resumes a waiting task. Paused tasks keep their place but are not picked up.
+func (s *CodegenService) PauseQueueItem(taskID uint, paused bool) error {
+	if err := s.pool.SetPaused(taskID, paused); err != nil {
+		if errors.Is(err, codegen.ErrNotQueued) {
+			return fmt.Errorf("40003:任务已被执行器领取，无法暂停或恢复")
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveQueueItem takes a waiting task out of the queue and marks it cancelled.
+func (s *CodegenService) RemoveQueueItem(taskID uint) error {
+	var task model.CodegenTask
+	if err := s.db.First(&task, taskID).Error; err != nil {
+		return err
+	}
+	if !s.pool.Remove(taskID) {
+		return fmt.Errorf("40003:任务已被执行器领取，无法移出队列")
+	}
+	s.markCancelled(&task)
+	return nil
+}
+
+// markCancelled finalizes a task that never reached an executor.
+func (s *CodegenService) markCancelled(task *model.CodegenTask) {
+	now := time.Now()
+	s.db.Model(task).Updates(map[string]interface{}{
+		"status":       "cancelled",
+		"completed_at": &now,
+	})
+	if task.RequirementID > 0 {
+		// Only undo the "generating" set when the task was triggered; a
+		// cancelled follow-up leaves the earlier iterations' result in place
+		status := "draft"
+		var done int64
+		s.db.Model(&model.CodegenTask{}).Where("requirement_id = ? AND status = ? AND commit_sha <> '' AND reverted_at IS NULL",
+			task.RequirementID, "completed").Count(&done)
+		if done > 0 {
+			status = "generated"
+		}
+		s.db.Model(&model.Requirement{}).Where("id = ? AND status = ?", task.RequirementID, "generating").Update("status", status)
+	}
+	s.hub.Broadcast(int64(task.ID), sse.Event{Type: "done", Data: map[string]interface{}{
+		"task_id": task.ID,
+		"status":  "cancelled",
+	}})
+}
+
+func containsUint(list []uint, v uint) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *CodegenService) GetHub() *sse.Hub {
 	return s.hub
 }
@@ -356,7 +1825,8 @@ func (s *CodegenService) GetHub() *sse.Hub {
 // ListSessions returns all tasks for a requirement that have a session_id (available for resume).
 func (s *CodegenService) ListSessions(requirementID uint) ([]model.CodegenTask, error) {
 	var tasks []model.CodegenTask
-	if err := s.db.Where("requirement_id = ? AND session_id != ''", requirementID).
+	// Sessions of rolled back iterations remember changes that are gone
+	if err := s.db.Where("requirement_id = ? AND session_id != '' AND reverted_at IS NULL", requirementID).
 		Order("created_at desc").Find(&tasks).Error; err != nil {
 		return nil, err
 	}
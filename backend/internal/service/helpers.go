@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/codeMaster/backend/internal/gitops"
 )
@@ -15,3 +16,24 @@ func testConnectionHelper(ctx context.Context, gitURL, token string) ([]string,
 func checkPushPermissionHelper(platform, gitURL, platformProjectID, token string) error {
 	return gitops.CheckPushPermission(platform, gitURL, platformProjectID, token)
 }
+
+// ErrorCode splits a service error of the form "40001:msg" into its code and
+// message. Errors without a five-digit code prefix are internal: 50001 with
+// the whole message.
+func ErrorCode(err error) (int, string) {
+	msg := err.Error()
+	if len(msg) > 5 && msg[5] == ':' {
+		digits := true
+		for i := 0; i < 5; i++ {
+			if msg[i] < '0' || msg[i] > '9' {
+				digits = false
+				break
+			}
+		}
+		if digits {
+			code, _ := strconv.Atoi(msg[:5])
+			return code, msg[6:]
+		}
+	}
+	return 50001, msg
+}
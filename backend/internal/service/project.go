@@ -1,18 +1,37 @@
 package service
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/codeMaster/backend/internal/gitops"
 	"github.com/codeMaster/backend/internal/model"
+	"github.com/codeMaster/backend/pkg/encrypt"
 	"gorm.io/gorm"
 )
 
 type ProjectService struct {
-	db *gorm.DB
+	db         *gorm.DB
+	aesKey     string
+	timeoutMin int // global codegen task timeout
 }
 
-func NewProjectService(db *gorm.DB) *ProjectService {
-	return &ProjectService{db: db}
+func NewProjectService(db *gorm.DB, aesKey string) *ProjectService {
+	return &ProjectService{db: db, aesKey: aesKey}
+}
+
+// SetTaskTimeout sets the global codegen task timeout, in minutes.
+func (s *ProjectService) SetTaskTimeout(minutes int) {
+	s.timeoutMin = minutes
+}
+
+// TaskTimeoutMinutes returns the codegen task timeout of a project with the
+// given sandbox policy: its override, else the global timeout.
+func (s *ProjectService) TaskTimeoutMinutes(p *model.SandboxPolicy) int {
+	if p != nil && p.TimeoutMinutes > 0 {
+		return p.TimeoutMinutes
+	}
+	return s.timeoutMin
 }
 
 func (s *ProjectService) Create(name, description string, ownerID uint, docLinks model.DocLinks, memberIDs []uint) (*model.Project, error) {
@@ -120,6 +139,51 @@ func (s *ProjectService) Update(id uint, updates map[string]interface{}) (*model
 	return s.GetByID(id)
 }
 
+// SetSigningKey stores the private key commits of the project are signed
+// with. The key must be usable without a passphrase.
+func (s *ProjectService) SetSigningKey(id uint, format, privateKey string) (*model.Project, error) {
+	project, err := s.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("40402:项目不存在")
+	}
+	keyID, publicKey, err := gitops.InspectSigningKey(context.Background(), gitops.SigningKey{Format: format, PrivateKey: privateKey})
+	if err != nil {
+		return nil, fmt.Errorf("40002:%s", err.Error())
+	}
+	encrypted, err := encrypt.AESEncrypt(s.aesKey, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("加密签名私钥失败: %w", err)
+	}
+
+	identity := model.CommitIdentity{}
+	if project.CommitIdentity.Data != nil {
+		identity.Mode = project.CommitIdentity.Data.Mode
+	}
+	identity.SigningFormat = format
+	identity.SigningKeyID = keyID
+	identity.SigningPublicKey = publicKey
+	return s.Update(id, map[string]interface{}{
+		"signing_key":     encrypted,
+		"commit_identity": model.JSONCommitIdentity{Data: &identity},
+	})
+}
+
+// ClearSigningKey stops signing the commits of the project.
+func (s *ProjectService) ClearSigningKey(id uint) (*model.Project, error) {
+	project, err := s.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("40402:项目不存在")
+	}
+	identity := model.CommitIdentity{}
+	if project.CommitIdentity.Data != nil {
+		identity.Mode = project.CommitIdentity.Data.Mode
+	}
+	return s.Update(id, map[string]interface{}{
+		"signing_key":     "",
+		"commit_identity": model.JSONCommitIdentity{Data: &identity},
+	})
+}
+
 func (s *ProjectService) Archive(id uint) error {
 	var runningCount int64
 	s.db.Model(&model.CodegenTask{}).
@@ -196,6 +260,11 @@ func (s *ProjectService) GetProjectStats(projectID uint) map[string]int64 {
 	return stats
 }
 
+// GetMonthlySpend returns the Claude cost of the project's codegen tasks this month.
+func (s *ProjectService) GetMonthlySpend(projectID uint) float64 {
+	return projectMonthlySpend(s.db, projectID, 0)
+}
+
 func (s *ProjectService) GetMemberCount(projectID uint) int64 {
 	var count int64
 	s.db.Model(&model.ProjectMember{}).Where("project_id = ?", projectID).Count(&count)
@@ -153,7 +153,7 @@ func (s *RequirementService) ListAccessible(userID uint, scope, status, keyword
 func (s *RequirementService) HasRunningTask(requirementID uint) bool {
 	var count int64
 	s.db.Model(&model.CodegenTask{}).
-		Where("requirement_id = ? AND status IN ?", requirementID, []string{"pending", "cloning", "running"}).
+		Where("requirement_id = ? AND status IN ?", requirementID, []string{"pending", "cloning", "running", "awaiting_approval"}).
 		Count(&count)
 	return count > 0
 }
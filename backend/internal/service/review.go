@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/codeMaster/backend/internal/agent"
+	"github.com/codeMaster/backend/internal/codegen"
 	"github.com/codeMaster/backend/internal/gitops"
 	"github.com/codeMaster/backend/internal/model"
 	"github.com/codeMaster/backend/internal/notify"
@@ -24,10 +26,10 @@ type ReviewService struct {
 	notifier   notify.Notifier
 }
 
-func NewReviewService(db *gorm.DB, aesKey, workDir string) *ReviewService {
+func NewReviewService(db *gorm.DB, aesKey, workDir string, backend agent.Backend) *ReviewService {
 	return &ReviewService{
 		db:         db,
-		aiReviewer: review.NewAIReviewer(db),
+		aiReviewer: review.NewAIReviewer(db, backend),
 		aesKey:     aesKey,
 		workDir:    workDir,
 	}
@@ -103,7 +105,9 @@ func (s *ReviewService) runAIReview(rev *model.CodeReview, task *model.CodegenTa
 	}
 
 	diffContent, _ := gitops.GetDiffContent(ctx, workDir, task.SourceBranch, task.TargetBranch, "")
-	s.aiReviewer.RunReview(ctx, rev, workDir, diffContent, apiKey, baseURL, modelName)
+	var project model.Project
+	s.db.Select("id", "tool_policy").First(&project, task.Repository.ProjectID)
+	s.aiReviewer.RunReview(ctx, rev, workDir, diffContent, apiKey, baseURL, modelName, project.ToolPolicy.Data)
 
 	// Notify after AI review completes
 	if s.notifier != nil {
@@ -292,7 +296,17 @@ func (s *ReviewService) CreateMergeRequest(reviewID uint, userID uint) (*model.C
 		}
 	}
 
-	title := fmt.Sprintf("feat(req-%d): %s", task.RequirementID, task.Requirement.Title)
+	// Project MR title template; the default is "feat(req-<id>): <title>"
+	requirement := task.Requirement
+	if requirement.AssigneeID != nil {
+		s.db.Preload("Assignee").First(requirement, requirement.ID)
+	}
+	var project model.Project
+	s.db.Select("id", "git_templates").First(&project, requirement.ProjectID)
+	title, err := codegen.RenderMRTitle(project.GitTemplates.Data, codegen.NewNamingVars(requirement, task.ID))
+	if err != nil {
+		return nil, fmt.Errorf("40002:%s", err.Error())
+	}
 	description := s.buildMRDescription(task, rev)
 
 	mrResult, err := gitops.CreateMergeRequest(gitops.MergeRequestInput{
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/codeMaster/backend/internal/secrets"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -24,13 +25,15 @@ type subscriber struct {
 
 type Hub struct {
 	mu          sync.RWMutex
-	subscribers map[int64][]*subscriber // taskID -> subscribers
+	subscribers map[int64][]*subscriber     // taskID -> subscribers
+	redactors   map[int64]*secrets.Redactor // taskID -> values injected into the task
 	rdb         *redis.Client
 }
 
 func NewHub(rdb *redis.Client) *Hub {
 	return &Hub{
 		subscribers: make(map[int64][]*subscriber),
+		redactors:   make(map[int64]*secrets.Redactor),
 		rdb:         rdb,
 	}
 }
@@ -60,10 +63,31 @@ func (h *Hub) Subscribe(taskID int64) (<-chan Event, func()) {
 	return sub.ch, unsub
 }
 
+// MaskValues makes every later event of the task mask values, e.g. the
+// tokens and API keys handed to its agent. Detected secrets are masked for
+// all tasks.
+func (h *Hub) MaskValues(taskID int64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.redactors[taskID] = secrets.NewRedactor(values...)
+}
+
+// ForgetValues drops the values registered with MaskValues.
+func (h *Hub) ForgetValues(taskID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.redactors, taskID)
+}
+
 func (h *Hub) Broadcast(taskID int64, event Event) {
 	ctx := context.Background()
 	key := fmt.Sprintf("codegen:stream:%d", taskID)
 
+	h.mu.RLock()
+	r := h.redactors[taskID]
+	h.mu.RUnlock()
+
+	event.Data = redact(r, event.Data)
 	data, _ := json.Marshal(event)
 	h.rdb.RPush(ctx, key, string(data))
 
@@ -79,6 +103,27 @@ func (h *Hub) Broadcast(taskID int64, event Event) {
 	}
 }
 
+// redact masks secrets in event data before it is stored or sent. Data is
+// normalized through JSON so typed payloads are scrubbed as well; it is only
+// replaced when something was masked.
+func redact(r *secrets.Redactor, data interface{}) interface{} {
+	if data == nil {
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return data
+	}
+	if masked, changed := r.Value(decoded); changed {
+		return masked
+	}
+	return data
+}
+
 func (h *Hub) ReplayFrom(taskID int64, fromID int64) ([]Event, error) {
 	ctx := context.Background()
 	key := fmt.Sprintf("codegen:stream:%d", taskID)
@@ -20,6 +20,10 @@ client.interceptors.request.use((config) => {
 // Response interceptor: unwrap data, handle 401
 client.interceptors.response.use(
   (response) => {
+    // File downloads are not wrapped in { code, data }
+    if (response.config.responseType === 'blob') {
+      return response.data;
+    }
     const data = response.data;
     if (data.code !== 0) {
       return Promise.reject(new Error(data.message || '请求失败'));
@@ -33,6 +37,18 @@ client.interceptors.response.use(
       window.location.href = '/login';
       return Promise.reject(new Error('登录已过期，请重新登录'));
     }
+    if (error.response?.data instanceof Blob) {
+      // Errors of file downloads arrive as a JSON blob
+      return error.response.data.text().then((text: string) => {
+        let message = '下载失败';
+        try {
+          message = JSON.parse(text).message || message;
+        } catch {
+          // not JSON
+        }
+        return Promise.reject(new Error(message));
+      });
+    }
     const message = error.response?.data?.message || error.message || '网络错误';
     return Promise.reject(new Error(message));
   }
@@ -1,5 +1,5 @@
 import client from './client';
-import type { CodeGenTask, DiffFile } from '@/types';
+import type { CodeGenTask, DiffFile, DiffFilePatch, DiffTreeNode, IterationCompare } from '@/types';
 
 export const codegenApi = {
   get(id: number): Promise<CodeGenTask> {
@@ -10,12 +10,46 @@ export const codegenApi = {
     return client.post(`/codegen/${id}/cancel`);
   },
 
-  getDiff(id: number, params?: { file?: string; format?: string }): Promise<{
+  revert(id: number, mode: 'reset' | 'revert' = 'reset'): Promise<{
+    id: number;
+    revert_mode: string;
+    from_commit: string;
+    to_commit: string;
+    previous_task_id?: number;
+  }> {
+    return client.post(`/codegen/${id}/revert`, { mode });
+  },
+
+  getDiff(id: number): Promise<{
     target_branch: string;
     base_branch: string;
-    files: DiffFile[];
+    files_changed?: number;
+    additions?: number;
+    deletions?: number;
+    files?: DiffFile[];
+    tree?: DiffTreeNode[];
   }> {
-    return client.get(`/codegen/${id}/diff`, { params });
+    return client.get(`/codegen/${id}/diff`);
+  },
+
+  getDiffFile(id: number, params: { path: string; hunk_offset?: number; hunk_limit?: number }): Promise<DiffFilePatch> {
+    return client.get(`/codegen/${id}/diff/file`, { params });
+  },
+
+  compare(id: number, baseTaskId?: number): Promise<IterationCompare> {
+    return client.get(`/codegen/${id}/compare`, { params: { base_task_id: baseTaskId } });
+  },
+
+  getCompareFile(
+    id: number,
+    params: { base_task_id?: number; path: string; hunk_offset?: number; hunk_limit?: number },
+  ): Promise<DiffFilePatch> {
+    return client.get(`/codegen/${id}/compare/file`, { params });
+  },
+
+  // git format-patch output / git bundle of the task's commits
+  download(id: number, kind: 'patch' | 'bundle'): Promise<Blob> {
+    return client.get(`/codegen/${id}/${kind}`, { responseType: 'blob', timeout: 300000 });
   },
 
   getLog(id: number, params?: { offset?: number; limit?: number }): Promise<{
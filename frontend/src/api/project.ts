@@ -1,5 +1,5 @@
 import client from './client';
-import type { Project, PaginatedResponse } from '@/types';
+import type { Project, PaginatedResponse, CommitIdentity, SigningFormat } from '@/types';
 
 export const projectApi = {
   list(params?: Record<string, unknown>): Promise<PaginatedResponse<Project>> {
@@ -22,6 +22,14 @@ export const projectApi = {
     return client.put(`/projects/${id}/archive`);
   },
 
+  setSigningKey(id: number, format: SigningFormat, privateKey: string): Promise<{ id: number; commit_identity: CommitIdentity }> {
+    return client.put(`/projects/${id}/signing-key`, { format, private_key: privateKey });
+  },
+
+  clearSigningKey(id: number): Promise<{ id: number; commit_identity: CommitIdentity }> {
+    return client.delete(`/projects/${id}/signing-key`);
+  },
+
   addMembers(id: number, userIds: number[], role: string): Promise<{ added: unknown[]; skipped: unknown[] }> {
     return client.post(`/projects/${id}/members`, { user_ids: userIds, role });
   },
@@ -1,5 +1,5 @@
 import client from './client';
-import type { Requirement, PaginatedResponse, SessionInfo } from '@/types';
+import type { Requirement, PaginatedResponse, SessionInfo, BranchSyncResult } from '@/types';
 
 export const requirementApi = {
   list(params?: Record<string, unknown>): Promise<PaginatedResponse<Requirement>> {
@@ -57,6 +57,37 @@ export const requirementApi = {
     return client.post(`/requirements/${id}/manual-submit`, data || {});
   },
 
+  // Upload a unified diff or git format-patch series; it is applied onto the requirement branch and pushed
+  manualSubmitPatch(id: number, patch: File, data?: { source_branch?: string; commit_message?: string }): Promise<{
+    task_id: number;
+    status: string;
+    source_branch: string;
+    target_branch: string;
+    commit_sha: string;
+  }> {
+    const form = new FormData();
+    form.append('patch', patch);
+    if (data?.source_branch) form.append('source_branch', data.source_branch);
+    if (data?.commit_message) form.append('commit_message', data.commit_message);
+    return client.post(`/requirements/${id}/manual-submit`, form, {
+      headers: { 'Content-Type': 'multipart/form-data' },
+      timeout: 600000,
+    });
+  },
+
+  // Bring the requirement branch up to date with its source branch; conflicts leave it untouched
+  syncBranch(id: number, data?: { strategy?: 'merge' | 'rebase'; source_branch?: string }): Promise<{
+    source_branch: string;
+    target_branch: string;
+    strategy: string;
+    status: BranchSyncResult['status'];
+    source_sha: string;
+    conflicts?: string[];
+    head: string;
+  }> {
+    return client.post(`/requirements/${id}/sync-branch`, data || {}, { timeout: 600000 });
+  },
+
   getCodegenTasks(id: number, params?: Record<string, unknown>): Promise<PaginatedResponse<unknown>> {
     return client.get(`/requirements/${id}/codegen-tasks`, { params });
   },
@@ -17,7 +17,7 @@ import {
   Play, Square, ArrowLeft, FileCode, Eye, ChevronDown, ChevronRight,
   Terminal, FileEdit, FilePlus, BookOpen, CheckCircle, XCircle, Clock, Loader2,
   Info, AlertTriangle, AlertCircle, GitBranch, Cpu, Upload, ExternalLink,
-  Search, FolderSearch, Wrench, ListTodo, ClipboardCheck, Users,
+  Search, FolderSearch, Wrench, ListTodo, ClipboardCheck, Users, Download,
 } from 'lucide-react';
 import ReactMarkdown from 'react-markdown';
 import remarkGfm from 'remark-gfm';
@@ -123,13 +123,28 @@ export function CodeGenPage() {
     if (!taskId) return;
     try {
       const data = await codegenApi.getDiff(taskId);
-      setDiffFiles(data.files);
+      setDiffFiles(data.files ?? []);
       setShowDiff(true);
     } catch (err) {
       toast({ title: '获取 Diff 失败', description: (err as Error).message, variant: 'destructive' });
     }
   };
 
+  const handleDownload = async (kind: 'patch' | 'bundle') => {
+    if (!taskId) return;
+    try {
+      const blob = await codegenApi.download(taskId, kind);
+      const url = URL.createObjectURL(blob);
+      const a = document.createElement('a');
+      a.href = url;
+      a.download = `task-${taskId}.${kind}`;
+      a.click();
+      URL.revokeObjectURL(url);
+    } catch (err) {
+      toast({ title: '下载失败', description: (err as Error).message, variant: 'destructive' });
+    }
+  };
+
   const handleOpenReviewDialog = async () => {
     if (!task?.requirement?.id) return;
     try {
@@ -479,6 +494,16 @@ export function CodeGenPage() {
                     </a>
                   )}
                 </div>
+                {task.status === 'completed' && task.commit_sha && (
+                  <div className="flex gap-2">
+                    <Button variant="outline" size="sm" className="flex-1" onClick={() => handleDownload('patch')}>
+                      <Download className="w-4 h-4 mr-1" />下载 Patch
+                    </Button>
+                    <Button variant="outline" size="sm" className="flex-1" onClick={() => handleDownload('bundle')}>
+                      <Download className="w-4 h-4 mr-1" />下载 Bundle
+                    </Button>
+                  </div>
+                )}
               </CardContent>
             </Card>
           )}
@@ -538,7 +563,7 @@ export function CodeGenPage() {
           <CardContent>
             <div className="space-y-4">
               {diffFiles.map((file) => (
-                <DiffFileBlock key={file.path} file={file} />
+                <DiffFileBlock key={file.path} taskId={taskId!} file={file} />
               ))}
             </div>
           </CardContent>
@@ -936,15 +961,37 @@ function OutputBlock({ output, prevTool }: { output: SSEOutputEvent; prevTool?:
 
 // ---- Diff file block ----
 
-function DiffFileBlock({ file }: { file: DiffFile }) {
-  const [expanded, setExpanded] = useState(true);
+// Patches are loaded when a file is expanded, a page of hunks at a time.
+function DiffFileBlock({ taskId, file }: { taskId: number; file: DiffFile }) {
+  const [expanded, setExpanded] = useState(false);
+  const [lines, setLines] = useState<string[]>([]);
+  const [nextOffset, setNextOffset] = useState<number | null>(0);
+  const [loading, setLoading] = useState(false);
+
+  const loadMore = async () => {
+    if (nextOffset === null || loading) return;
+    setLoading(true);
+    try {
+      const page = await codegenApi.getDiffFile(taskId, { path: file.path, hunk_offset: nextOffset });
+      const text = (nextOffset === 0 ? page.header : '') + page.hunks.join('');
+      setLines((prev) => [...prev, ...text.replace(/\n$/, '').split('\n')]);
+      setNextOffset(page.has_more ? page.hunk_offset + page.hunks.length : null);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const toggle = () => {
+    if (!expanded && nextOffset === 0) loadMore();
+    setExpanded(!expanded);
+  };
   const statusIcon = file.status === 'added' ? <FilePlus className="w-3.5 h-3.5 text-green-500" /> :
     file.status === 'deleted' ? <XCircle className="w-3.5 h-3.5 text-red-500" /> :
     <FileEdit className="w-3.5 h-3.5 text-yellow-500" />;
 
   return (
     <div className="border rounded-md overflow-hidden">
-      <button onClick={() => setExpanded(!expanded)}
+      <button onClick={toggle}
         className="w-full flex items-center gap-2 p-2 bg-muted/50 hover:bg-muted text-sm cursor-pointer">
         {expanded ? <ChevronDown className="w-4 h-4" /> : <ChevronRight className="w-4 h-4" />}
         {statusIcon}
@@ -954,9 +1001,9 @@ function DiffFileBlock({ file }: { file: DiffFile }) {
           <span className="text-red-500 ml-2">-{file.deletions}</span>
         </span>
       </button>
-      {expanded && file.diff && (
+      {expanded && lines.length > 0 && (
         <pre className="p-3 text-xs overflow-x-auto bg-background">
-          {file.diff.split('\n').map((line, i) => (
+          {lines.map((line, i) => (
             <div key={i} className={
               line.startsWith('+') ? 'bg-green-500/10 text-green-400' :
               line.startsWith('-') ? 'bg-red-500/10 text-red-400' :
@@ -967,6 +1014,12 @@ function DiffFileBlock({ file }: { file: DiffFile }) {
           ))}
         </pre>
       )}
+      {expanded && nextOffset !== null && nextOffset > 0 && (
+        <button onClick={loadMore} disabled={loading}
+          className="w-full p-1.5 text-[11px] text-primary hover:underline cursor-pointer border-t">
+          {loading ? '加载中...' : `加载更多 (${nextOffset} / ${file.hunk_total ?? '?'} 个片段)`}
+        </button>
+      )}
     </div>
   );
 }
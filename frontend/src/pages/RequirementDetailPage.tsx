@@ -72,7 +72,7 @@ export function RequirementDetailPage() {
   const [req, setReq] = useState<Requirement | null>(null);
   const [loading, setLoading] = useState(true);
   const [editOpen, setEditOpen] = useState(false);
-  const [editData, setEditData] = useState({ title: '', description: '', priority: '', assignee_id: '', repository_id: '', deadline: '' });
+  const [editData, setEditData] = useState({ title: '', description: '', priority: '', type: '', assignee_id: '', repository_id: '', deadline: '' });
   const [editDocLinks, setEditDocLinks] = useState<DocLink[]>([]);
   const [editDocResolving, setEditDocResolving] = useState<Record<number, boolean>>({});
   const [editDocErrors, setEditDocErrors] = useState<Record<number, string>>({});
@@ -94,6 +94,7 @@ export function RequirementDetailPage() {
   const [manualSubmitLoading, setManualSubmitLoading] = useState(false);
   const [manualCommitMessage, setManualCommitMessage] = useState('');
   const [manualCommitUrl, setManualCommitUrl] = useState('');
+  const [manualPatch, setManualPatch] = useState<File | null>(null);
   const [completeLoading, setCompleteLoading] = useState(false);
   const [closeLoading, setCloseLoading] = useState(false);
   const [reopenLoading, setReopenLoading] = useState(false);
@@ -156,6 +157,8 @@ export function RequirementDetailPage() {
   const canEdit = (isCreator || isAdmin) && (req.status === 'draft' || req.status === 'rejected');
   const canGenerate = (isAssignee || isAdmin) && req.status !== 'generating' && req.repository && req.assignee;
   const settingsMissing = settingsReady && (!settingsReady.apiKey || !settingsReady.gitToken);
+  // Named by the project's branch template once the first task runs
+  const branch = req.branch || `code-master/req-${req.id}`;
 
   const handleEdit = async () => {
     setEditLoading(true);
@@ -164,6 +167,7 @@ export function RequirementDetailPage() {
         title: editData.title,
         description: editData.description,
         priority: editData.priority,
+        type: editData.type,
       };
       if (editData.assignee_id) data.assignee_id = Number(editData.assignee_id);
       if (editData.repository_id) data.repository_id = Number(editData.repository_id);
@@ -201,20 +205,27 @@ export function RequirementDetailPage() {
   };
 
   const handleManualSubmit = async () => {
-    if (!manualCommitUrl.trim()) {
-      toast({ title: '请输入 Commit 链接', variant: 'destructive' });
+    if (!manualPatch && !manualCommitUrl.trim()) {
+      toast({ title: '请输入 Commit 链接或上传补丁', variant: 'destructive' });
       return;
     }
     setManualSubmitLoading(true);
     try {
-      await requirementApi.manualSubmit(req.id, {
-        commit_message: manualCommitMessage || undefined,
-        commit_url: manualCommitUrl,
-      });
+      if (manualPatch) {
+        await requirementApi.manualSubmitPatch(req.id, manualPatch, {
+          commit_message: manualCommitMessage || undefined,
+        });
+      } else {
+        await requirementApi.manualSubmit(req.id, {
+          commit_message: manualCommitMessage || undefined,
+          commit_url: manualCommitUrl,
+        });
+      }
       toast({ title: '手动提交成功', variant: 'success' });
       setManualSubmitOpen(false);
       setManualCommitMessage('');
       setManualCommitUrl('');
+      setManualPatch(null);
       fetchRequirement();
     } catch (err) {
       toast({ title: '提交失败', description: (err as Error).message, variant: 'destructive' });
@@ -391,6 +402,7 @@ export function RequirementDetailPage() {
                   title: req.title,
                   description: req.description,
                   priority: req.priority,
+                  type: req.type || 'feat',
                   assignee_id: req.assignee ? String(req.assignee.id) : '',
                   repository_id: req.repository ? String(req.repository.id) : '',
                   deadline: req.deadline ? req.deadline.split('T')[0] : '',
@@ -560,9 +572,9 @@ export function RequirementDetailPage() {
                 <div className="space-y-1">
                   <span className="text-xs text-muted-foreground">分支名</span>
                   <div className="flex items-center gap-2">
-                    <code className="text-sm bg-muted px-2 py-1 rounded flex-1 truncate">code-master/req-{req.id}</code>
+                    <code className="text-sm bg-muted px-2 py-1 rounded flex-1 truncate">{branch}</code>
                     <Button variant="ghost" size="icon" className="h-7 w-7 shrink-0"
-                      onClick={() => copyToClipboard(`code-master/req-${req.id}`)}>
+                      onClick={() => copyToClipboard(branch)}>
                       <Copy className="w-3.5 h-3.5" />
                     </Button>
                   </div>
@@ -571,10 +583,10 @@ export function RequirementDetailPage() {
                   <span className="text-xs text-muted-foreground">拉取远程分支（已存在）</span>
                   <div className="flex items-center gap-2">
                     <code className="text-xs bg-muted px-2 py-1 rounded flex-1 break-all leading-relaxed">
-                      git fetch origin && git checkout -b code-master/req-{req.id} origin/code-master/req-{req.id}
+                      git fetch origin && git checkout -b {branch} origin/{branch}
                     </code>
                     <Button variant="ghost" size="icon" className="h-7 w-7 shrink-0"
-                      onClick={() => copyToClipboard(`git fetch origin && git checkout -b code-master/req-${req.id} origin/code-master/req-${req.id}`)}>
+                      onClick={() => copyToClipboard(`git fetch origin && git checkout -b ${branch} origin/${branch}`)}>
                       <Copy className="w-3.5 h-3.5" />
                     </Button>
                   </div>
@@ -583,10 +595,10 @@ export function RequirementDetailPage() {
                   <span className="text-xs text-muted-foreground">新建本地分支（不存在）</span>
                   <div className="flex items-center gap-2">
                     <code className="text-xs bg-muted px-2 py-1 rounded flex-1 break-all leading-relaxed">
-                      git checkout -b code-master/req-{req.id}
+                      git checkout -b {branch}
                     </code>
                     <Button variant="ghost" size="icon" className="h-7 w-7 shrink-0"
-                      onClick={() => copyToClipboard(`git checkout -b code-master/req-${req.id}`)}>
+                      onClick={() => copyToClipboard(`git checkout -b ${branch}`)}>
                       <Copy className="w-3.5 h-3.5" />
                     </Button>
                   </div>
@@ -688,6 +700,21 @@ export function RequirementDetailPage() {
                 </SelectContent>
               </Select>
             </div>
+            <div className="space-y-2">
+              <label className="text-sm font-medium">类型</label>
+              <Select value={editData.type} onValueChange={(v) => setEditData({ ...editData, type: v })}>
+                <SelectTrigger><SelectValue /></SelectTrigger>
+                <SelectContent>
+                  <SelectItem value="feat">feat - 新功能</SelectItem>
+                  <SelectItem value="fix">fix - 缺陷修复</SelectItem>
+                  <SelectItem value="refactor">refactor - 重构</SelectItem>
+                  <SelectItem value="perf">perf - 性能优化</SelectItem>
+                  <SelectItem value="docs">docs - 文档</SelectItem>
+                  <SelectItem value="test">test - 测试</SelectItem>
+                  <SelectItem value="chore">chore - 杂项</SelectItem>
+                </SelectContent>
+              </Select>
+            </div>
             <div className="grid grid-cols-2 gap-4">
               <div className="space-y-2">
                 <label className="text-sm font-medium">指派人</label>
@@ -874,10 +901,10 @@ export function RequirementDetailPage() {
                     <span className="text-xs text-muted-foreground">拉取远程分支（已存在时）</span>
                     <div className="flex items-center gap-2">
                       <code className="text-xs bg-muted px-2 py-1.5 rounded flex-1 break-all leading-relaxed">
-                        git fetch origin && git checkout -b code-master/req-{req.id} origin/code-master/req-{req.id}
+                        git fetch origin && git checkout -b {branch} origin/{branch}
                       </code>
                       <Button variant="ghost" size="icon" className="h-7 w-7 shrink-0"
-                        onClick={() => copyToClipboard(`git fetch origin && git checkout -b code-master/req-${req.id} origin/code-master/req-${req.id}`)}>
+                        onClick={() => copyToClipboard(`git fetch origin && git checkout -b ${branch} origin/${branch}`)}>
                         <Copy className="w-3.5 h-3.5" />
                       </Button>
                     </div>
@@ -886,10 +913,10 @@ export function RequirementDetailPage() {
                     <span className="text-xs text-muted-foreground">新建本地分支（不存在时）</span>
                     <div className="flex items-center gap-2">
                       <code className="text-xs bg-muted px-2 py-1.5 rounded flex-1 break-all leading-relaxed">
-                        git checkout -b code-master/req-{req.id}
+                        git checkout -b {branch}
                       </code>
                       <Button variant="ghost" size="icon" className="h-7 w-7 shrink-0"
-                        onClick={() => copyToClipboard(`git checkout -b code-master/req-${req.id}`)}>
+                        onClick={() => copyToClipboard(`git checkout -b ${branch}`)}>
                         <Copy className="w-3.5 h-3.5" />
                       </Button>
                     </div>
@@ -935,10 +962,10 @@ export function RequirementDetailPage() {
                   </div>
                   <div className="flex items-center gap-2">
                     <code className="text-xs bg-muted px-2 py-1.5 rounded flex-1 break-all leading-relaxed">
-                      git add . && git commit -m "feat: req-{req.id}" && git push origin code-master/req-{req.id}
+                      git add . && git commit -m "feat: req-{req.id}" && git push origin {branch}
                     </code>
                     <Button variant="ghost" size="icon" className="h-7 w-7 shrink-0"
-                      onClick={() => copyToClipboard(`git add . && git commit -m "feat: req-${req.id}" && git push origin code-master/req-${req.id}`)}>
+                      onClick={() => copyToClipboard(`git add . && git commit -m "feat: req-${req.id}" && git push origin ${branch}`)}>
                       <Copy className="w-3.5 h-3.5" />
                     </Button>
                   </div>
@@ -972,16 +999,22 @@ export function RequirementDetailPage() {
           <DialogHeader>
             <DialogTitle>手动提交代码</DialogTitle>
             <DialogDescription>
-              请先将代码推送到 <code className="bg-muted px-1.5 py-0.5 rounded text-xs">code-master/req-{req.id}</code> 分支，然后粘贴 Commit 链接
+              将代码推送到 <code className="bg-muted px-1.5 py-0.5 rounded text-xs">{branch}</code> 分支后粘贴 Commit 链接，或上传基于源分支的补丁由系统应用并推送
             </DialogDescription>
           </DialogHeader>
           <div className="space-y-4 py-4">
             <div className="space-y-2">
-              <label className="text-sm font-medium">Commit 链接 <span className="text-destructive">*</span></label>
-              <Input placeholder="粘贴 Git 仓库的 Commit URL..." value={manualCommitUrl}
+              <label className="text-sm font-medium">Commit 链接</label>
+              <Input placeholder="粘贴 Git 仓库的 Commit URL..." value={manualCommitUrl} disabled={!!manualPatch}
                 onChange={(e) => setManualCommitUrl(e.target.value)} />
               <p className="text-xs text-muted-foreground">例如：https://gitlab.com/group/repo/-/commit/abc123</p>
             </div>
+            <div className="space-y-2">
+              <label className="text-sm font-medium">或上传补丁</label>
+              <Input type="file" accept=".patch,.diff,text/x-patch,text/x-diff,text/plain"
+                onChange={(e) => setManualPatch(e.target.files?.[0] ?? null)} />
+              <p className="text-xs text-muted-foreground">支持 git diff 输出或 git format-patch 生成的补丁 (最大 10MB)</p>
+            </div>
             <div className="space-y-2">
               <label className="text-sm font-medium">提交说明（可选）</label>
               <Textarea placeholder="描述你的代码变更..." value={manualCommitMessage}
@@ -990,7 +1023,7 @@ export function RequirementDetailPage() {
           </div>
           <DialogFooter>
             <Button variant="outline" onClick={() => setManualSubmitOpen(false)}>取消</Button>
-            <Button onClick={handleManualSubmit} disabled={manualSubmitLoading || !manualCommitUrl.trim()}>
+            <Button onClick={handleManualSubmit} disabled={manualSubmitLoading || (!manualPatch && !manualCommitUrl.trim())}>
               {manualSubmitLoading ? <><Spinner size="sm" className="mr-2" />提交中...</> : <><Upload className="w-4 h-4 mr-1" />确认提交</>}
             </Button>
           </DialogFooter>
@@ -73,10 +73,39 @@ export interface Project {
   requirement_count?: number;
   open_requirement_count?: number;
   status: 'active' | 'archived';
+  branch_sync?: BranchSyncConfig;
+  git_templates?: GitTemplates;
+  commit_policy?: CommitPolicy;
+  commit_identity?: CommitIdentity;
   created_at: string;
   updated_at: string;
 }
 
+export interface GitTemplates {
+  branch?: string;
+  commit?: string;
+  mr_title?: string;
+}
+
+export interface CommitPolicy {
+  split?: '' | 'module' | 'agent';
+  generate_messages?: boolean;
+}
+
+export interface CommitIdentity {
+  mode?: 'bot' | 'author' | 'co_author';
+  signing_format?: SigningFormat;
+  signing_key_id?: string;
+  signing_public_key?: string;
+}
+
+export type SigningFormat = 'ssh' | 'openpgp';
+
+export interface BranchSyncConfig {
+  strategy?: '' | 'merge' | 'rebase';
+  on_conflict?: 'report' | 'agent';
+}
+
 // ============ Repository ============
 
 export interface Repository {
@@ -116,6 +145,7 @@ export interface ConnectionTestResult {
 
 export type RequirementStatus = 'draft' | 'generating' | 'generated' | 'reviewing' | 'approved' | 'merged' | 'rejected' | 'completed' | 'closed';
 export type Priority = 'p0' | 'p1' | 'p2' | 'p3';
+export type RequirementType = 'feat' | 'fix' | 'refactor' | 'perf' | 'docs' | 'test' | 'chore';
 
 export interface Requirement {
   id: number;
@@ -125,12 +155,14 @@ export interface Requirement {
   doc_links?: DocLink[];
   doc_content_status?: string;
   priority: Priority;
+  type?: RequirementType;
   status: RequirementStatus;
   deadline?: string | null;
   creator: { id: number; name: string; avatar: string };
   assignee: { id: number; name: string; avatar: string } | null;
   repository: { id: number; name: string; platform?: string; git_url?: string } | null;
   codegen_tasks?: CodeGenTask[];
+  branch?: string;
   latest_codegen?: { id: number; status: string; created_at: string } | null;
   latest_review?: { id: number; ai_score: number; human_status: string } | null;
   created_at: string;
@@ -150,13 +182,66 @@ export interface DiffStat {
 
 export interface DiffFile {
   path: string;
-  status: 'added' | 'modified' | 'deleted';
+  status: 'added' | 'modified' | 'deleted' | 'renamed' | 'copied' | 'type_changed';
+  old_path?: string;
+  similarity?: number;
+  binary?: boolean;
+  old_mode?: string;
+  new_mode?: string;
   language?: string;
   additions: number;
   deletions: number;
+  hunk_total?: number;
   diff?: string;
 }
 
+export interface DiffFilePatch extends DiffFile {
+  header: string;
+  hunks: string[];
+  hunk_total: number;
+  hunk_offset: number;
+  hunk_limit: number;
+  has_more: boolean;
+}
+
+export interface DiffTreeNode {
+  name: string;
+  path: string;
+  type: 'dir' | 'file';
+  status?: DiffFile['status'];
+  files?: number;
+  additions: number;
+  deletions: number;
+  children?: DiffTreeNode[];
+}
+
+export interface IterationCompare {
+  base_task_id: number;
+  head_task_id: number;
+  base_commit: string;
+  head_commit: string;
+  base_from: 'specified' | 'review' | 'previous';
+  files_changed: number;
+  additions: number;
+  deletions: number;
+  files: DiffFile[];
+  tree: DiffTreeNode[];
+}
+
+export interface BranchSyncResult {
+  strategy: 'merge' | 'rebase';
+  status: 'up_to_date' | 'updated' | 'conflict' | 'resolved';
+  source_sha?: string;
+  branch_sha?: string;
+  conflicts?: string[];
+}
+
+export interface TaskCommit {
+  sha: string;
+  message: string;
+  files?: string[];
+}
+
 export interface CodeGenTask {
   id: number;
   requirement?: { id: number; title: string };
@@ -168,10 +253,17 @@ export interface CodeGenTask {
   prompt?: string;
   diff_stat?: DiffStat;
   commit_sha?: string;
+  base_sha?: string;
+  commits?: TaskCommit[];
+  branch_sync?: BranchSyncResult;
   error_message?: string;
   claude_cost_usd?: number;
   session_id?: string;
   resume_task_id?: number;
+  revert_mode?: 'reset' | 'revert';
+  revert_sha?: string;
+  reverted_by?: number;
+  reverted_at?: string;
   review?: {
     id: number;
     ai_score: number;